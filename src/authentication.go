@@ -99,6 +99,24 @@ func basicAuth(r *http.Request, level string) (username string, err error) {
 	return
 }
 
+/*
+getStreamUser returns the user of a stream request with valid credentials (basic authentication or the username and
+password parameters). Streams don't require authentication, the user is only written to the session history.
+*/
+func getStreamUser(r *http.Request) string {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		username, password = r.URL.Query().Get("username"), r.URL.Query().Get("password")
+	}
+	if username == "" {
+		return ""
+	}
+	if _, err := authentication.UserAuthentication(username, password); err != nil {
+		return ""
+	}
+	return username
+}
+
 func urlAuth(r *http.Request, requestType string) (err error) {
 	var level, token string
 
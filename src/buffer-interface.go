@@ -4,6 +4,9 @@ import "io"
 
 type BufferInterface interface {
 	StartBuffer(stream *Stream) error
+    StartInput(stream *Stream) error
+    StopInput()
+    GetActivity() *InputActivity
     StopBuffer()
     CloseBuffer()
     HandleByteOutput(stdOut io.ReadCloser)
@@ -17,6 +20,10 @@ type BufferInterface interface {
     writeToPipe(file string) error
     writeBytesToPipe(data []byte) error
     GetPipeReader() *io.PipeReader
+    GetPipeWriter() *io.PipeWriter
     GetStopChan() chan struct{}
     SetStopChan(chan struct{})
+    GetSegments() *SegmentList
+    GetPipeSequence() int
+    ReadSegment(sequence int) ([]byte, error)
 }
\ No newline at end of file
@@ -2,15 +2,18 @@ package src
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"threadfin/src/internal/mpegts"
+
 	"github.com/avfs/avfs"
 )
 
@@ -25,6 +28,10 @@ type StreamBuffer struct {
 	OldSegments        []string
 	PipeWriter         *io.PipeWriter
 	PipeReader         *io.PipeReader
+	Segments           *SegmentList
+	Activity           *InputActivity
+	inputCancel        context.CancelFunc
+	pipeSequence       int64 // Latest segment written to the pipe, accessed atomically
 }
 
 const (
@@ -38,10 +45,17 @@ const (
 	FileStatError         = 4015 //errMsg = "Could not get file statics of buffered file"
 	ReadFileError         = 4016 //errMsg = "Could not read buffered file before sending to clients"
 	FileDoesNotExistError = 4019 //errMsg = "Buffered file does not exist anymore"
+	SlowClientError       = 4024 //errMsg = "Client could not keep up with the stream"
 )
 
+// KeepSegments is the number of already broadcasted segments that stay in the buffer folder
+const KeepSegments = 6
+
 func (sb *StreamBuffer) StartBuffer(stream *Stream) error {
 	sb.Stream = stream
+	sb.Segments.Clear()
+	sb.OldSegments = nil
+	atomic.StoreInt64(&sb.pipeSequence, -1)
 	if err := sb.PrepareBufferFolder(filepath.Join(stream.Folder, "0.ts")); err != nil {
 		// If something went wrong when setting up the buffer storage don't run at all
 		stream.ReportError(err, BufferFolderError, "", true)
@@ -50,9 +64,52 @@ func (sb *StreamBuffer) StartBuffer(stream *Stream) error {
 	return nil
 }
 
+/*
+StartInput connects the buffer to the upstream. The plain StreamBuffer has no upstream.
+*/
+func (sb *StreamBuffer) StartInput(stream *Stream) error {
+	sb.Stream = stream
+	sb.Activity.reset()
+	return nil
+}
+
+/*
+StopInput disconnects the buffer from the upstream, the buffered segments are kept
+*/
+func (sb *StreamBuffer) StopInput() {
+	done := sb.Activity.stop()
+	if sb.inputCancel != nil {
+		sb.inputCancel()
+	}
+	waitForInput(done)
+}
+
+func (sb *StreamBuffer) GetActivity() *InputActivity {
+	return sb.Activity
+}
+
+/*
+inputFailed reports the error of the upstream. With an active watchdog the error is handled there.
+*/
+func (sb *StreamBuffer) inputFailed(err error, errCode int) {
+	if !sb.Activity.fail(err, errCode) {
+		// The input has been stopped on purpose
+		return
+	}
+	if Settings.BufferStallTimeout <= 0 {
+		sb.Stream.ReportError(err, errCode, "", true)
+	}
+}
+
+/*
+StopBuffer stops writing the buffered segments to the pipe. It can be called more than once, the stream manager
+calls it under its lock. A reactivated stream gets a new stop channel that is closed by the next call.
+*/
 func (sb *StreamBuffer) StopBuffer() {
-	if !sb.Stopped {
-		sb.Stopped = true
+	sb.Stopped = true
+	select {
+	case <-sb.StopChan:
+	default:
 		close(sb.StopChan)
 	}
 }
@@ -68,24 +125,35 @@ func (sb *StreamBuffer) CloseBuffer() {
 	}
 }
 
+// bufferSize returns the buffer size of the stream in KB
+func (sb *StreamBuffer) bufferSize() int {
+	if sb.Stream != nil && sb.Stream.BufferConfig.Size > 0 {
+		return sb.Stream.BufferConfig.Size
+	}
+	return Settings.BufferSize
+}
+
 func (sb *StreamBuffer) GetPipeReader() *io.PipeReader{
 	return sb.PipeReader
 }
 
+func (sb *StreamBuffer) GetPipeWriter() *io.PipeWriter{
+	return sb.PipeWriter
+}
+
 func (sb *StreamBuffer) GetStopChan() chan struct{} {
 	return sb.StopChan
 }
 
 func (sb *StreamBuffer) SetStopChan(stopChan chan struct{}) {
-	sb.StopChan = stopChan
+	sb.StopChan, sb.Stopped = stopChan, false
 }
 
 /*
 HandleByteOutput save the byte ouptut of the command or http request as files
 */
 func (sb *StreamBuffer) HandleByteOutput(stdOut io.ReadCloser) {
-	TS_PACKAGE_MIN_SIZE := 188
-	bufferSize := Settings.BufferSize * 1024 // in bytes
+	bufferSize := sb.bufferSize() * 1024 // in bytes
 	buffer := make([]byte, bufferSize)
 	var fileSize int
 	init := true
@@ -96,6 +164,13 @@ func (sb *StreamBuffer) HandleByteOutput(stdOut io.ReadCloser) {
 	var f avfs.File
 	var err error
 	var tmpFile string
+	var timestamps mpegts.Timestamps
+	var segmentStarted time.Time
+	var lastPTS int64
+	var hasPTS bool
+	done := sb.Activity.begin()
+	defer close(done)
+	defer stdOut.Close()
 	reader := bufio.NewReader(stdOut)
 	for {
 		select {
@@ -112,33 +187,64 @@ func (sb *StreamBuffer) HandleByteOutput(stdOut io.ReadCloser) {
 					sb.Stream.ReportError(err, CreateFileError, "", true)
 					return
 				}
+				segmentStarted = time.Now()
 				init = false
 			}
 			n, err := reader.Read(buffer)
+			sb.Activity.read(n)
+			metrics.streamBytes(sb.Stream, n)
 			if n == 0 && err == nil {
 				continue
 			}
 			if err == io.EOF {
 				f.Close()
 				ShowDebug("Buffer reached EOF!", 3)
-				sb.Stream.ReportError(err, EndOfFileError, "", true)
+				sb.inputFailed(err, EndOfFileError)
 				return
 			}
 			if err != nil {
 				f.Close()
 				bufferVFS.Remove(tmpFile)
-				sb.Stream.ReportError(err, ReadIntoBufferError, "", true)
+				sb.inputFailed(err, ReadIntoBufferError)
 				return
 			}
-			if _, err := f.Write(buffer[:n]); err != nil {
-				f.Close()
-				bufferVFS.Remove(tmpFile)
-				sb.Stream.ReportError(err, WriteToBufferError, "", true)
-				return
-			}
-			fileSize += n
-			// Check if the file size exceeds the threshold
-			if fileSize >= TS_PACKAGE_MIN_SIZE*1024 {
+			data := buffer[:n]
+			for len(data) > 0 {
+				chunk := data
+				if fileSize+len(chunk) > SegmentSize {
+					chunk = data[:SegmentSize-fileSize]
+				}
+				if _, err := f.Write(chunk); err != nil {
+					f.Close()
+					bufferVFS.Remove(tmpFile)
+					sb.Stream.ReportError(err, WriteToBufferError, "", true)
+					return
+				}
+				timestamps.Write(chunk)
+				fileSize += len(chunk)
+				data = data[len(chunk):]
+
+				// Check if the file size reached the threshold
+				if fileSize < SegmentSize {
+					continue
+				}
+
+				duration := time.Since(segmentStarted)
+				if pts, ok := timestamps.Last(); ok {
+					if hasPTS {
+						duration = mpegts.Since(lastPTS, pts)
+					} else if d, ok := timestamps.Duration(); ok {
+						duration = d
+					}
+					lastPTS, hasPTS = pts, true
+				}
+				if duration <= 0 || duration > time.Minute {
+					// Timestamps jumped (discontinuity), use the wall clock instead
+					duration = time.Since(segmentStarted)
+				}
+				sb.Segments.Add(Segment{Sequence: tmpSegment, Duration: duration, Size: fileSize, Created: time.Now()})
+				sb.enforceQuota()
+
 				tmpSegment++
 				tmpFile = fmt.Sprintf("%s%d.ts", tmpFolder, tmpSegment)
 				// Close the current file and create a new one
@@ -150,6 +256,7 @@ func (sb *StreamBuffer) HandleByteOutput(stdOut io.ReadCloser) {
 					sb.Stream.ReportError(err, CreateFileError, "", true)
 					return
 				}
+				segmentStarted = time.Now()
 				fileSize = 0
 			}
 		}
@@ -157,6 +264,7 @@ func (sb *StreamBuffer) HandleByteOutput(stdOut io.ReadCloser) {
 }
 
 func (sb *StreamBuffer) RemoveBufferedFiles(folder string) error {
+	sb.Segments.Clear()
 	test := filepath.Dir(folder)
 	if err := sb.FileSystem.RemoveAll(test); err != nil {
 		return fmt.Errorf("failed to remove buffer folder: %w", err)
@@ -223,72 +331,26 @@ func (sb *StreamBuffer) createBufferFolder(path string) (err error) {
 }
 
 /*
-GetBufTmpFiles retrieves the files within the buffer folder
-and returns a sorted list with the file names
+GetBufTmpFiles returns the completed segments that have not been sent to the pipe yet,
+sorted by their sequence number
 */
 func (sb *StreamBuffer) GetBufTmpFiles() (tmpFiles []string) {
+	var lastSequence = -1
+	if len(sb.OldSegments) > 0 {
+		lastSequence, _ = strconv.Atoi(strings.TrimSuffix(sb.OldSegments[len(sb.OldSegments)-1], ".ts"))
+	}
 
-	var tmpFolder = sb.Stream.Folder
-	var fileIDs []float64
-
-	if _, err := sb.FileSystem.Stat(tmpFolder); !fsIsNotExistErr(err) {
-		files, err := sb.FileSystem.ReadDir(tmpFolder)
-		if err != nil {
-			ShowError(err, 000)
-			return
-		}
-
-		// Check if more then one file is available
-		if len(files) >= 1 {
-			// Iterate over the files and collect the IDs
-			for _, file := range files {
-				if !file.IsDir() && filepath.Ext(file.Name()) == ".ts" {
-					fileID := strings.TrimSuffix(file.Name(), ".ts")
-					if f, err := strconv.ParseFloat(fileID, 64); err == nil {
-						fileIDs = append(fileIDs, f)
-					}
-				}
-			}
-
-			sort.Float64s(fileIDs)
-			if len(fileIDs) > 0 {
-				fileIDs = fileIDs[:len(fileIDs)-1]
-			}
-
-			// Create the return array with the sorted files
-			for _, file := range fileIDs {
-				fileName := fmt.Sprintf("%.0f.ts", file)
-				// Check if the file is already within old segments array
-				if !ContainsString(sb.OldSegments, fileName) {
-					tmpFiles = append(tmpFiles, fileName)
-					sb.OldSegments = append(sb.OldSegments, fileName)
-				}
-			}
-		}
+	for _, segment := range sb.Segments.After(lastSequence) {
+		fileName := fmt.Sprintf("%d.ts", segment.Sequence)
+		tmpFiles = append(tmpFiles, fileName)
+		sb.OldSegments = append(sb.OldSegments, fileName)
 	}
 	return
 }
 
+// GetBufferedSize returns the size of the completed segments within the buffer folder
 func (sb *StreamBuffer) GetBufferedSize() (size int) {
-	size = 0
-	var tmpFolder = sb.Stream.Folder
-	if _, err := sb.FileSystem.Stat(tmpFolder); !fsIsNotExistErr(err) {
-
-		files, err := sb.FileSystem.ReadDir(tmpFolder)
-		if err != nil {
-			ShowError(err, 000)
-			return
-		}
-		for _, file := range files {
-			if !file.IsDir() && filepath.Ext(file.Name()) == ".ts" {
-				file_info, err := sb.FileSystem.Stat(filepath.Join(tmpFolder, file.Name()))
-				if err == nil {
-					size += int(file_info.Size())
-				}
-			}
-		}
-	}
-	return size
+	return sb.Segments.Size()
 }
 
 func (sb *StreamBuffer) addBufferedFilesToPipe() {
@@ -297,17 +359,24 @@ func (sb *StreamBuffer) addBufferedFilesToPipe() {
 		case <-sb.StopChan:
 			return
 		default:
-			if sb.GetBufferedSize() < Settings.BufferSize * 1024 {
+			if sb.GetBufferedSize() < sb.bufferSize() * 1024 {
 				time.Sleep(25 * time.Millisecond) // Wait for new files
 				continue
 			}
 			tmpFiles := sb.GetBufTmpFiles()
+			if len(tmpFiles) == 0 {
+				time.Sleep(25 * time.Millisecond) // Wait for new files
+				continue
+			}
 			for _, f := range tmpFiles {
 				if ok, err := sb.CheckBufferFolder(); !ok {
 					sb.Stream.ReportError(err, BufferFolderError, "", true)
 					return
 				}
 				ShowDebug(fmt.Sprintf("Streaming:Broadcasting file %s to clients", f), 1)
+				if sequence, err := strconv.Atoi(strings.TrimSuffix(f, ".ts")); err == nil {
+					atomic.StoreInt64(&sb.pipeSequence, int64(sequence))
+				}
 				err := sb.writeToPipe(f) // Add file so it will be copied to the pipes
 				if err != nil {
 					sb.Stream.ReportError(err, 0, "", false)
@@ -319,16 +388,59 @@ func (sb *StreamBuffer) addBufferedFilesToPipe() {
 }
 
 /*
-DeleteOldesSegment will delete the file provided in the buffer
+DeleteOldesSegment will delete the oldest file that has already been sent to the clients.
+The latest KeepSegments files are kept, so a client that comes back can resume in them.
+With an enabled time-shift window all segments within the window are kept.
 */
 func (sb *StreamBuffer) DeleteOldestSegment() {
+	if len(sb.OldSegments) <= KeepSegments {
+		return
+	}
+	sequence, err := strconv.Atoi(strings.TrimSuffix(sb.OldSegments[0], ".ts"))
+	if _, exists := sb.Segments.Get(sequence); err == nil && !exists {
+		// The segment has already been removed by the buffer quota
+		sb.OldSegments = sb.OldSegments[1:]
+		return
+	}
+	if window := time.Duration(Settings.BufferTimeshift) * time.Minute; window > 0 && err == nil {
+		if sb.Segments.DurationAfter(sequence) < window {
+			return
+		}
+	}
+	if err == nil && sb.Segments.DurationAfter(sequence) < clientSessionGrace() && sb.Stream.segmentInUse(sequence) {
+		// A resumed client still reads the segment, it is kept for the grace window at most.
+		// Afterwards the client skips to the oldest segment like a paused time-shift client.
+		return
+	}
 	fileToRemove := filepath.Join(sb.Stream.Folder, sb.OldSegments[0])
 	if err := sb.FileSystem.Remove(fileToRemove); err != nil {
 		ShowError(err, 4007)
 	}
+	if err == nil {
+		sb.Segments.Remove(sequence)
+	}
 	sb.OldSegments = sb.OldSegments[1:]
 }
 
+// ReadSegment returns the content of the completed segment with the given sequence number
+func (sb *StreamBuffer) ReadSegment(sequence int) ([]byte, error) {
+	f, err := sb.FileSystem.Open(filepath.Join(sb.Stream.Folder, fmt.Sprintf("%d.ts", sequence)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (sb *StreamBuffer) GetSegments() *SegmentList {
+	return sb.Segments
+}
+
+// GetPipeSequence returns the latest segment that has been written to the pipe, -1 if there is none
+func (sb *StreamBuffer) GetPipeSequence() int {
+	return int(atomic.LoadInt64(&sb.pipeSequence))
+}
+
 /*
 CheckBufferFolder reports whether the buffer folder exists.
 */
@@ -349,6 +461,10 @@ func (sb *StreamBuffer) CheckBufferedFile(file string) (bool, error) {
 
 func (sb *StreamBuffer) writeToPipe(file string) error {
 	f, err := sb.FileSystem.Open(filepath.Join(sb.Stream.Folder, file))
+	if fsIsNotExistErr(err) {
+		ShowDebug(fmt.Sprintf("Streaming:Segment %s has been removed by the buffer quota", file), 3)
+		return nil
+	}
     if err != nil {
         return err
     }
@@ -0,0 +1,165 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Keys of the buffer override in the provider data (Settings.Files.M3U / HDHR)
+const (
+	providerBuffer        = "buffer"         // -, threadfin, ffmpeg, vlc or command, empty uses the global setting
+	providerBufferOptions = "buffer.options" // FFmpeg, VLC or command arguments
+	providerBufferSize    = "buffer.size.kb"
+)
+
+// BufferConfig is the buffer used for a stream, the global settings overridden by the provider and the XEPG channel
+type BufferConfig struct {
+	Type    string // -, threadfin, ffmpeg, vlc or command
+	Options string // Arguments of FFmpeg, VLC or the command
+	Size    int    // Buffer size in KB
+}
+
+func isBufferType(bufferType string) bool {
+	switch bufferType {
+	case "-", "threadfin", "ffmpeg", "vlc", "command":
+		return true
+	}
+	return false
+}
+
+// getDefaultBufferOptions returns the global arguments of the third party buffer
+func getDefaultBufferOptions(bufferType string) string {
+	switch bufferType {
+	case "ffmpeg":
+		return Settings.FFmpegOptions
+	case "vlc":
+		return Settings.VLCOptions
+	case "command":
+		return Settings.CommandOptions
+	}
+	return ""
+}
+
+/*
+applyBufferOverride overrides the buffer config with the values that are set.
+The options of another buffer type don't fit, so they are reset to the global options of the new type.
+*/
+func (c *BufferConfig) applyBufferOverride(bufferType, options string, size int) {
+	if isBufferType(bufferType) && bufferType != c.Type {
+		c.Type = bufferType
+		c.Options = getDefaultBufferOptions(bufferType)
+	}
+	if options != "" {
+		c.Options = options
+	}
+	if size > 0 {
+		c.Size = size
+	}
+}
+
+// getProviderBufferOverride returns the buffer override of the playlist
+func getProviderBufferOverride(playlistID string) (bufferType, options string, size int) {
+	var data = getProviderSettings(playlistID)
+	bufferType, _ = data[providerBuffer].(string)
+	options, _ = data[providerBufferOptions].(string)
+	switch value := data[providerBufferSize].(type) {
+	case float64:
+		size = int(value)
+	case string:
+		size, _ = strconv.Atoi(value)
+	}
+	return
+}
+
+// resolvedChannel is the XEPG channel of a stream info, resolved once for the request of a client
+type resolvedChannel struct {
+	channel XEPGChannelStruct
+	found   bool
+}
+
+/*
+withChannel returns a copy of the stream info with its XEPG channel. The stream setup reads the channel several
+times (buffer, transcoding profile, radio), the copy doesn't look it up again. The cached stream info is not changed.
+*/
+func (s *StreamInfo) withChannel() *StreamInfo {
+	var info = *s
+	info.channel = nil
+	channel, found := getStreamChannel(&info)
+	info.channel = &resolvedChannel{channel: channel, found: found}
+	return &info
+}
+
+/*
+getStreamChannel returns the active XEPG channel of the stream. Stream infos without XEPG channel
+are matched by the URL, the playlist and the URL are compared before the channel is decoded.
+*/
+func getStreamChannel(streamInfo *StreamInfo) (xepgChannel XEPGChannelStruct, found bool) {
+	if streamInfo.channel != nil {
+		return streamInfo.channel.channel, streamInfo.channel.found
+	}
+
+	if streamInfo.XEPG != "" {
+		dxc, ok := Data.XEPG.Channels[streamInfo.XEPG]
+		if !ok {
+			return XEPGChannelStruct{}, false
+		}
+		if err := json.Unmarshal([]byte(mapToJSON(dxc)), &xepgChannel); err != nil || !xepgChannel.XActive || xepgChannel.FileM3UID != streamInfo.PlaylistID {
+			return XEPGChannelStruct{}, false
+		}
+		return xepgChannel, true
+	}
+
+	var urlID = streamInfo.channelURLid()
+	for _, dxc := range Data.XEPG.Channels {
+		channel, ok := dxc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		playlistID, _ := channel["_file.m3u.id"].(string)
+		channelURL, _ := channel["url"].(string)
+		if playlistID != streamInfo.PlaylistID || getMD5(fmt.Sprintf("%s-%s", playlistID, channelURL)) != urlID {
+			continue
+		}
+		if err := json.Unmarshal([]byte(mapToJSON(dxc)), &xepgChannel); err != nil || !xepgChannel.XActive {
+			continue
+		}
+		return xepgChannel, true
+	}
+	return XEPGChannelStruct{}, false
+}
+
+// getChannelBufferOverride returns the buffer override of the XEPG channel of the stream
+func getChannelBufferOverride(streamInfo *StreamInfo) (bufferType, options string, size int) {
+	if xepgChannel, found := getStreamChannel(streamInfo); found {
+		size, _ = strconv.Atoi(xepgChannel.XBufferSize)
+		return xepgChannel.XBuffer, xepgChannel.XBufferOptions, size
+	}
+	return
+}
+
+/*
+getBufferConfig returns the buffer of the stream. The XEPG channel (x-buffer, x-buffer-options, x-buffer-size)
+takes precedence over the provider (buffer, buffer.options, buffer.size.kb), unset values fall back to the global settings.
+A transcoding profile of the stream always runs with FFmpeg.
+*/
+func getBufferConfig(streamInfo *StreamInfo) BufferConfig {
+	var config = BufferConfig{Type: Settings.Buffer, Options: getDefaultBufferOptions(Settings.Buffer), Size: Settings.BufferSize}
+	if streamInfo == nil || streamInfo.PlaylistID == "" {
+		return config
+	}
+	config.applyBufferOverride(getProviderBufferOverride(streamInfo.PlaylistID))
+	config.applyBufferOverride(getChannelBufferOverride(streamInfo))
+	if profile, ok := getTranscodingProfile(streamInfo.Profile); ok && streamInfo.Profile != "" {
+		config.applyBufferOverride("ffmpeg", profile.Options, 0)
+	}
+	return config
+}
+
+// getPlaylistBufferType returns the buffer type of the playlist, the global setting overridden by the provider
+func getPlaylistBufferType(playlistID string) string {
+	if bufferType, _, _ := getProviderBufferOverride(playlistID); isBufferType(bufferType) {
+		return bufferType
+	}
+	return Settings.Buffer
+}
@@ -0,0 +1,176 @@
+package src
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"threadfin/src/internal/mpegts"
+)
+
+const (
+	BufferQuotaError = 4028 //errMsg = "Buffer quota reached, no new stream possible"
+	DiskSpaceError   = 4029 //errMsg = "Not enough free space in the temp folder"
+)
+
+// SegmentSize is the size of a buffered segment, a multiple of the TS packet size so every segment starts at a packet boundary
+const SegmentSize = mpegts.PacketSize * 1024
+
+var (
+	bufferedBytes   atomic.Int64 // Size of the buffered segments and HLS segments of all streams
+	bufferedStreams atomic.Int64 // Number of streams with buffered segments
+)
+
+// BufferUsageStruct is returned by the API command getBufferUsage
+type BufferUsageStruct struct {
+	UsedBytes        int64     `json:"usedBytes"`
+	Streams          int64     `json:"streams"`
+	StreamQuotaBytes int64     `json:"streamQuotaBytes"`
+	TotalQuotaBytes  int64     `json:"totalQuotaBytes"`
+	FreeDiskBytes    int64     `json:"freeDiskBytes,omitempty"`
+	MinFreeDiskBytes int64     `json:"minFreeDiskBytes,omitempty"`
+	InRAM            bool      `json:"inRAM"`
+	LastBlocked      string    `json:"lastBlocked,omitempty"`
+	LastBlockedTime  time.Time `json:"lastBlockedTime,omitempty"`
+}
+
+// lastQuotaBlock is the last stream that could not be started because of a quota
+var lastQuotaBlock struct {
+	sync.Mutex
+	err  error
+	time time.Time
+}
+
+// minimumStreamBytes is the buffer a single stream needs to run: the buffer size and two segments
+func minimumStreamBytes() int64 {
+	return int64(Settings.BufferSize*1024) + 2*SegmentSize
+}
+
+// streamQuotaBytes returns the quota of a single stream (buffer.quota.stream.mb), 0 means unlimited
+func streamQuotaBytes() int64 {
+	if Settings.BufferQuotaStream <= 0 {
+		return 0
+	}
+	return max(int64(Settings.BufferQuotaStream)*1024*1024, minimumStreamBytes())
+}
+
+// totalQuotaBytes returns the quota of all streams together (buffer.quota.total.mb), 0 means unlimited
+func totalQuotaBytes() int64 {
+	if Settings.BufferQuotaTotal <= 0 {
+		return 0
+	}
+	return int64(Settings.BufferQuotaTotal) * 1024 * 1024
+}
+
+/*
+checkBufferSpace reports an error if the total quota has no room for another stream,
+or if the temp folder has not enough free space. The caller must hold the lock.
+*/
+func (sm *StreamManager) checkBufferSpace() (err error) {
+	defer func() {
+		if err != nil {
+			ShowError(err, 0)
+			lastQuotaBlock.Lock()
+			lastQuotaBlock.err, lastQuotaBlock.time = err, time.Now()
+			lastQuotaBlock.Unlock()
+		}
+	}()
+
+	if quota := totalQuotaBytes(); quota > 0 {
+		var streams int64
+		for _, playlist := range sm.Playlists {
+			for streamID, stream := range playlist.Streams {
+				if streamID != "TunerLimitReached" && stream != nil {
+					streams++
+				}
+			}
+		}
+		if (streams+1)*minimumStreamBytes() > quota {
+			return fmt.Errorf("%s (%d streams, %d MB)", getErrMsg(BufferQuotaError), streams, Settings.BufferQuotaTotal)
+		}
+	}
+
+	if !Settings.StoreBufferInRAM && Settings.BufferMinFreeSpace > 0 {
+		free, err := getFreeDiskSpace(System.Folder.Temp)
+		if err != nil {
+			ShowDebug(fmt.Sprintf("Streaming:Could not get the free space of %s: %s", System.Folder.Temp, err.Error()), 1)
+			return nil
+		}
+		if free < int64(Settings.BufferMinFreeSpace)*1024*1024 {
+			return fmt.Errorf("%s (%d MB free, %d MB required)", getErrMsg(DiskSpaceError), free/1024/1024, Settings.BufferMinFreeSpace)
+		}
+	}
+	return nil
+}
+
+/*
+enforceQuota deletes the oldest segments of the stream while it exceeds its own quota,
+or while all streams together exceed the total quota and the stream holds more than its share.
+The HLS segments of the stream count toward its size. The newest segments the stream needs to run
+and the segments a resumed client still reads are always kept. The quota limits the time-shift window,
+time-shift clients that fall behind skip to the oldest segment.
+*/
+func (sb *StreamBuffer) enforceQuota() {
+	var streamQuota, totalQuota = streamQuotaBytes(), totalQuotaBytes()
+	if streamQuota == 0 && totalQuota == 0 {
+		return
+	}
+
+	var hls = sb.Stream.hlsOutput()
+	for {
+		var size = int64(sb.Segments.Size())
+		if hls != nil {
+			size += hls.size()
+		}
+		var overStream = streamQuota > 0 && size > streamQuota
+		var overTotal = totalQuota > 0 && bufferedBytes.Load() > totalQuota && size > max(totalQuota/max(bufferedStreams.Load(), 1), minimumStreamBytes())
+		if !overStream && !overTotal {
+			return
+		}
+
+		oldest, _ := sb.Segments.Oldest()
+		if sb.Segments.Len() <= 2 || sb.Segments.DurationAfter(oldest.Sequence) < clientSessionGrace() && sb.Stream.segmentInUse(oldest.Sequence) {
+			// Only the HLS segments are left to trim
+			if hls == nil || !hls.trim() {
+				return
+			}
+			ShowDebug(fmt.Sprintf("Streaming:Buffer quota reached, removed a HLS segment of %s", sb.Stream.Name), 3)
+			continue
+		}
+
+		err := sb.FileSystem.Remove(filepath.Join(sb.Stream.Folder, fmt.Sprintf("%d.ts", oldest.Sequence)))
+		if err != nil && !fsIsNotExistErr(err) {
+			ShowError(err, 4007)
+			return
+		}
+		sb.Segments.Remove(oldest.Sequence)
+		ShowDebug(fmt.Sprintf("Streaming:Buffer quota reached, removed segment %d of %s", oldest.Sequence, sb.Stream.Name), 3)
+	}
+}
+
+// GetBufferUsage returns the size of all buffered segments and the quotas
+func GetBufferUsage() *BufferUsageStruct {
+	var usage = &BufferUsageStruct{
+		UsedBytes:        bufferedBytes.Load(),
+		Streams:          bufferedStreams.Load(),
+		StreamQuotaBytes: streamQuotaBytes(),
+		TotalQuotaBytes:  totalQuotaBytes(),
+		InRAM:            Settings.StoreBufferInRAM,
+	}
+
+	if !Settings.StoreBufferInRAM {
+		if free, err := getFreeDiskSpace(System.Folder.Temp); err == nil {
+			usage.FreeDiskBytes = free
+		}
+		usage.MinFreeDiskBytes = int64(Settings.BufferMinFreeSpace) * 1024 * 1024
+	}
+
+	lastQuotaBlock.Lock()
+	if lastQuotaBlock.err != nil {
+		usage.LastBlocked, usage.LastBlockedTime = lastQuotaBlock.err.Error(), lastQuotaBlock.time
+	}
+	lastQuotaBlock.Unlock()
+	return usage
+}
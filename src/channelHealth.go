@@ -0,0 +1,437 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"threadfin/src/internal/mpegts"
+)
+
+// Status of a channel or a single URL after the health scan
+const (
+	HealthOK      = "ok"
+	HealthFailing = "failing" // The last scans failed, but not often enough to consider the channel dead
+	HealthDead    = "dead"
+	HealthUnknown = "unknown" // The URL can't be probed (no HTTP) or is streamed at the moment
+)
+
+// Modes of the health scan (health.mode)
+const (
+	HealthModeRead = "read" // Read the first packets and check the MPEG-TS sync bytes
+	HealthModeHead = "head" // Only check the HTTP status of a HEAD request
+)
+
+const (
+	HealthScanError    = 4300 //errMsg = "Channel health scan failed"
+	HealthNoSyncError  = 4301 //errMsg = "No MPEG-TS sync bytes found"
+	HealthNoProbeError = 4302 //errMsg = "The URL can't be probed"
+
+	healthCheckInterval = time.Minute
+	healthProbePackets  = 7 // Number of TS packets read from the upstream
+	healthSyncPackets   = 3 // Number of consecutive sync bytes required
+)
+
+// ChannelHealth is the result of the last health scan of a XEPG channel, it is stored as x-health in the channel data
+type ChannelHealth struct {
+	Status   string      `json:"status"`
+	Checked  time.Time   `json:"checked"`
+	Latency  int64       `json:"latency.ms"` // Latency of the first working URL
+	Failures int         `json:"failures"`   // Number of failed scans in a row
+	Error    string      `json:"error,omitempty"`
+	Audio    string      `json:"audio,omitempty"` // Content type of an audio-only upstream (ADTS or MP3)
+	URLs     []URLHealth `json:"urls"`
+}
+
+// URLHealth is the result of the probe of the stream URL or one of the backup URLs
+type URLHealth struct {
+	URL     string `json:"url"`
+	Status  string `json:"status"`
+	Latency int64  `json:"latency.ms"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ChannelHealthEntry is returned by the API for every scanned channel
+type ChannelHealthEntry struct {
+	XEPG      string         `json:"x-epg"`
+	ChannelID string         `json:"channelID"`
+	Name      string         `json:"name"`
+	Health    *ChannelHealth `json:"health"`
+}
+
+// HealthScanner probes the active XEPG channels (health.scan.interval)
+type HealthScanner struct {
+	mu       sync.Mutex
+	running  bool
+	lastScan time.Time
+}
+
+var healthScanner = &HealthScanner{}
+
+/*
+schedule starts a scan whenever the interval has passed. The first scan runs one interval after the start,
+so the playlists are not probed while Threadfin is still loading them.
+*/
+func (hs *HealthScanner) schedule() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	hs.lastScan = time.Now()
+	for range ticker.C {
+		var interval = time.Duration(Settings.HealthScanInterval) * time.Minute
+		if interval <= 0 || time.Since(hs.lastScan) < interval || System.ScanInProgress != 0 {
+			continue
+		}
+		hs.Scan()
+	}
+}
+
+// Start runs a scan in the background, it reports false if a scan is already running
+func (hs *HealthScanner) Start() bool {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.running {
+		return false
+	}
+	go hs.Scan()
+	return true
+}
+
+/*
+Scan probes every active XEPG channel one after another and stores the results in Data.XEPG.Channels.
+Channels which are streamed at the moment and playlists without a free tuner are skipped.
+*/
+func (hs *HealthScanner) Scan() {
+	hs.mu.Lock()
+	if hs.running {
+		hs.mu.Unlock()
+		return
+	}
+	hs.running = true
+	hs.mu.Unlock()
+
+	defer func() {
+		hs.mu.Lock()
+		hs.running = false
+		hs.lastScan = time.Now()
+		hs.mu.Unlock()
+	}()
+
+	var channels = getHealthChannels()
+	ShowInfo(fmt.Sprintf("Health:Scanning %d channels", len(channels)))
+
+	var results = make(map[string]*ChannelHealth)
+	var dead int
+	for id, channel := range channels {
+		health := probeChannel(channel)
+		if health == nil {
+			continue
+		}
+		if health.Status == HealthDead {
+			dead++
+		}
+		results[id] = health
+	}
+
+	changed, radioChanged, err := storeChannelHealth(results)
+	if err != nil {
+		ShowError(err, HealthScanError)
+		return
+	}
+	ShowInfo(fmt.Sprintf("Health:Scanned %d channels, %d dead", len(results), dead))
+
+	// The hidden or the radio channels changed, the M3U file has to be created again
+	if changed && Settings.HealthHideDead || radioChanged {
+		createM3UFile()
+	}
+}
+
+// getHealthChannels returns a copy of all active XEPG channels
+func getHealthChannels() map[string]XEPGChannelStruct {
+	var channels = make(map[string]XEPGChannelStruct)
+	for id, dxc := range Data.XEPG.Channels {
+		var xepgChannel XEPGChannelStruct
+		if err := json.Unmarshal([]byte(mapToJSON(dxc)), &xepgChannel); err != nil {
+			continue
+		}
+		if xepgChannel.XActive {
+			channels[id] = xepgChannel
+		}
+	}
+	return channels
+}
+
+/*
+probeChannel probes the stream URL and the backup URLs of the channel. The channel is ok if one of the URLs works.
+It returns nil if the channel was not probed.
+*/
+func probeChannel(channel XEPGChannelStruct) *ChannelHealth {
+	var streamInfo = &StreamInfo{
+		PlaylistID: channel.FileM3UID,
+		URLid:      channelStreamID(channel),
+	}
+	if !streamManager.canProbe(streamInfo) {
+		return nil
+	}
+
+	var previous = channel.XHealth
+	var health = &ChannelHealth{Status: HealthUnknown, Checked: time.Now()}
+
+	for _, rawURL := range []string{channel.URL, channel.BackupChannel1URL, channel.BackupChannel2URL, channel.BackupChannel3URL} {
+		if rawURL == "" {
+			continue
+		}
+
+		var result = URLHealth{URL: rawURL, Status: HealthOK}
+		latency, audio, err := probeStreamURL(streamInfo, rawURL)
+		result.Latency = latency.Milliseconds()
+		switch {
+		case errors.Is(err, errHealthNoProbe):
+			result.Status = HealthUnknown
+		case err != nil:
+			result.Status, result.Error = HealthFailing, err.Error()
+			if health.Error == "" {
+				health.Error = err.Error()
+			}
+		case health.Status != HealthOK:
+			health.Status, health.Latency, health.Audio = HealthOK, result.Latency, audio
+		}
+		health.URLs = append(health.URLs, result)
+	}
+
+	switch {
+	case health.Status == HealthOK:
+		health.Error = ""
+	case health.Error == "":
+		// None of the URLs could be probed
+		return health
+	default:
+		health.Failures = 1
+		if previous != nil {
+			health.Failures = previous.Failures + 1
+		}
+		health.Status = HealthFailing
+		if health.Failures >= max(Settings.HealthDeadAfter, 1) {
+			health.Status = HealthDead
+		}
+		ShowDebug(fmt.Sprintf("Health:%s %s (%s)", channel.XName, health.Status, health.Error), 1)
+	}
+	return health
+}
+
+var errHealthNoProbe = errors.New(getErrMsg(HealthNoProbeError))
+
+/*
+probeStreamURL requests the URL and checks that MPEG-TS packets arrive. For HLS playlists the newest
+segment is checked. It returns the time until the upstream answered and the content type of an audio-only upstream,
+which has no MPEG-TS packets.
+*/
+func probeStreamURL(streamInfo *StreamInfo, rawURL string) (latency time.Duration, audio string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(max(Settings.HealthTimeout, 1))*time.Second)
+	defer cancel()
+
+	var start = time.Now()
+	resp, err := healthRequest(ctx, Settings.HealthMode, streamInfo, rawURL)
+	if err != nil {
+		return time.Since(start), "", err
+	}
+	defer resp.Body.Close()
+	latency = time.Since(start)
+
+	if audio = audioContentType(resp); audio != "" || Settings.HealthMode == HealthModeHead {
+		return
+	}
+
+	if isHLSResponse(resp) {
+		audio, err = probeHLSPlaylist(ctx, streamInfo, resp)
+		return
+	}
+	return latency, "", checkSyncBytes(resp.Body)
+}
+
+// probeHLSPlaylist follows the playlist to the newest segment and checks its sync bytes, packed audio segments have none
+func probeHLSPlaylist(ctx context.Context, streamInfo *StreamInfo, resp *http.Response) (string, error) {
+	master, media, err := parseHLSPlaylist(resp.Request.URL.String(), resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if master != nil {
+		variantResp, err := healthRequest(ctx, HealthModeRead, streamInfo, master.SelectVariant(0).URI)
+		if err != nil {
+			return "", err
+		}
+		if master, media, err = parseHLSPlaylist(variantResp.Request.URL.String(), variantResp.Body); err != nil {
+			return "", err
+		}
+		if master != nil {
+			return "", errors.New("expected a media playlist but got a master playlist")
+		}
+	}
+
+	if len(media.Segments) == 0 {
+		return "", errors.New("HLS playlist contains no segments")
+	}
+	var segment = media.Segments[len(media.Segments)-1]
+
+	segmentResp, err := healthRequest(ctx, HealthModeRead, streamInfo, segment.URI)
+	if err != nil {
+		return "", err
+	}
+	defer segmentResp.Body.Close()
+
+	// Encrypted segments can't be checked without the key, the answer of the upstream is enough
+	if audio := audioContentType(segmentResp); audio != "" || segment.Key != nil {
+		return audio, nil
+	}
+	return "", checkSyncBytes(segmentResp.Body)
+}
+
+/*
+healthRequest sends a GET or HEAD request with the HTTP client of the provider.
+Every non 2xx status code is an error.
+*/
+func healthRequest(ctx context.Context, mode string, streamInfo *StreamInfo, rawURL string) (*http.Response, error) {
+	var method = http.MethodGet
+	if mode == HealthModeHead {
+		method = http.MethodHead
+	}
+
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errHealthNoProbe
+	}
+	return doProviderRequest(ctx, method, streamInfo, rawURL)
+}
+
+// checkSyncBytes reads the first packets and looks for consecutive MPEG-TS sync bytes
+func checkSyncBytes(r io.Reader) error {
+	var data = make([]byte, healthProbePackets*mpegts.PacketSize)
+	n, err := io.ReadFull(r, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	data = data[:n]
+
+	for offset := 0; offset < mpegts.PacketSize && offset+(healthSyncPackets-1)*mpegts.PacketSize < len(data); offset++ {
+		var synced = true
+		for i := 0; i < healthSyncPackets; i++ {
+			if data[offset+i*mpegts.PacketSize] != mpegts.SyncByte {
+				synced = false
+				break
+			}
+		}
+		if synced {
+			return nil
+		}
+	}
+
+	if bytes.HasPrefix(data, []byte("#EXTM3U")) {
+		return errors.New("unexpected HLS playlist")
+	}
+	return errors.New(getErrMsg(HealthNoSyncError))
+}
+
+/*
+storeChannelHealth writes the results into the channel data and saves the XEPG database.
+It reports whether a channel became dead or recovered and whether a channel was detected as audio-only or no longer is.
+*/
+func storeChannelHealth(results map[string]*ChannelHealth) (changed, radioChanged bool, err error) {
+	for System.ScanInProgress != 0 {
+		time.Sleep(time.Second)
+	}
+
+	System.ScanInProgress = 1
+	defer func() { System.ScanInProgress = 0 }()
+
+	// The types detected by the streams, the results of the scan are newer
+	for id, contentType := range takeDetectedAudio() {
+		if channel, ok := Data.XEPG.Channels[id].(map[string]interface{}); ok {
+			if contentType == "" {
+				delete(channel, "x-audio")
+			} else {
+				channel["x-audio"] = contentType
+			}
+		}
+	}
+
+	for id, health := range results {
+		channel, ok := Data.XEPG.Channels[id].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var previous XEPGChannelStruct
+		if err := json.Unmarshal([]byte(mapToJSON(channel)), &previous); err == nil {
+			var wasDead = previous.XHealth != nil && previous.XHealth.Status == HealthDead
+			changed = changed || wasDead != (health.Status == HealthDead)
+		}
+
+		channel["x-health"] = health
+
+		// Audio-only upstreams are radio channels, the M3U file marks them. Only a read probe can tell the opposite.
+		if health.Status == HealthOK && previous.XAudio != health.Audio && (health.Audio != "" || Settings.HealthMode == HealthModeRead) {
+			if health.Audio == "" {
+				delete(channel, "x-audio")
+			} else {
+				channel["x-audio"] = health.Audio
+			}
+			radioChanged = true
+		}
+	}
+
+	err = saveMapToJSONFile(System.File.XEPG, Data.XEPG.Channels)
+	return
+}
+
+// isDeadChannelHidden reports whether the channel has to be hidden from the M3U file and the lineup (health.hideDead)
+func isDeadChannelHidden(xepgChannel XEPGChannelStruct) bool {
+	return Settings.HealthHideDead && xepgChannel.XHealth != nil && xepgChannel.XHealth.Status == HealthDead
+}
+
+// GetChannelHealth returns the health of all scanned channels, sorted by channel number
+func GetChannelHealth() (entries []ChannelHealthEntry) {
+	for id, xepgChannel := range getHealthChannels() {
+		if xepgChannel.XHealth == nil {
+			continue
+		}
+		entries = append(entries, ChannelHealthEntry{
+			XEPG:      id,
+			ChannelID: xepgChannel.XChannelID,
+			Name:      xepgChannel.XName,
+			Health:    xepgChannel.XHealth,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(entries[i].ChannelID, 64)
+		b, _ := strconv.ParseFloat(entries[j].ChannelID, 64)
+		return a < b
+	})
+	return
+}
+
+/*
+canProbe reports whether the upstream of the stream may be probed. Running streams are healthy anyway
+and the probe must not take a tuner which a client could need.
+*/
+func (sm *StreamManager) canProbe(streamInfo *StreamInfo) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.LockAgainstNewStreams {
+		return false
+	}
+	if playlist, exists := sm.Playlists[streamInfo.PlaylistID]; exists {
+		if _, exists := playlist.Streams[streamInfo.URLid]; exists {
+			return false
+		}
+	}
+	return sm.IsNewStreamPossible(streamInfo)
+}
@@ -0,0 +1,204 @@
+package src
+
+import (
+	"io"
+	"sync"
+
+	"threadfin/src/internal/mpegts"
+)
+
+// Policies for clients that can not keep up with the stream (buffer.slowClientPolicy)
+const (
+	SlowClientDrop       = "drop"       // Drop whole TS packets that do not fit into the queue
+	SlowClientSkip       = "skip"       // Discard the queue and continue at the live edge
+	SlowClientDisconnect = "disconnect" // Disconnect the client
+)
+
+// ClientQueueStats contains the lag diagnostics of a client
+type ClientQueueStats struct {
+	Queued    int   `json:"queuedBytes"`
+	MaxQueued int   `json:"maxQueuedBytes"`
+	Capacity  int   `json:"capacityBytes"`
+	Sent      int64 `json:"sentBytes"`
+	Dropped   int64 `json:"droppedBytes"`
+	Skips     int   `json:"skips"`
+}
+
+/*
+ClientQueue is a bounded ring buffer between Broadcast and the writer goroutine of a single client.
+Offsets are counted from the start of the stream, so TS packet boundaries are known without parsing the data.
+*/
+type ClientQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	start  int
+	size   int
+	closed bool
+
+	writeOffset int64 // Offset of the next byte that will be pushed
+	accepting   bool  // Decision for the current TS packet when the queue is full
+	stats       ClientQueueStats
+}
+
+func NewClientQueue(capacity int) *ClientQueue {
+	if capacity < mpegts.PacketSize*16 {
+		capacity = mpegts.PacketSize * 16
+	}
+	q := &ClientQueue{
+		data:      make([]byte, capacity),
+		accepting: true,
+	}
+	q.stats.Capacity = capacity
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+/*
+Push adds the data to the queue. If the queue is full the policy decides what happens with the data.
+It returns false if the client has to be disconnected.
+*/
+func (q *ClientQueue) Push(p []byte, policy string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return true
+	}
+
+	for len(p) > 0 {
+		// Split the data at the TS packet boundaries
+		n := mpegts.PacketSize - int(q.writeOffset%mpegts.PacketSize)
+		if n > len(p) {
+			n = len(p)
+		}
+		piece := p[:n]
+		packetStart := q.writeOffset%mpegts.PacketSize == 0
+
+		if packetStart {
+			q.accepting = len(q.data)-q.size >= mpegts.PacketSize
+			if !q.accepting {
+				switch policy {
+				case SlowClientDisconnect:
+					q.closed = true
+					q.size = 0
+					q.cond.Broadcast()
+					return false
+				case SlowClientSkip:
+					q.skipToLiveEdge()
+					q.accepting = true
+				}
+			}
+		}
+
+		if q.accepting {
+			q.write(piece)
+		} else {
+			q.stats.Dropped += int64(len(piece))
+		}
+		q.writeOffset += int64(n)
+		p = p[n:]
+	}
+
+	if q.size > q.stats.MaxQueued {
+		q.stats.MaxQueued = q.size
+	}
+	q.cond.Broadcast()
+	return true
+}
+
+/*
+Pop waits for data and returns up to max bytes from the queue.
+It returns io.EOF once the queue has been closed.
+*/
+func (q *ClientQueue) Pop(max int) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.size == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if q.size == 0 {
+		return nil, io.EOF
+	}
+
+	n := min(max, q.size, len(q.data)-q.start)
+	out := make([]byte, n)
+	copy(out, q.data[q.start:q.start+n])
+	q.start = (q.start + n) % len(q.data)
+	q.size -= n
+	q.stats.Sent += int64(n)
+	q.cond.Broadcast() // Wake up PushWait
+	return out, nil
+}
+
+/*
+PushWait adds the data to the queue and waits for free space instead of dropping data.
+It is used for time-shift clients, a paused client holds up only its own segment reader.
+It returns false once the queue has been closed.
+*/
+func (q *ClientQueue) PushWait(p []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(p) > 0 {
+		for q.size == len(q.data) && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return false
+		}
+
+		n := min(len(p), len(q.data)-q.size)
+		q.write(p[:n])
+		q.writeOffset += int64(n)
+		p = p[n:]
+
+		if q.size > q.stats.MaxQueued {
+			q.stats.MaxQueued = q.size
+		}
+		q.cond.Broadcast()
+	}
+	return true
+}
+
+// Close discards the remaining data and wakes up the writer
+func (q *ClientQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.size = 0
+	q.cond.Broadcast()
+}
+
+// Stats returns the current lag diagnostics of the client
+func (q *ClientQueue) Stats() ClientQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Queued = q.size
+	return stats
+}
+
+func (q *ClientQueue) write(p []byte) {
+	end := (q.start + q.size) % len(q.data)
+	n := copy(q.data[end:], p)
+	copy(q.data, p[n:])
+	q.size += len(p)
+}
+
+/*
+skipToLiveEdge discards the queued data. The bytes up to the next packet boundary are kept,
+so the client does not receive a truncated packet.
+*/
+func (q *ClientQueue) skipToLiveEdge() {
+	readOffset := q.writeOffset - int64(q.size)
+	keep := int((mpegts.PacketSize - readOffset%mpegts.PacketSize) % mpegts.PacketSize)
+	if keep > q.size {
+		keep = q.size
+	}
+	q.stats.Dropped += int64(q.size - keep)
+	q.stats.Skips++
+	q.size = keep
+}
@@ -0,0 +1,158 @@
+package src
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ClientSession remembers a MPEG-TS client that left, so it can continue where it left off when it comes back
+type ClientSession struct {
+	PlaylistID string
+	StreamID   string
+	ClientID   string
+	Sequence   int // Segment the client continues with
+	Left       time.Time
+}
+
+/*
+getClientSessionKey identifies the client of the channel by the session parameter of the URL.
+Clients without a session are identified by their IP address and user agent.
+*/
+func getClientSessionKey(streamInfo *StreamInfo, r *http.Request) string {
+	if session := r.URL.Query().Get("session"); session != "" {
+		return getMD5(fmt.Sprintf("%s-%s", streamInfo.URLid, session))
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return getMD5(fmt.Sprintf("%s-%s-%s", streamInfo.URLid, ip, r.Header.Get("User-Agent")))
+}
+
+func clientSessionGrace() time.Duration {
+	return time.Duration(Settings.BufferSessionGrace) * time.Second
+}
+
+/*
+resumeClientSession returns the session of the client if it came back within buffer.session.grace seconds
+and the stream is still running. The stream gets reactivated if the client was its last one.
+*/
+func (sm *StreamManager) resumeClientSession(key string) (*ClientSession, *Stream, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.ClientSessions[key]
+	if !exists {
+		return nil, nil, false
+	}
+	delete(sm.ClientSessions, key)
+
+	if time.Since(session.Left) > clientSessionGrace() {
+		return nil, nil, false
+	}
+
+	playlist, exists := sm.Playlists[session.PlaylistID]
+	if !exists {
+		return nil, nil, false
+	}
+	stream, exists := playlist.Streams[session.StreamID]
+	if !exists || stream == nil || stream.Ctx.Err() != nil {
+		return nil, nil, false
+	}
+
+	if stream.clientCount() == 0 {
+		stream.reactivate()
+	}
+	ShowInfo(fmt.Sprintf("Streaming:Client %s resumed %s at segment %d", session.ClientID, session.StreamID, session.Sequence))
+	return session, stream, true
+}
+
+/*
+leaveClientSession remembers the position of the client that closed the connection.
+Expired sessions are removed at the same time.
+*/
+func (sm *StreamManager) leaveClientSession(key string, playlistID, streamID, clientID string, stream *Stream, client *Client) {
+	if clientSessionGrace() <= 0 || stream.Folder == "" {
+		return
+	}
+
+	var sequence = stream.clientSequence(client)
+	if sequence < 0 {
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for k, session := range sm.ClientSessions {
+		if time.Since(session.Left) > clientSessionGrace() {
+			delete(sm.ClientSessions, k)
+		}
+	}
+
+	sm.ClientSessions[key] = &ClientSession{PlaylistID: playlistID, StreamID: streamID, ClientID: clientID, Sequence: sequence, Left: time.Now()}
+	ShowDebug(fmt.Sprintf("Streaming:Client %s left %s at segment %d", clientID, streamID, sequence), 2)
+}
+
+/*
+hasClientSession reports whether a client of the stream may come back, the stream is kept for the grace window.
+The caller must hold the lock.
+*/
+func (sm *StreamManager) hasClientSession(playlistID, streamID string) bool {
+	for _, session := range sm.ClientSessions {
+		if session.PlaylistID == playlistID && session.StreamID == streamID && time.Since(session.Left) <= clientSessionGrace() {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+clientSequence returns the segment with the data the client received last. The data within the queue of the client
+has not been sent yet, the position is moved back by its size.
+*/
+func (s *Stream) clientSequence(client *Client) int {
+	var sequence = s.Buffer.GetPipeSequence()
+	if client.timeshift {
+		sequence = int(client.sequence.Load())
+	}
+	if sequence < 0 {
+		return -1
+	}
+	return s.Buffer.GetSegments().SequenceBefore(sequence, client.queue.Stats().Queued)
+}
+
+/*
+reactivate stops the timer of the stream without clients and restarts the pipe of the buffered segments.
+The caller must hold the lock of the StreamManager.
+*/
+func (s *Stream) reactivate() {
+	if s.StopTimer != nil {
+		s.StopTimer.Stop()
+		s.StopTimer = nil
+		s.TimerCancel = nil
+	}
+	s.Buffer.SetStopChan(make(chan struct{}))
+	go s.Buffer.addBufferedFilesToPipe()
+}
+
+/*
+segmentInUse reports whether a client that reads the segments on its own has not reached the segment yet.
+Time-shift clients are limited by the time-shift window, only resumed clients are checked. The caller limits
+the time a segment is kept for them, a stalled client must not hold up the buffer.
+*/
+func (s *Stream) segmentInUse(sequence int) bool {
+	if Settings.BufferTimeshift > 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, client := range s.Clients {
+		if client.timeshift && client.sequence.Load() >= 0 && client.sequence.Load() <= int64(sequence) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package src
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+commandValues are the values of the placeholders in the arguments of the command buffer:
+[URL], [USER-AGENT], [HEADERS] and [PROXY]
+*/
+type commandValues struct {
+	URL       string
+	UserAgent string
+	Headers   []string // Key=Value
+	Proxy     string
+}
+
+/*
+getCommandValues returns the values of the stream, the URL headers, the headers and cookies of the provider
+and the proxy are resolved the same way as for the requests of the Threadfin buffer.
+*/
+func getCommandValues(streamInfo *StreamInfo) commandValues {
+	var values = commandValues{URL: streamInfo.URL, UserAgent: getProviderUserAgent(streamInfo.PlaylistID)}
+
+	if req, err := newProviderRequest(context.Background(), http.MethodGet, streamInfo, streamInfo.URL); err == nil {
+		values.URL = req.URL.String()
+		for key, header := range req.Header {
+			if key == "User-Agent" {
+				continue
+			}
+			for _, value := range header {
+				values.Headers = append(values.Headers, key+"="+value)
+			}
+		}
+		sort.Strings(values.Headers)
+	}
+
+	if proxyURL, err := getProviderProxy(getProviderSettings(streamInfo.PlaylistID)); err == nil && proxyURL != nil {
+		values.Proxy = proxyURL.String()
+	}
+	return values
+}
+
+/*
+arguments splits the options at spaces and replaces the placeholders. An argument with [HEADERS] is repeated
+for every header (--http-header=[HEADERS]), an argument with [PROXY] is left out if the provider has no proxy.
+*/
+func (v commandValues) arguments(options string) (args []string) {
+	var replacer = strings.NewReplacer("[URL]", v.URL, "[USER-AGENT]", v.UserAgent, "[PROXY]", v.Proxy)
+	for _, arg := range strings.Split(options, " ") {
+		switch {
+		case arg == "":
+			continue
+		case strings.Contains(arg, "[PROXY]") && v.Proxy == "":
+			continue
+		case strings.Contains(arg, "[HEADERS]"):
+			arg = replacer.Replace(arg)
+			for _, header := range v.Headers {
+				args = append(args, strings.ReplaceAll(arg, "[HEADERS]", header))
+			}
+		default:
+			args = append(args, replacer.Replace(arg))
+		}
+	}
+	return
+}
@@ -25,7 +25,7 @@ var Settings SettingsStruct
 var Data DataStruct
 
 // SystemFiles : Alle Systemdateien
-var SystemFiles = []string{"authentication.json", "pms.json", "settings.json", "xepg.json", "urls.json"}
+var SystemFiles = []string{"authentication.json", "pms.json", "settings.json", "xepg.json", "urls.json", "recordings.json"}
 
 // bufferVFS : Filesystem to use for the Buffer
 //var bufferVFS avfs.VFS
@@ -50,6 +50,9 @@ func Init() (err error) {
 	System.FFmpeg.DefaultOptions = "-hide_banner -loglevel error -i [URL] -hls_time 6 -hls_list_size 3 -c copy -f hls pipe:1"
 	System.VLC.DefaultOptions = "-I dummy [URL] --sout \"#std{mux=ts,access=file,dst=-}\" --no-sout-all"
 
+	// Command buffer, the default is the preset for streamlink
+	System.Command.DefaultOptions = "--stdout --loglevel error --http-header=User-Agent=[USER-AGENT] --http-header=[HEADERS] --http-proxy=[PROXY] [URL] best"
+
 	// Default Logeinträge, wird später von denen aus der settings.json überschrieben. Muss gemacht werden, damit die ersten Einträge auch im Log (webUI aangezeigt werden)
 	Settings.LogEntriesRAM = 500
 
@@ -76,6 +79,7 @@ func Init() (err error) {
 	System.Folder.ImagesCache = System.Folder.Cache + "images" + string(os.PathSeparator)
 	System.Folder.ImagesUpload = System.Folder.Data + "images" + string(os.PathSeparator)
 	System.Folder.Custom = System.Folder.ImagesUpload + "custom" + string(os.PathSeparator)
+	System.Folder.Slates = System.Folder.ImagesUpload + "slates" + string(os.PathSeparator)
 	System.Folder.Video = System.Folder.Data + "video" + string(os.PathSeparator)
 	System.Folder.Temp = tempFolder
 
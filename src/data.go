@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path"
 	"sort"
@@ -114,7 +115,66 @@ func updateServerSettings(request RequestStruct) (settings *SettingsStruct, err
 					return
 				}
 
-			case "ffmpeg.path":
+			case "buffer.slowClientPolicy":
+				switch value {
+				case SlowClientDrop, SlowClientSkip, SlowClientDisconnect:
+				default:
+					err = fmt.Errorf("invalid slow client policy: %v", value)
+					return
+				}
+
+			case "recording.path":
+				value = strings.TrimRight(value.(string), string(os.PathSeparator)) + string(os.PathSeparator)
+				err = checkFolder(value.(string))
+				if err != nil {
+					return
+				}
+
+			case "transcoding.profiles":
+				if profiles, ok := value.([]interface{}); ok {
+					if err = checkTranscodingProfiles(profiles); err != nil {
+						return
+					}
+				}
+
+			case "udp.interface":
+				if name, ok := value.(string); ok && name != "" {
+					if _, err = net.InterfaceByName(name); err != nil {
+						return
+					}
+				}
+
+			case "health.mode":
+				switch value {
+				case HealthModeRead, HealthModeHead:
+				default:
+					err = fmt.Errorf("invalid health scan mode: %v", value)
+					return
+				}
+
+			case "head.mode":
+				switch value {
+				case HeadModeSynthesize, HeadModePassthrough:
+				default:
+					err = fmt.Errorf("invalid HEAD mode: %v", value)
+					return
+				}
+
+			case "health.scan.interval", "health.timeout", "health.deadAfter",
+				"buffer.quota.stream.mb", "buffer.quota.total.mb", "buffer.minFreeSpace.mb", "slate.timeout",
+				"buffer.session.grace", "history.retention.days":
+				if number, ok := value.(float64); ok && number < 0 {
+					err = fmt.Errorf("invalid value for %s: %v", key, value)
+					return
+				}
+
+			case "buffer.timeshift.minutes":
+				if minutes, ok := value.(float64); ok && minutes < 0 {
+					err = fmt.Errorf("invalid time-shift window: %v", value)
+					return
+				}
+
+			case "ffmpeg.path", "command.path":
 				var path = value.(string)
 				if len(path) > 0 {
 
@@ -186,6 +246,10 @@ func updateServerSettings(request RequestStruct) (settings *SettingsStruct, err
 		Settings.VLCOptions = System.VLC.DefaultOptions
 	}
 
+	if len(Settings.CommandOptions) == 0 {
+		Settings.CommandOptions = System.Command.DefaultOptions
+	}
+
 	switch Settings.Buffer {
 
 	case "ffmpeg":
@@ -202,6 +266,13 @@ func updateServerSettings(request RequestStruct) (settings *SettingsStruct, err
 			return
 		}
 
+	case "command":
+
+		if len(Settings.CommandPath) == 0 {
+			err = errors.New(getErrMsg(2022))
+			return
+		}
+
 	}
 
 	if serverProtocolChanged || omitPortsChanged {
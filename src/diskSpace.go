@@ -0,0 +1,14 @@
+//go:build !windows
+
+package src
+
+import "syscall"
+
+// getFreeDiskSpace returns the bytes available to the user in the file system of the path
+func getFreeDiskSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
@@ -118,6 +118,11 @@ func getLineup() (jsonContent []byte, err error) {
 				return
 			}
 
+			// Radio channels are only part of the lineup if radio.hdhr is enabled
+			if m3uChannel.Radio == "true" && !Settings.RadioHDHR {
+				continue
+			}
+
 			var stream LineupStream
 			stream.GuideName = m3uChannel.Name
 			switch len(m3uChannel.UUIDValue) {
@@ -146,6 +151,7 @@ func getLineup() (jsonContent []byte, err error) {
 		}
 
 	case "XEPG":
+		var channels []XEPGChannelStruct
 		for _, dxc := range Data.XEPG.Channels {
 
 			var xepgChannel XEPGChannelStruct
@@ -154,12 +160,16 @@ func getLineup() (jsonContent []byte, err error) {
 				return
 			}
 
-			if xepgChannel.XActive && !xepgChannel.XHideChannel {
+			if xepgChannel.XActive {
+				channels = append(channels, xepgChannel)
+			}
+
+			if xepgChannel.XActive && !xepgChannel.XHideChannel && !isDeadChannelHidden(xepgChannel) && (Settings.RadioHDHR || !isRadioChannel(xepgChannel)) {
 				var stream LineupStream
 				stream.GuideName = xepgChannel.XName
 				stream.GuideNumber = xepgChannel.XChannelID
 				//stream.URL = fmt.Sprintf("%s://%s/stream/%s-%s", System.ServerProtocol.DVR, System.Domain, xepgChannel.FileM3UID, base64.StdEncoding.EncodeToString([]byte(xepgChannel.URL)))
-				stream.URL, err = createStreamingURL(xepgChannel.FileM3UID, xepgChannel.XChannelID, xepgChannel.XName, xepgChannel.URL, xepgChannel.BackupChannel1URL, xepgChannel.BackupChannel2URL, xepgChannel.BackupChannel3URL)
+				stream.URL, err = createChannelStreamingURL(xepgChannel)
 				if err == nil {
 					lineup = append(lineup, stream)
 				} else {
@@ -169,6 +179,7 @@ func getLineup() (jsonContent []byte, err error) {
 			}
 
 		}
+		forwardLegacyStreamIDs(channels)
 
 	}
 
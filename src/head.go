@@ -0,0 +1,177 @@
+package src
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const HeadRequestError = 4080 //errMsg = "HEAD request to the streaming server failed"
+
+// Modes for HEAD requests of /stream/ (head.mode)
+const (
+	HeadModeSynthesize  = "synthesize"  // Answer from the known channel data, the streaming server is not contacted
+	HeadModePassthrough = "passthrough" // Send the HEAD request to the streaming server and return its headers
+)
+
+// lastStreamCodecs keeps the codecs of the PMT of ended streams by stream ID, a HEAD request gets them without a running stream
+var lastStreamCodecs sync.Map
+
+/*
+setStreamHeaders sets the headers of a MPEG-TS stream served by the buffer. The content type of
+audio-only upstreams and remuxed radio channels is set by the client writer with the first data.
+*/
+func setStreamHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Keep-Alive", "timeout=10, max=100")
+}
+
+/*
+serveStreamHead answers HEAD and OPTIONS requests of /stream/. The headers are the ones a GET request would get,
+derived from the channel, the buffer and the codecs of the latest PMT. Only with head.mode passthrough
+the request is sent to the streaming server.
+*/
+func serveStreamHead(w http.ResponseWriter, r *http.Request, streamInfo *StreamInfo, bufferConfig BufferConfig, hlsFile string) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if Settings.HeadMode == HeadModePassthrough && strings.HasPrefix(streamInfo.URL, "http") {
+		passthroughStreamHead(w, r, streamInfo)
+		return
+	}
+
+	if bufferConfig.Type == "-" && !hasProviderProxy(streamInfo.PlaylistID) {
+		// The client gets the same redirect as for GET
+		http.Redirect(w, r, streamInfo.URL, http.StatusFound)
+		return
+	}
+
+	var codecs, audio = streamManager.streamCodecs(streamInfo)
+
+	switch {
+	case hlsFile != "" && filepath.Ext(hlsFile) == ".m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	case hlsFile != "":
+		w.Header().Set("Content-Type", "video/mp2t")
+	default:
+		setStreamHeaders(w)
+		if contentType := streamContentType(streamInfo, codecs, audio); contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+	}
+
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("X-Threadfin-Buffer", bufferConfig.Type)
+	if len(codecs) > 0 {
+		w.Header().Set("X-Threadfin-Codecs", strings.Join(codecs, ","))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+streamContentType returns the content type of an audio-only upstream or a remuxed radio channel,
+empty for MPEG-TS
+*/
+func streamContentType(streamInfo *StreamInfo, codecs []string, audio string) string {
+	if audio != "" {
+		return audio
+	}
+	if channel, found := getStreamChannel(streamInfo); found && channelAudio(channel) != "" {
+		return channelAudio(channel)
+	}
+	if !streamInfo.Radio || !Settings.RadioRemux {
+		return ""
+	}
+	// The remux extracts the first audio stream that can be played without a container
+	for _, codec := range codecs {
+		switch codec {
+		case "aac":
+			return "audio/aac"
+		case "mpegaudio":
+			return "audio/mpeg"
+		}
+	}
+	return ""
+}
+
+// passthroughStreamHead returns the headers of the streaming server, cookies are not passed to the client
+func passthroughStreamHead(w http.ResponseWriter, r *http.Request, streamInfo *StreamInfo) {
+	resp, err := doProviderRequest(r.Context(), http.MethodHead, streamInfo, streamInfo.URL)
+	if err != nil {
+		ShowError(err, HeadRequestError)
+		httpStatusError(w, http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		if key == "Set-Cookie" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+}
+
+// hasProviderProxy reports whether the streams of the playlist are fetched through a HTTP proxy without buffer
+func hasProviderProxy(playlistID string) bool {
+	providerSettings, ok := Settings.Files.M3U[playlistID].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	proxyIP, _ := providerSettings["http_proxy.ip"].(string)
+	proxyPort, _ := providerSettings["http_proxy.port"].(string)
+	return proxyIP != "" && proxyPort != ""
+}
+
+/*
+streamCodecs returns the codecs of the running stream and the content type of an audio-only upstream.
+Without a running stream the codecs of the last stream of the channel are returned.
+*/
+func (sm *StreamManager) streamCodecs(streamInfo *StreamInfo) (codecs []string, audio string) {
+	sm.mu.Lock()
+	var stream *Stream
+	if playlist, exists := sm.Playlists[streamInfo.PlaylistID]; exists {
+		stream = playlist.Streams[streamInfo.URLid]
+	}
+	if stream == nil {
+		if playlistID, streamID, found := sm.findSharedStream(streamInfo); found {
+			stream = sm.Playlists[playlistID].Streams[streamID]
+		}
+	}
+	sm.mu.Unlock()
+
+	if stream != nil {
+		stream.mu.Lock()
+		codecs, audio = stream.warm.randomAccess.Codecs(), stream.audio
+		stream.mu.Unlock()
+		if len(codecs) > 0 || audio != "" {
+			return codecs, audio
+		}
+	}
+
+	if last, ok := lastStreamCodecs.Load(streamInfo.URLid); ok {
+		codecs = last.([]string)
+	}
+	return codecs, ""
+}
+
+// keepStreamCodecs remembers the codecs of the ending stream for HEAD requests
+func (s *Stream) keepStreamCodecs(streamID string) {
+	s.mu.Lock()
+	codecs := s.warm.randomAccess.Codecs()
+	s.mu.Unlock()
+
+	if len(codecs) > 0 {
+		lastStreamCodecs.Store(streamID, codecs)
+	}
+}
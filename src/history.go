@@ -0,0 +1,151 @@
+package src
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"threadfin/src/internal/history"
+)
+
+// maxStreamEvents limits the failover events kept per stream, older events are dropped
+const maxStreamEvents = 100
+
+// sessionHistory is the database of the finished client sessions (history.jsonl in the config folder)
+var sessionHistory *history.DB
+
+/*
+historyQueue passes the finished sessions to writeHistory. The clients are removed under the lock of the
+StreamManager, the sessions are written to the file outside of it.
+*/
+var historyQueue = make(chan history.Session, 1024)
+
+// HistoryStatsStruct is returned by the API command getHistoryStats
+type HistoryStatsStruct struct {
+	From        time.Time              `json:"from,omitempty"`
+	MostWatched []history.ChannelStats `json:"mostWatched"`
+	PeakTuners  []history.TunerPeak    `json:"peakTuners"`
+	Users       []history.UserStats    `json:"users"`
+}
+
+/*
+openHistory opens the session history and removes the sessions older than history.retention.days.
+The history is always opened, history.retention.days = 0 only stops recording new sessions.
+*/
+func openHistory() (err error) {
+	if sessionHistory, err = history.Open(System.File.History); err != nil {
+		return
+	}
+	pruneHistory()
+	go writeHistory()
+	return
+}
+
+// writeHistory appends the queued sessions to the session history
+func writeHistory() {
+	for session := range historyQueue {
+		if err := sessionHistory.Add(session); err != nil {
+			ShowError(err, 0)
+		}
+	}
+}
+
+// pruneHistory removes the sessions that are older than history.retention.days
+func pruneHistory() {
+	if sessionHistory == nil || Settings.HistoryRetention <= 0 {
+		return
+	}
+	removed, err := sessionHistory.Prune(time.Now().AddDate(0, 0, -Settings.HistoryRetention))
+	if err != nil {
+		ShowError(err, 0)
+		return
+	}
+	if removed > 0 {
+		ShowInfo(fmt.Sprintf("History:Removed %d sessions older than %d days", removed, Settings.HistoryRetention))
+	}
+}
+
+/*
+addEvent remembers a failover or error of the stream, the events are written to the sessions of the clients
+that were connected at this time.
+*/
+func (s *Stream) addEvent(eventType, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, history.Event{Time: time.Now(), Type: eventType, Message: message})
+	if len(s.events) > maxStreamEvents {
+		s.events = s.events[len(s.events)-maxStreamEvents:]
+	}
+}
+
+/*
+recordSession queues the session of the client for the history, it is called when the client is removed from the stream.
+The tuner limit video is not recorded.
+*/
+func (s *Stream) recordSession(streamID, clientID string, client *Client) {
+	if sessionHistory == nil || Settings.HistoryRetention <= 0 || streamID == "TunerLimitReached" {
+		return
+	}
+
+	var session = history.Session{
+		ID:         getMD5(fmt.Sprintf("%s-%d", clientID, client.started.UnixNano())),
+		Channel:    s.Name,
+		StreamID:   streamID,
+		PlaylistID: s.PlaylistID,
+		Playlist:   getProviderParameter(s.PlaylistID, GetPlaylistType(s.PlaylistID), "name"),
+		IP:         client.remoteAddr,
+		UserAgent:  client.userAgent,
+		User:       client.user,
+		Start:      client.started,
+		Stop:       time.Now(),
+		BytesSent:  client.hlsSent.Load(),
+	}
+	if ip, _, err := net.SplitHostPort(client.remoteAddr); err == nil {
+		session.IP = ip
+	}
+	if client.queue != nil {
+		session.BytesSent = client.queue.Stats().Sent
+	}
+
+	s.mu.Lock()
+	for _, event := range s.events {
+		if !event.Time.Before(client.started) {
+			session.Events = append(session.Events, event)
+		}
+	}
+	s.mu.Unlock()
+
+	select {
+	case historyQueue <- session:
+	default:
+		// The writer is behind, the caller must not wait for it
+		go func() { historyQueue <- session }()
+	}
+}
+
+// historyRange returns the start of the requested days, 0 days is the whole history
+func historyRange(days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// GetHistory returns the sessions of the last days, the oldest first
+func GetHistory(days int) []history.Session {
+	if sessionHistory == nil {
+		return nil
+	}
+	return sessionHistory.Sessions(historyRange(days), time.Time{})
+}
+
+// GetHistoryStats returns the most watched channels, the peak of concurrent tuners per provider and the watch time per user
+func GetHistoryStats(days int) *HistoryStatsStruct {
+	var sessions = GetHistory(days)
+	return &HistoryStatsStruct{
+		From:        historyRange(days),
+		MostWatched: history.MostWatched(sessions, 25),
+		PeakTuners:  history.PeakTuners(sessions),
+		Users:       history.WatchTimeByUser(sessions),
+	}
+}
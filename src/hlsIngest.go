@@ -0,0 +1,311 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"threadfin/src/internal/hls"
+	"threadfin/src/internal/mpegts"
+)
+
+const (
+	HLSUnsupportedError = 4017
+	HLSInvalidError     = 4050
+	HLSPlaylistError    = 4052
+	HLSSegmentError     = 4053
+	HLSDecryptionError  = 4054
+	HLSAudioError       = 4055
+)
+
+const (
+	hlsLiveEdgeSegments   = 3 // Number of segments behind the end of a live playlist to start with
+	hlsMaxSequentialError = 3 // Number of failed requests in a row before the ingest gives up
+)
+
+/*
+ingestHLS follows the given HLS playlist and writes the downloaded MPEG-TS segments
+as one continuous stream into HandleByteOutput
+*/
+func (sb *ThreadfinBuffer) ingestHLS(ctx context.Context, playlistURL string, body io.ReadCloser) {
+	pipeReader, pipeWriter := io.Pipe()
+	go sb.HandleByteOutput(pipeReader)
+
+	err := sb.followHLSPlaylist(ctx, playlistURL, body, pipeWriter)
+	if err != nil {
+		ShowDebug(fmt.Sprintf("Streaming:HLS ingest stopped: %s", err.Error()), 1)
+	}
+	pipeWriter.CloseWithError(err)
+}
+
+func (sb *ThreadfinBuffer) followHLSPlaylist(ctx context.Context, playlistURL string, body io.ReadCloser, w io.Writer) error {
+	master, media, err := parseHLSPlaylist(playlistURL, body)
+	if err != nil {
+		ShowError(err, HLSInvalidError)
+		return err
+	}
+
+	if master != nil {
+		variant := master.SelectVariant(Settings.M3U8AdaptiveBandwidthMBPS * 1000 * 1000)
+		if master.SeparateAudio(variant) {
+			// The segments of the variant contain no audio, the audio rendition is not muxed into the stream
+			err = errors.New(getErrMsg(HLSAudioError))
+			ShowError(err, HLSAudioError)
+			return err
+		}
+		ShowInfo(fmt.Sprintf("Streaming:HLS variant: %d kbit/s %s", variant.Bandwidth/1000, variant.Resolution))
+		playlistURL = variant.URI
+	}
+
+	var lastSequence int64 = -1
+	var errorCount int
+	var splicer mpegts.Splicer
+	keys := make(map[string][]byte)
+
+	for {
+		if media == nil {
+			media, err = sb.fetchHLSMediaPlaylist(ctx, playlistURL)
+			if err != nil {
+				errorCount++
+				ShowDebug(fmt.Sprintf("Streaming:Could not load HLS playlist (%d/%d): %s", errorCount, hlsMaxSequentialError, err.Error()), 1)
+				if errorCount >= hlsMaxSequentialError {
+					ShowError(err, HLSPlaylistError)
+					return err
+				}
+				if !sb.waitForNextPoll(ctx, time.Second) {
+					return nil
+				}
+				continue
+			}
+		}
+
+		if media.InitSection != "" {
+			err = errors.New(getErrMsg(HLSUnsupportedError))
+			ShowError(err, HLSUnsupportedError)
+			return err
+		}
+
+		segments := nextHLSSegments(media, lastSequence)
+		for _, segment := range segments {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			if segment.Discontinuity {
+				ShowDebug(fmt.Sprintf("Streaming:HLS discontinuity at segment %d", segment.Sequence), 2)
+			}
+
+			content, audio, err := sb.downloadHLSSegment(ctx, segment, keys)
+			if err != nil {
+				errorCount++
+				ShowDebug(fmt.Sprintf("Streaming:Skipped HLS segment %d (%d/%d): %s", segment.Sequence, errorCount, hlsMaxSequentialError, err.Error()), 1)
+				if errorCount >= hlsMaxSequentialError {
+					ShowError(err, HLSSegmentError)
+					return err
+				}
+				continue
+			}
+			errorCount = 0
+
+			// Packed audio segments (ADTS or MP3) make a radio stream
+			if lastSequence < 0 {
+				sb.Stream.setAudio(audio)
+			}
+
+			// The timestamps and continuity counters start over, the transport stream segments are spliced
+			if segment.Discontinuity && lastSequence >= 0 {
+				sb.Stream.resetWarmStart()
+				if audio == "" {
+					splicer.Splice(segmentPSI(content))
+				}
+			}
+			if audio == "" {
+				content = splicer.Write(content)
+			}
+
+			if _, err := w.Write(content); err != nil {
+				return err
+			}
+			lastSequence = segment.Sequence
+		}
+
+		if media.EndList {
+			return nil
+		}
+
+		// Poll again after one target duration, or half of it if the playlist had no new segments (RFC 8216 6.3.4)
+		wait := time.Duration(media.TargetDuration * float64(time.Second))
+		if len(segments) == 0 {
+			wait = wait / 2
+		}
+		if !sb.waitForNextPoll(ctx, wait) {
+			return nil
+		}
+		media = nil
+	}
+}
+
+// segmentPSI returns the PAT and PMT packets of the segment, nil if it has none
+func segmentPSI(content []byte) []byte {
+	var randomAccess mpegts.RandomAccess
+	for i := 0; i+mpegts.PacketSize <= len(content); i += mpegts.PacketSize {
+		randomAccess.Packet(content[i : i+mpegts.PacketSize])
+	}
+	return randomAccess.PSI()
+}
+
+// waitForNextPoll reports whether the ingest should go on after the given duration
+func (sb *ThreadfinBuffer) waitForNextPoll(ctx context.Context, wait time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-sb.CloseChan:
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+/*
+nextHLSSegments returns the segments of the playlist that have not been written yet.
+On the first call it starts close to the live edge, afterwards it follows the sliding window.
+*/
+func nextHLSSegments(media *hls.MediaPlaylist, lastSequence int64) []hls.Segment {
+	segments := media.Segments
+	if len(segments) == 0 {
+		return nil
+	}
+
+	first := segments[0].Sequence
+	last := segments[len(segments)-1].Sequence
+
+	switch {
+	case lastSequence < 0:
+		if !media.EndList && len(segments) > hlsLiveEdgeSegments {
+			return segments[len(segments)-hlsLiveEdgeSegments:]
+		}
+		return segments
+
+	case lastSequence+1 < first:
+		// The window moved on faster than we downloaded, continue with the oldest segment available
+		ShowDebug(fmt.Sprintf("Streaming:HLS lost %d segments", first-lastSequence-1), 1)
+		return segments
+
+	case lastSequence > last+int64(len(segments)):
+		// The media sequence has been reset by the server, start over at the live edge
+		ShowDebug("Streaming:HLS media sequence was reset", 1)
+		return nextHLSSegments(media, -1)
+	}
+
+	return segments[min(int(lastSequence+1-first), len(segments)):]
+}
+
+func parseHLSPlaylist(playlistURL string, body io.ReadCloser) (*hls.MasterPlaylist, *hls.MediaPlaylist, error) {
+	defer body.Close()
+
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hls.Parse(body, base)
+}
+
+func (sb *ThreadfinBuffer) fetchHLSMediaPlaylist(ctx context.Context, playlistURL string) (*hls.MediaPlaylist, error) {
+	resp, err := sb.get(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	master, media, err := parseHLSPlaylist(resp.Request.URL.String(), resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if master != nil {
+		return nil, errors.New("expected a media playlist but got a master playlist")
+	}
+	return media, nil
+}
+
+// downloadHLSSegment returns the decrypted segment and the content type of packed audio, which is empty for MPEG-TS
+func (sb *ThreadfinBuffer) downloadHLSSegment(ctx context.Context, segment hls.Segment, keys map[string][]byte) ([]byte, string, error) {
+	resp, err := sb.get(ctx, segment.URI)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var audio = audioContentType(resp)
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if segment.Key == nil {
+		return content, audio, nil
+	}
+
+	if segment.Key.Method != "AES-128" {
+		return nil, "", fmt.Errorf("unsupported HLS encryption method %s", segment.Key.Method)
+	}
+
+	key, ok := keys[segment.Key.URI]
+	if !ok {
+		keyResp, err := sb.get(ctx, segment.Key.URI)
+		if err != nil {
+			return nil, "", err
+		}
+		defer keyResp.Body.Close()
+
+		key, err = io.ReadAll(keyResp.Body)
+		if err != nil {
+			return nil, "", err
+		}
+		keys[segment.Key.URI] = key
+	}
+
+	content, err = decryptHLSSegment(content, key, segment)
+	if err != nil {
+		ShowError(err, HLSDecryptionError)
+	}
+	return content, audio, err
+}
+
+// decryptHLSSegment decrypts an AES-128 encrypted segment. Without an IV attribute the media sequence number is used as IV.
+func decryptHLSSegment(content, key []byte, segment hls.Segment) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) == 0 || len(content)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted segment is not a multiple of the block size")
+	}
+
+	iv := segment.Key.IV
+	if len(iv) != aes.BlockSize {
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(segment.Sequence))
+	}
+
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, content)
+
+	// Remove the PKCS7 padding
+	padding := int(content[len(content)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(content[len(content)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid padding in decrypted segment")
+	}
+	return content[:len(content)-padding], nil
+}
+
+// get requests the given URL with the HTTP client of the provider and treats every non 2xx status code as error
+func (sb *ThreadfinBuffer) get(ctx context.Context, rawURL string) (*http.Response, error) {
+	return doProviderRequest(ctx, http.MethodGet, sb.Stream.StreamInfo, rawURL)
+}
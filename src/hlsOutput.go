@@ -0,0 +1,479 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avfs/avfs"
+
+	"threadfin/src/internal/mpegts"
+)
+
+// HLSSession identifies a HLS client between its playlist and segment requests
+type HLSSession struct {
+	PlaylistID string
+	StreamID   string
+	ClientID   string
+	LastSeen   time.Time
+}
+
+const (
+	hlsTargetDuration = 4 * time.Second  // Segments are cut at the first key frame after this duration
+	hlsWindow         = 30 * time.Second // Playback time of the live playlist
+	hlsMaxWindow      = 5 * time.Minute  // Limit of the time-shift window of the playlist, the segments are a copy of the buffer
+	hlsMinSegments    = 2
+	hlsStartTimeout   = 20 * time.Second
+	hlsSessionTimeout = 30 * time.Second
+)
+
+// hlsSegment is a completed HLS segment (hls/<Sequence>.ts within the stream folder)
+type hlsSegment struct {
+	Sequence      int
+	Duration      time.Duration
+	Size          int
+	Discontinuity bool
+}
+
+/*
+hlsSegments cuts the broadcasted data of a stream into HLS segments. Other than the buffer files the segments
+start with the PAT and PMT at a key frame and last about hlsTargetDuration. The segments of the playlist window
+and one more are kept, the oldest one could still be requested by a client. Their size counts toward the buffer quota.
+*/
+type hlsSegments struct {
+	mu              sync.Mutex
+	fileSystem      avfs.VFS
+	folder          string
+	segmenter       *mpegts.Segmenter
+	segments        []hlsSegment
+	next            int
+	discontinuities int   // Discontinuities of the removed segments (EXT-X-DISCONTINUITY-SEQUENCE)
+	bytes           int64 // Size of the stored segments
+	closed          bool  // The stream has been removed
+}
+
+/*
+hlsPlaylistWindow returns the playback time of the playlist, the player can seek back through the time-shift window.
+The window is limited to hlsMaxWindow, the buffer quota may trim it further.
+*/
+func hlsPlaylistWindow() time.Duration {
+	return min(max(hlsWindow, time.Duration(Settings.BufferTimeshift)*time.Minute), hlsMaxWindow)
+}
+
+// write cuts the data into segments, the caller must hold the lock
+func (h *hlsSegments) write(data []byte) {
+	if h.closed {
+		return
+	}
+	for _, segment := range h.segmenter.Write(data) {
+		if err := h.add(segment); err != nil {
+			ShowError(err, CreateFileError)
+		}
+	}
+}
+
+// add stores the segment and removes the segments that left the window, the caller must hold the lock
+func (h *hlsSegments) add(segment mpegts.Segment) error {
+	if err := h.fileSystem.MkdirAll(h.folder, 0755); err != nil {
+		return err
+	}
+	if err := h.fileSystem.WriteFile(filepath.Join(h.folder, fmt.Sprintf("%d.ts", h.next)), segment.Data, 0644); err != nil {
+		return err
+	}
+	h.segments = append(h.segments, hlsSegment{Sequence: h.next, Duration: segment.Duration, Size: len(segment.Data), Discontinuity: segment.Discontinuity})
+	h.next++
+	h.bytes += int64(len(segment.Data))
+	bufferedBytes.Add(int64(len(segment.Data)))
+
+	for len(h.segments) > 2 && playbackTime(h.segments[2:]) >= hlsPlaylistWindow() {
+		h.removeOldest()
+	}
+	return nil
+}
+
+// removeOldest deletes the oldest segment, the caller must hold the lock
+func (h *hlsSegments) removeOldest() {
+	if h.segments[0].Discontinuity {
+		h.discontinuities++
+	}
+	h.fileSystem.Remove(filepath.Join(h.folder, fmt.Sprintf("%d.ts", h.segments[0].Sequence)))
+	h.bytes -= int64(h.segments[0].Size)
+	bufferedBytes.Add(-int64(h.segments[0].Size))
+	h.segments = h.segments[1:]
+}
+
+// trim deletes the oldest segment for the buffer quota, the segments a player needs to start are kept
+func (h *hlsSegments) trim() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.segments) <= hlsMinSegments+1 {
+		return false
+	}
+	h.removeOldest()
+	return true
+}
+
+// size returns the size of the stored segments
+func (h *hlsSegments) size() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.bytes
+}
+
+// clear removes the segments from the buffer usage, the files are deleted with the stream folder
+func (h *hlsSegments) clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bufferedBytes.Add(-h.bytes)
+	h.segments, h.bytes, h.closed = nil, 0, true
+}
+
+// discontinuity drops the incomplete segment, the broadcast continues with other content
+func (h *hlsSegments) discontinuity() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.segmenter.Discontinuity()
+}
+
+/*
+playlist returns the newest segments that cover the playlist window
+and the number of discontinuities before the first one
+*/
+func (h *hlsSegments) playlist() ([]hlsSegment, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var start = len(h.segments)
+	for start > 0 && playbackTime(h.segments[start:]) < hlsPlaylistWindow() {
+		start--
+	}
+	// The oldest segment is kept for the clients that still request it, it isn't listed
+	if start == 0 && len(h.segments) > hlsMinSegments {
+		start = 1
+	}
+
+	var discontinuities = h.discontinuities
+	for _, segment := range h.segments[:start] {
+		if segment.Discontinuity {
+			discontinuities++
+		}
+	}
+	return append([]hlsSegment{}, h.segments[start:]...), discontinuities
+}
+
+// get returns the segment with the given sequence number
+func (h *hlsSegments) get(sequence int) (hlsSegment, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, segment := range h.segments {
+		if segment.Sequence == sequence {
+			return segment, true
+		}
+	}
+	return hlsSegment{}, false
+}
+
+func (h *hlsSegments) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.segments)
+}
+
+func playbackTime(segments []hlsSegment) (duration time.Duration) {
+	for _, segment := range segments {
+		duration += segment.Duration
+	}
+	return
+}
+
+/*
+startHLS starts to cut the broadcast into HLS segments. The first segment starts with the warm start data,
+so the player doesn't wait for the next key frame.
+*/
+func (s *Stream) startHLS(fileSystem avfs.VFS) *hlsSegments {
+	s.mu.Lock()
+	if s.hls != nil {
+		s.mu.Unlock()
+		return s.hls
+	}
+	var hls = &hlsSegments{fileSystem: fileSystem, folder: filepath.Join(s.Folder, "hls"), segmenter: mpegts.NewSegmenter(hlsTargetDuration)}
+	hls.mu.Lock()
+	defer hls.mu.Unlock()
+	s.hls = hls
+	var warm = s.warm.data()
+	s.mu.Unlock()
+
+	// Broadcast waits for the lock, the data continues the warm start data
+	hls.write(warm)
+	return hls
+}
+
+// hlsOutput returns the HLS segments of the stream, nil if no HLS client joined yet
+func (s *Stream) hlsOutput() *hlsSegments {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hls
+}
+
+/*
+ServeHLS publishes the buffered segments of a stream as live HLS playlist.
+HLS clients take the same tuner slot as the MPEG-TS clients of the stream.
+*/
+func (sm *StreamManager) ServeHLS(streamInfo *StreamInfo, file string, w http.ResponseWriter, r *http.Request) {
+
+	if sm.LockAgainstNewStreams {
+		httpStatusError(w, http.StatusServiceUnavailable)
+		return
+	}
+
+	// Initialize buffer file system
+	if sm.FileSystem == nil {
+		sm.FileSystem = InitBufferVFS(Settings.StoreBufferInRAM)
+	}
+
+	sessionID := getHLSSessionID(streamInfo, r)
+
+	switch {
+	case file == "index.m3u8":
+		stream, err := sm.joinHLSSession(sessionID, streamInfo, r)
+		if err != nil {
+			ShowError(err, 0)
+			httpStatusError(w, http.StatusServiceUnavailable)
+			return
+		}
+		sm.serveHLSPlaylist(stream, sessionID, w, r)
+
+	case filepath.Ext(file) == ".ts":
+		sequence, err := strconv.Atoi(strings.TrimSuffix(file, ".ts"))
+		stream, client := sm.touchHLSSession(sessionID)
+		if err != nil || stream == nil {
+			httpStatusError(w, http.StatusNotFound)
+			return
+		}
+		client.hlsSent.Add(sm.serveHLSSegment(stream, sequence, w))
+
+	default:
+		httpStatusError(w, http.StatusNotFound)
+	}
+}
+
+/*
+HasHLSSession reports whether the request belongs to a running HLS session
+*/
+func (sm *StreamManager) HasHLSSession(streamInfo *StreamInfo, r *http.Request) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	_, exists := sm.HLSSessions[getHLSSessionID(streamInfo, r)]
+	return exists
+}
+
+/*
+getHLSSessionID returns the session from the URL.
+Clients that request the playlist without a session are identified by their IP address and user agent.
+*/
+func getHLSSessionID(streamInfo *StreamInfo, r *http.Request) string {
+	if session := r.URL.Query().Get("session"); session != "" {
+		return session
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return getMD5(fmt.Sprintf("%s-%s-%s", streamInfo.URLid, ip, r.Header.Get("User-Agent")))
+}
+
+func (sm *StreamManager) joinHLSSession(sessionID string, streamInfo *StreamInfo, r *http.Request) (*Stream, error) {
+	if stream, _ := sm.touchHLSSession(sessionID); stream != nil {
+		return stream, nil
+	}
+
+	var priority = getClientPriority(r)
+
+	// StartStream changes the URL ID if the tuner limit has been reached, don't touch the cached stream info
+	var info = *streamInfo
+	clientID, playlistID, err := sm.StartStream(&info, priority)
+	if err != nil {
+		return nil, err
+	}
+	if clientID == "" || playlistID == "" {
+		return nil, errors.New("could not start stream for HLS client")
+	}
+
+	sm.mu.Lock()
+	var stream *Stream
+	playlist, exists := sm.Playlists[playlistID]
+	if exists {
+		stream, exists = playlist.Streams[info.URLid]
+	}
+	if !exists || stream == nil {
+		sm.mu.Unlock()
+		return nil, errors.New("could not start stream for HLS client")
+	}
+	var first = stream.addClient(clientID, &Client{hls: true, priority: priority, remoteAddr: r.RemoteAddr, userAgent: r.UserAgent(), user: getStreamUser(r), started: time.Now()})
+
+	if info.URLid == "TunerLimitReached" {
+		// The tuner limit video can not be served as HLS, let the stop timer clean up the stream
+		sm.mu.Unlock()
+		sm.StopStream(playlistID, info.URLid, clientID)
+		return nil, errors.New(getErrMsg(4023))
+	}
+
+	stream.startHLS(sm.FileSystem)
+
+	// Make sure Broadcast is running only once
+	if first {
+		go stream.Broadcast()
+	}
+
+	sm.HLSSessions[sessionID] = &HLSSession{
+		PlaylistID: playlistID,
+		StreamID:   info.URLid,
+		ClientID:   clientID,
+		LastSeen:   time.Now(),
+	}
+	sm.mu.Unlock()
+
+	ShowInfo(fmt.Sprintf("Streaming:HLS client joined %s", info.URLid))
+	return stream, nil
+}
+
+// touchHLSSession updates the last activity of the session and returns its stream and client
+func (sm *StreamManager) touchHLSSession(sessionID string) (*Stream, *Client) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.HLSSessions[sessionID]
+	if !exists {
+		return nil, nil
+	}
+
+	if playlist, exists := sm.Playlists[session.PlaylistID]; exists {
+		if stream, exists := playlist.Streams[session.StreamID]; exists {
+			if client, exists := stream.getClient(session.ClientID); exists {
+				session.LastSeen = time.Now()
+				return stream, client
+			}
+		}
+	}
+
+	// The stream has been stopped or the client has been kicked in the meantime
+	delete(sm.HLSSessions, sessionID)
+	return nil, nil
+}
+
+/*
+expireHLSSessions removes the clients of HLS sessions that did not request anything for hlsSessionTimeout
+*/
+func (sm *StreamManager) expireHLSSessions() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var expired []*HLSSession
+
+		sm.mu.Lock()
+		for sessionID, session := range sm.HLSSessions {
+			if time.Since(session.LastSeen) > hlsSessionTimeout {
+				expired = append(expired, session)
+				delete(sm.HLSSessions, sessionID)
+			}
+		}
+		sm.mu.Unlock()
+
+		for _, session := range expired {
+			ShowInfo(fmt.Sprintf("Streaming:HLS session of %s expired", session.StreamID))
+			sm.StopStream(session.PlaylistID, session.StreamID, session.ClientID)
+		}
+	}
+}
+
+func (sm *StreamManager) serveHLSPlaylist(stream *Stream, sessionID string, w http.ResponseWriter, r *http.Request) {
+	var hls = stream.hlsOutput()
+	if hls == nil {
+		httpStatusError(w, http.StatusServiceUnavailable)
+		return
+	}
+
+	// Wait until enough segments have been cut for the player to start
+	var deadline = time.Now().Add(hlsStartTimeout)
+	for hls.len() < hlsMinSegments && time.Now().Before(deadline) {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	var available, discontinuities = hls.playlist()
+	if len(available) == 0 {
+		httpStatusError(w, http.StatusServiceUnavailable)
+		return
+	}
+
+	var targetDuration = hlsTargetDuration.Seconds()
+	for _, segment := range available {
+		targetDuration = math.Max(targetDuration, math.Ceil(segment.Duration.Seconds()))
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n")
+	playlist.WriteString("#EXT-X-VERSION:3\n")
+	playlist.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(targetDuration)))
+	playlist.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", available[0].Sequence))
+	if discontinuities > 0 {
+		playlist.WriteString(fmt.Sprintf("#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuities))
+	}
+	for _, segment := range available {
+		if segment.Discontinuity {
+			playlist.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		playlist.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", segment.Duration.Seconds()))
+		playlist.WriteString(fmt.Sprintf("%d.ts?session=%s\n", segment.Sequence, sessionID))
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(playlist.String()))
+}
+
+// serveHLSSegment sends the segment and returns the number of bytes sent
+func (sm *StreamManager) serveHLSSegment(stream *Stream, sequence int, w http.ResponseWriter) int64 {
+	var hls = stream.hlsOutput()
+	if hls == nil {
+		httpStatusError(w, http.StatusNotFound)
+		return 0
+	}
+	segment, exists := hls.get(sequence)
+	if !exists {
+		httpStatusError(w, http.StatusNotFound)
+		return 0
+	}
+
+	f, err := sm.FileSystem.Open(filepath.Join(hls.folder, fmt.Sprintf("%d.ts", sequence)))
+	if err != nil {
+		ShowError(err, OpenFileError)
+		httpStatusError(w, http.StatusNotFound)
+		return 0
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Content-Length", strconv.Itoa(segment.Size))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	n, err := io.Copy(w, f)
+	if err != nil {
+		ShowDebug(fmt.Sprintf("Streaming:Could not send HLS segment %d: %s", sequence, err.Error()), 3)
+	}
+	return n
+}
@@ -94,6 +94,11 @@ func ShowSystemInfo() {
 	fmt.Println("Settings [Streaming]")
 	fmt.Printf("Buffer:                   %s\n", Settings.Buffer)
 	fmt.Printf("UDPxy:                    %s\n", Settings.UDPxy)
+	fmt.Printf("UDP Interface:            %s\n", Settings.UDPInterface)
+	fmt.Printf("History Retention:        %d days\n", Settings.HistoryRetention)
+	fmt.Printf("Radio Remux:              %t\n", Settings.RadioRemux)
+	fmt.Printf("Radio in HDHR Lineup:     %t\n", Settings.RadioHDHR)
+	fmt.Printf("HEAD Requests:            %s\n", Settings.HeadMode)
 	fmt.Printf("Buffer Size:              %d KB\n", Settings.BufferSize)
 	fmt.Printf("Timeout:                  %d ms\n", int(Settings.BufferTimeout))
 	fmt.Printf("User Agent:               %s\n", Settings.UserAgent)
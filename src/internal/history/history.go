@@ -0,0 +1,158 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is a failover or error of the stream during the session
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // backup, reconnect, restart or error
+	Message string    `json:"message"`
+}
+
+// Session is a finished client connection of a stream
+type Session struct {
+	ID         string    `json:"id"`
+	Channel    string    `json:"channel"`
+	StreamID   string    `json:"streamID"`
+	PlaylistID string    `json:"playlistID"`
+	Playlist   string    `json:"playlist"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	User       string    `json:"user,omitempty"` // Authenticated user
+	Start      time.Time `json:"start"`
+	Stop       time.Time `json:"stop"`
+	BytesSent  int64     `json:"bytesSent"`
+	Events     []Event   `json:"events,omitempty"`
+}
+
+// Duration returns the watch time of the session
+func (s Session) Duration() time.Duration {
+	if s.Stop.Before(s.Start) {
+		return 0
+	}
+	return s.Stop.Sub(s.Start)
+}
+
+/*
+DB is a small embedded database for the sessions. It is an append-only file with one JSON object per line,
+all sessions are kept in memory. Prune rewrites the file without the expired sessions.
+*/
+type DB struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	sessions []Session
+}
+
+// Open loads the sessions of the file, the file is created if it doesn't exist. Damaged lines are skipped.
+func Open(path string) (*DB, error) {
+	var db = &DB{path: path}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var scanner = bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var session Session
+		if err := json.Unmarshal(scanner.Bytes(), &session); err == nil {
+			db.sessions = append(db.sessions, session)
+		}
+	}
+
+	if db.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Add appends the session to the database
+func (db *DB) Add(session Session) error {
+	line, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	db.sessions = append(db.sessions, session)
+	return nil
+}
+
+// Sessions returns the sessions that overlap the time range, a zero time is unlimited
+func (db *DB) Sessions(from, to time.Time) (sessions []Session) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, session := range db.sessions {
+		if !from.IsZero() && session.Stop.Before(from) {
+			continue
+		}
+		if !to.IsZero() && session.Start.After(to) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return
+}
+
+// Prune removes the sessions that ended before the given time and returns their number
+func (db *DB) Prune(before time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var kept []Session
+	var content []byte
+	for _, session := range db.sessions {
+		if session.Stop.Before(before) {
+			continue
+		}
+		line, err := json.Marshal(session)
+		if err != nil {
+			return 0, err
+		}
+		content = append(append(content, line...), '\n')
+		kept = append(kept, session)
+	}
+
+	var removed = len(db.sessions) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// Replace the file, the sessions are not lost if the write fails
+	var tmp = db.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return 0, err
+	}
+	db.file.Close()
+	if err := os.Rename(tmp, db.path); err != nil {
+		os.Remove(tmp)
+	}
+
+	var err error
+	if db.file, err = os.OpenFile(db.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return 0, err
+	}
+	db.sessions = kept
+	return removed, nil
+}
+
+// Close closes the file of the database
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.file.Close()
+}
@@ -0,0 +1,116 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+
+func session(channel, streamID, playlistID, ip, user string, start, stop int) Session {
+	return Session{
+		Channel:    channel,
+		StreamID:   streamID,
+		PlaylistID: playlistID,
+		Playlist:   "Provider " + playlistID,
+		IP:         ip,
+		User:       user,
+		Start:      base.Add(time.Duration(start) * time.Minute),
+		Stop:       base.Add(time.Duration(stop) * time.Minute),
+		BytesSent:  int64(stop-start) * 1000,
+	}
+}
+
+func TestDB(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "history.jsonl")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Session{
+		session("News", "1", "M1", "10.0.0.1", "", 0, 10),
+		session("Sport", "2", "M1", "10.0.0.2", "", 60, 70),
+	} {
+		if err := db.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	// A damaged line must not lose the other sessions
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString("{broken\n")
+	f.Close()
+
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if got := len(db.Sessions(time.Time{}, time.Time{})); got != 2 {
+		t.Fatalf("sessions = %d, want 2", got)
+	}
+	if got := db.Sessions(base.Add(30*time.Minute), time.Time{}); len(got) != 1 || got[0].Channel != "Sport" {
+		t.Fatalf("sessions after 30 minutes = %v", got)
+	}
+
+	removed, err := db.Prune(base.Add(30 * time.Minute))
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune = %d, %v", removed, err)
+	}
+	if err := db.Add(session("Movies", "3", "M2", "10.0.0.3", "", 80, 90)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Sessions(time.Time{}, time.Time{}); len(got) != 2 || got[0].Channel != "Sport" || got[1].Channel != "Movies" {
+		t.Fatalf("sessions after prune = %v", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	var sessions = []Session{
+		// Two clients share stream 1, it uses one tuner from 0 to 40
+		session("News", "1", "M1", "10.0.0.1", "alice", 0, 30),
+		session("News", "1", "M1", "10.0.0.2", "", 10, 40),
+		session("Sport", "2", "M1", "10.0.0.3", "bob", 20, 50),
+		// Stream 3 starts when stream 1 stops
+		session("Movies", "3", "M1", "10.0.0.1", "alice", 40, 100),
+		session("Kids", "4", "M2", "10.0.0.4", "", 0, 5),
+	}
+
+	watched := MostWatched(sessions, 2)
+	if len(watched) != 2 || watched[0].Channel != "Movies" || watched[1].Channel != "News" {
+		t.Fatalf("MostWatched = %v", watched)
+	}
+	if watched[1].Sessions != 2 || watched[1].WatchTime != 60*60 {
+		t.Fatalf("News = %+v", watched[1])
+	}
+
+	peaks := PeakTuners(sessions)
+	if len(peaks) != 2 {
+		t.Fatalf("PeakTuners = %v", peaks)
+	}
+	if peaks[0].PlaylistID != "M1" || peaks[0].Peak != 2 || !peaks[0].At.Equal(base.Add(20*time.Minute)) {
+		t.Fatalf("M1 = %+v", peaks[0])
+	}
+	if peaks[1].Peak != 1 || peaks[1].Playlist != "Provider M2" {
+		t.Fatalf("M2 = %+v", peaks[1])
+	}
+
+	users := WatchTimeByUser(sessions)
+	if len(users) != 4 || users[0].User != "alice" || users[0].WatchTime != 90*60 || users[0].Sessions != 2 {
+		t.Fatalf("WatchTimeByUser = %+v", users)
+	}
+	for _, user := range users {
+		if user.User == "10.0.0.2" && !user.Anonymous {
+			t.Fatalf("user without authentication is not anonymous: %+v", user)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// ChannelStats is the watch time of a channel
+type ChannelStats struct {
+	Channel    string `json:"channel"`
+	PlaylistID string `json:"playlistID"`
+	Sessions   int    `json:"sessions"`
+	WatchTime  int64  `json:"watchTime"` // Seconds
+}
+
+// TunerPeak is the highest number of streams of a provider that were running at the same time
+type TunerPeak struct {
+	PlaylistID string    `json:"playlistID"`
+	Playlist   string    `json:"playlist"`
+	Peak       int       `json:"peak"`
+	At         time.Time `json:"at"`
+}
+
+// UserStats is the watch time of a user, clients without authentication are counted by their IP address
+type UserStats struct {
+	User      string `json:"user"`
+	Anonymous bool   `json:"anonymous"`
+	Sessions  int    `json:"sessions"`
+	WatchTime int64  `json:"watchTime"` // Seconds
+	BytesSent int64  `json:"bytesSent"`
+}
+
+// MostWatched returns the channels with the longest watch time first, limit 0 returns all channels
+func MostWatched(sessions []Session, limit int) []ChannelStats {
+	var channels = make(map[[2]string]*ChannelStats)
+	for _, session := range sessions {
+		key := [2]string{session.PlaylistID, session.Channel}
+		if channels[key] == nil {
+			channels[key] = &ChannelStats{Channel: session.Channel, PlaylistID: session.PlaylistID}
+		}
+		channels[key].Sessions++
+		channels[key].WatchTime += int64(session.Duration().Seconds())
+	}
+
+	var stats = make([]ChannelStats, 0, len(channels))
+	for _, channel := range channels {
+		stats = append(stats, *channel)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].WatchTime != stats[j].WatchTime {
+			return stats[i].WatchTime > stats[j].WatchTime
+		}
+		return stats[i].Channel < stats[j].Channel
+	})
+
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
+
+/*
+PeakTuners returns the peak of concurrent tuners of every provider. A tuner is a running stream,
+the sessions of the clients that shared a stream are merged first.
+*/
+func PeakTuners(sessions []Session) []TunerPeak {
+	type interval struct{ start, stop time.Time }
+	type edge struct {
+		time  time.Time
+		delta int
+	}
+
+	var streams = make(map[string]map[string][]interval)
+	var names = make(map[string]string)
+	for _, session := range sessions {
+		if streams[session.PlaylistID] == nil {
+			streams[session.PlaylistID] = make(map[string][]interval)
+		}
+		streams[session.PlaylistID][session.StreamID] = append(streams[session.PlaylistID][session.StreamID], interval{session.Start, session.Stop})
+		names[session.PlaylistID] = session.Playlist
+	}
+
+	var peaks []TunerPeak
+	for playlistID, playlistStreams := range streams {
+		var edges []edge
+		for _, intervals := range playlistStreams {
+			sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+
+			// Merge the overlapping sessions of the stream
+			var current = intervals[0]
+			for _, next := range intervals[1:] {
+				if !next.start.After(current.stop) {
+					if next.stop.After(current.stop) {
+						current.stop = next.stop
+					}
+					continue
+				}
+				edges = append(edges, edge{current.start, 1}, edge{current.stop, -1})
+				current = next
+			}
+			edges = append(edges, edge{current.start, 1}, edge{current.stop, -1})
+		}
+
+		// A stream that stops frees the tuner before another one starts at the same time
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].time.Equal(edges[j].time) {
+				return edges[i].delta < edges[j].delta
+			}
+			return edges[i].time.Before(edges[j].time)
+		})
+
+		var peak = TunerPeak{PlaylistID: playlistID, Playlist: names[playlistID]}
+		var running int
+		for _, e := range edges {
+			running += e.delta
+			if running > peak.Peak {
+				peak.Peak, peak.At = running, e.time
+			}
+		}
+		peaks = append(peaks, peak)
+	}
+
+	sort.Slice(peaks, func(i, j int) bool { return peaks[i].PlaylistID < peaks[j].PlaylistID })
+	return peaks
+}
+
+// WatchTimeByUser returns the watch time of every user, the longest first
+func WatchTimeByUser(sessions []Session) []UserStats {
+	var users = make(map[string]*UserStats)
+	for _, session := range sessions {
+		var user, anonymous = session.User, false
+		if user == "" {
+			user, anonymous = session.IP, true
+		}
+		key := user
+		if anonymous {
+			key = "\x00" + user
+		}
+		if users[key] == nil {
+			users[key] = &UserStats{User: user, Anonymous: anonymous}
+		}
+		users[key].Sessions++
+		users[key].WatchTime += int64(session.Duration().Seconds())
+		users[key].BytesSent += session.BytesSent
+	}
+
+	var stats = make([]UserStats, 0, len(users))
+	for _, user := range users {
+		stats = append(stats, *user)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].WatchTime != stats[j].WatchTime {
+			return stats[i].WatchTime > stats[j].WatchTime
+		}
+		return stats[i].User < stats[j].User
+	})
+	return stats
+}
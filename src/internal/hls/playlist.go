@@ -0,0 +1,289 @@
+package hls
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrMissingHeader is returned when the playlist does not start with #EXTM3U
+var ErrMissingHeader = errors.New("#EXTM3U header is missing")
+
+// Variant is a single entry of a master playlist (#EXT-X-STREAM-INF)
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Resolution string
+	Codecs     string
+	Audio      string
+}
+
+// Rendition is an alternative rendition of a master playlist (#EXT-X-MEDIA), e.g. a separate audio track
+type Rendition struct {
+	Type    string
+	GroupID string
+	Name    string
+	URI     string // Empty if the rendition is part of the variant stream
+	Default bool
+}
+
+// Key describes the encryption of the following media segments (#EXT-X-KEY)
+type Key struct {
+	Method string
+	URI    string
+	IV     []byte
+}
+
+// Segment is a single media segment of a media playlist
+type Segment struct {
+	URI           string
+	Duration      float64
+	Sequence      int64
+	Discontinuity bool
+	Key           *Key
+}
+
+// MasterPlaylist contains all variants of a multivariant playlist
+type MasterPlaylist struct {
+	Variants   []Variant
+	Renditions []Rendition
+}
+
+// MediaPlaylist contains the segments of a media playlist
+type MediaPlaylist struct {
+	TargetDuration float64
+	MediaSequence  int64
+	Segments       []Segment
+	EndList        bool
+	InitSection    string
+}
+
+// Parse reads a playlist and returns either a master or a media playlist.
+// Relative URIs are resolved against the given base URL.
+func Parse(r io.Reader, base *url.URL) (*MasterPlaylist, *MediaPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "#EXTM3U") {
+		return nil, nil, ErrMissingHeader
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF") {
+			master, err := parseMaster(lines, base)
+			return master, nil, err
+		}
+	}
+
+	media, err := parseMedia(lines, base)
+	return nil, media, err
+}
+
+func parseMaster(lines []string, base *url.URL) (*MasterPlaylist, error) {
+	master := &MasterPlaylist{}
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "#EXT-X-MEDIA:") {
+			attributes := parseAttributes(strings.TrimPrefix(lines[i], "#EXT-X-MEDIA:"))
+			master.Renditions = append(master.Renditions, Rendition{
+				Type:    attributes["TYPE"],
+				GroupID: attributes["GROUP-ID"],
+				Name:    attributes["NAME"],
+				URI:     resolve(base, attributes["URI"]),
+				Default: attributes["DEFAULT"] == "YES",
+			})
+			continue
+		}
+		if !strings.HasPrefix(lines[i], "#EXT-X-STREAM-INF:") {
+			continue
+		}
+		attributes := parseAttributes(strings.TrimPrefix(lines[i], "#EXT-X-STREAM-INF:"))
+		// The URI is the next line that is not a tag
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], "#") {
+			i++
+		}
+		if i+1 >= len(lines) {
+			break
+		}
+		i++
+		variant := Variant{
+			URI:        resolve(base, lines[i]),
+			Resolution: attributes["RESOLUTION"],
+			Codecs:     attributes["CODECS"],
+			Audio:      attributes["AUDIO"],
+		}
+		variant.Bandwidth, _ = strconv.Atoi(attributes["BANDWIDTH"])
+		master.Variants = append(master.Variants, variant)
+	}
+	if len(master.Variants) == 0 {
+		return nil, errors.New("master playlist contains no variants")
+	}
+	return master, nil
+}
+
+func parseMedia(lines []string, base *url.URL) (*MediaPlaylist, error) {
+	media := &MediaPlaylist{}
+	var duration float64
+	var discontinuity bool
+	var key *Key
+	var sequence int64
+
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			media.TargetDuration, _ = strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			media.MediaSequence, _ = strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+			sequence = media.MediaSequence
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attributes := parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			if attributes["METHOD"] == "NONE" {
+				key = nil
+				continue
+			}
+			key = &Key{Method: attributes["METHOD"], URI: resolve(base, attributes["URI"])}
+			if iv := attributes["IV"]; iv != "" {
+				iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+				key.IV, _ = hex.DecodeString(iv)
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			media.InitSection = resolve(base, parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))["URI"])
+		case line == "#EXT-X-ENDLIST":
+			media.EndList = true
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			media.Segments = append(media.Segments, Segment{
+				URI:           resolve(base, line),
+				Duration:      duration,
+				Sequence:      sequence,
+				Discontinuity: discontinuity,
+				Key:           key,
+			})
+			sequence++
+			duration = 0
+			discontinuity = false
+		}
+	}
+
+	if media.TargetDuration <= 0 {
+		media.TargetDuration = 6
+	}
+	return media, nil
+}
+
+// SelectVariant returns the variant with the highest bandwidth that does not exceed maxBandwidth (bits per second).
+// If every variant exceeds the limit the one with the lowest bandwidth is returned. A maxBandwidth of 0 means no limit.
+// Variants with the audio in the variant stream are preferred over variants with a separate audio rendition.
+func (m *MasterPlaylist) SelectVariant(maxBandwidth int) Variant {
+	var muxed bool
+	for _, variant := range m.Variants {
+		muxed = muxed || !m.SeparateAudio(variant)
+	}
+
+	var best, lowest *Variant
+	for i := range m.Variants {
+		variant := &m.Variants[i]
+		if muxed && m.SeparateAudio(*variant) {
+			continue
+		}
+		if lowest == nil || variant.Bandwidth < lowest.Bandwidth {
+			lowest = variant
+		}
+		if maxBandwidth > 0 && variant.Bandwidth > maxBandwidth {
+			continue
+		}
+		if best == nil || variant.Bandwidth > best.Bandwidth {
+			best = variant
+		}
+	}
+	if best == nil {
+		return *lowest
+	}
+	return *best
+}
+
+/*
+SeparateAudio reports whether the audio of the variant is only available as separate rendition of its audio group.
+The variant stream doesn't contain the audio unless a rendition of the group has no URI.
+*/
+func (m *MasterPlaylist) SeparateAudio(variant Variant) bool {
+	if variant.Audio == "" {
+		return false
+	}
+	var separate bool
+	for _, rendition := range m.Renditions {
+		if rendition.Type != "AUDIO" || rendition.GroupID != variant.Audio {
+			continue
+		}
+		if rendition.URI == "" {
+			return false
+		}
+		separate = true
+	}
+	return separate
+}
+
+// parseAttributes splits an attribute list (KEY=VALUE,KEY="VALUE") into a map
+func parseAttributes(list string) map[string]string {
+	attributes := make(map[string]string)
+	var key strings.Builder
+	var value strings.Builder
+	inKey, quoted := true, false
+
+	flush := func() {
+		if key.Len() > 0 {
+			attributes[strings.TrimSpace(key.String())] = value.String()
+		}
+		key.Reset()
+		value.Reset()
+		inKey = true
+	}
+
+	for _, r := range list {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '=' && inKey:
+			inKey = false
+		case r == ',' && !quoted:
+			flush()
+		case inKey:
+			key.WriteRune(r)
+		default:
+			value.WriteRune(r)
+		}
+	}
+	flush()
+	return attributes
+}
+
+func resolve(base *url.URL, uri string) string {
+	if base == nil || uri == "" {
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return base.ResolveReference(u).String()
+}
@@ -0,0 +1,128 @@
+package hls
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const masterPlaylist = `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2"
+high/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720
+mid/index.m3u8
+`
+
+const mediaPlaylist = `#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:100
+#EXTINF:4.000,
+seg100.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin",IV=0x000102030405060708090a0b0c0d0e0f
+#EXTINF:3.5,
+seg101.ts
+#EXT-X-ENDLIST
+`
+
+func TestParseMaster(t *testing.T) {
+
+	base, _ := url.Parse("http://example.com/live/master.m3u8")
+	master, media, err := Parse(strings.NewReader(masterPlaylist), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if media != nil || master == nil {
+		t.Fatal("expected a master playlist")
+	}
+
+	if len(master.Variants) != 3 {
+		t.Fatalf("expected 3 variants, got %d", len(master.Variants))
+	}
+
+	if variant := master.SelectVariant(3000000); variant.URI != "http://example.com/live/mid/index.m3u8" {
+		t.Errorf("unexpected variant: %s", variant.URI)
+	}
+
+	if variant := master.SelectVariant(0); variant.Bandwidth != 5000000 {
+		t.Errorf("unexpected variant without limit: %d", variant.Bandwidth)
+	}
+
+	if variant := master.SelectVariant(100000); variant.Bandwidth != 800000 {
+		t.Errorf("expected the lowest variant, got %d", variant.Bandwidth)
+	}
+
+	if codecs := master.Variants[0].Codecs; codecs != "avc1.4d401e,mp4a.40.2" {
+		t.Errorf("quoted attribute not parsed: %s", codecs)
+	}
+}
+
+const demuxedPlaylist = `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="muxed",NAME="Main",DEFAULT=YES
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,AUDIO="aac"
+high/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,AUDIO="muxed"
+mid/index.m3u8
+`
+
+func TestSeparateAudio(t *testing.T) {
+
+	base, _ := url.Parse("http://example.com/live/master.m3u8")
+	master, _, err := Parse(strings.NewReader(demuxedPlaylist), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(master.Renditions) != 2 || master.Renditions[0].URI != "http://example.com/live/audio/en.m3u8" || !master.Renditions[0].Default {
+		t.Fatalf("unexpected renditions: %+v", master.Renditions)
+	}
+
+	if !master.SeparateAudio(master.Variants[0]) || master.SeparateAudio(master.Variants[1]) {
+		t.Error("audio groups not detected")
+	}
+
+	// The variant with the audio in the variant stream is preferred, regardless of the bandwidth
+	if variant := master.SelectVariant(0); variant.URI != "http://example.com/live/mid/index.m3u8" {
+		t.Errorf("unexpected variant: %s", variant.URI)
+	}
+
+	// Without another variant the one with the separate audio is returned
+	master.Variants = master.Variants[:1]
+	if variant := master.SelectVariant(0); variant.URI != "http://example.com/live/high/index.m3u8" {
+		t.Errorf("unexpected variant: %s", variant.URI)
+	}
+}
+
+func TestParseMedia(t *testing.T) {
+
+	base, _ := url.Parse("http://example.com/live/mid/index.m3u8")
+	_, media, err := Parse(strings.NewReader(mediaPlaylist), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if media.TargetDuration != 4 || media.MediaSequence != 100 || !media.EndList {
+		t.Errorf("unexpected playlist header: %+v", media)
+	}
+
+	if len(media.Segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(media.Segments))
+	}
+
+	second := media.Segments[1]
+	if second.Sequence != 101 || !second.Discontinuity || second.URI != "http://example.com/live/mid/seg101.ts" {
+		t.Errorf("unexpected segment: %+v", second)
+	}
+
+	if second.Key == nil || second.Key.Method != "AES-128" || len(second.Key.IV) != 16 {
+		t.Errorf("key not parsed: %+v", second.Key)
+	}
+
+	if _, _, err := Parse(strings.NewReader("seg.ts\n"), base); err != ErrMissingHeader {
+		t.Errorf("expected missing header error, got %v", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type ImageCache struct {
@@ -17,6 +18,8 @@ type ImageCache struct {
 	httpPool *sync.Pool
 	mutex sync.Mutex
 	wg sync.WaitGroup
+	hits atomic.Int64
+	misses atomic.Int64
 }
 
 // Create a new image cache
@@ -55,9 +58,11 @@ func (ic *ImageCache) GetImageURL(url string) (string) {
 	ic.mutex.Lock()
 	if cached_url, ok := ic.cache[key]; ok {
 		ic.mutex.Unlock()
+		ic.hits.Add(1)
 		return cached_url
 	}
 	ic.mutex.Unlock()
+	ic.misses.Add(1)
 
 	if ic.caching {
 		// Create the filename and path to the file
@@ -137,6 +142,11 @@ func (ic *ImageCache) WaitForDownloads() {
 	ic.wg.Wait()
 }
 
+// Number of image URLs found and not found in the cache
+func (ic *ImageCache) Stats() (hits int64, misses int64) {
+	return ic.hits.Load(), ic.misses.Load()
+}
+
 func (ic *ImageCache) GetNumCachedImages() int {
     ic.mutex.Lock()
     defer ic.mutex.Unlock()
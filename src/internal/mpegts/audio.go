@@ -0,0 +1,91 @@
+package mpegts
+
+// Stream types of the PMT with audio frames that can be played without a container
+var audioContentTypes = map[byte]string{
+	0x03: "audio/mpeg", // MPEG-1 audio (MP3)
+	0x04: "audio/mpeg", // MPEG-2 audio
+	0x0f: "audio/aac",  // AAC with ADTS headers
+}
+
+/*
+AudioExtractor returns the elementary stream of the first audio stream of the program. ADTS and MPEG audio frames
+carry their own headers, the PES payloads are concatenated. The packets have to be passed in order.
+*/
+type AudioExtractor struct {
+	pmtPID      int
+	pid         int
+	contentType string
+	known       bool
+	started     bool // The first PES header of the audio stream has been seen
+}
+
+/*
+ContentType returns the content type of the extracted audio. It is known once the PMT has been found,
+an empty content type means the program has no audio stream that can be extracted.
+*/
+func (a *AudioExtractor) ContentType() (string, bool) {
+	return a.contentType, a.known
+}
+
+// Packet returns the audio frames contained in the packet
+func (a *AudioExtractor) Packet(packet []byte) []byte {
+	if len(packet) < PacketSize || packet[0] != SyncByte {
+		return nil
+	}
+
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	start := packet[1]&0x40 != 0
+
+	switch {
+	case pid == 0:
+		if pmtPID, ok := programPMTPID(packet); ok && start {
+			a.pmtPID = pmtPID
+		}
+		return nil
+
+	case pid == a.pmtPID && a.pmtPID != 0:
+		if start {
+			a.parsePMT(packet)
+		}
+		return nil
+
+	case pid != a.pid || a.pid == 0:
+		return nil
+	}
+
+	payload, ok := Payload(packet)
+	if !ok {
+		return nil
+	}
+
+	if start {
+		if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+			return nil
+		}
+		a.started = true
+		return payload[min(9+int(payload[8]), len(payload)):]
+	}
+
+	if !a.started {
+		// The middle of a PES packet, the frames start with the next one
+		return nil
+	}
+	return payload
+}
+
+func (a *AudioExtractor) parsePMT(packet []byte) {
+	streams, ok := pmtStreams(packet)
+	if !ok {
+		return
+	}
+	for _, es := range streams {
+		if contentType, ok := audioContentTypes[es.streamType]; ok {
+			if es.pid != a.pid {
+				a.started = false
+			}
+			a.pid, a.contentType, a.known = es.pid, contentType, true
+			return
+		}
+	}
+	a.pid, a.contentType, a.known = 0, "", true
+}
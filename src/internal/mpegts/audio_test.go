@@ -0,0 +1,59 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAudioExtractor(t *testing.T) {
+
+	const audioPID = 0x101
+	var m = NewMuxer(nil)
+
+	// Program with a H.264 video stream and an AAC audio stream
+	pmt := appendCRC([]byte{0x02, 0xb0, 23, 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0xe0 | VideoPID>>8, VideoPID & 0xff, 0xf0, 0x00,
+		streamTypeH264, 0xe0 | VideoPID>>8, VideoPID & 0xff, 0xf0, 0x00,
+		0x0f, 0xe0 | audioPID>>8, audioPID & 0xff, 0xf0, 0x00})
+
+	pes := func(frame []byte) []byte {
+		header := []byte{0x00, 0x00, 0x01, 0xc0, 0x00, 0x00, 0x80, 0x80, 0x05, 0x21, 0x00, 0x01, 0x00, 0x01}
+		return m.packetize(audioPID, append(header, frame...), 0, true)
+	}
+
+	frame1 := bytes.Repeat([]byte{0xff, 0xf1, 0x50, 0x80}, 100) // Longer than one packet
+	frame2 := []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x02}
+
+	var data []byte
+	data = append(data, pes(frame1)[PacketSize:]...) // The middle of a PES packet before the tables
+	data = append(data, m.psiPacket(0, m.pat())...)
+	data = append(data, m.psiPacket(PMTPID, pmt)...)
+	data = append(data, pes(frame1)...)
+	data = append(data, m.packetize(VideoPID, []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x00, 0x00, 0x65}, 0, true)...)
+	data = append(data, pes(frame2)...)
+
+	var a AudioExtractor
+	if _, known := a.ContentType(); known {
+		t.Fatal("content type is known without PMT")
+	}
+
+	var out []byte
+	for i := 0; i < len(data); i += PacketSize {
+		out = append(out, a.Packet(data[i:i+PacketSize])...)
+	}
+
+	if contentType, known := a.ContentType(); !known || contentType != "audio/aac" {
+		t.Errorf("content type = %q, %v", contentType, known)
+	}
+	if want := append(append([]byte{}, frame1...), frame2...); !bytes.Equal(out, want) {
+		t.Errorf("unexpected audio:\n%x\nwant\n%x", out, want)
+	}
+
+	// A program without audio
+	var video AudioExtractor
+	video.Packet(m.psiPacket(0, m.pat()))
+	video.Packet(m.psiPacket(PMTPID, m.pmt()))
+	if contentType, known := video.ContentType(); !known || contentType != "" {
+		t.Errorf("content type without audio = %q, %v", contentType, known)
+	}
+}
@@ -0,0 +1,135 @@
+package mpegts
+
+import "time"
+
+const (
+	// PacketSize is the size of a single transport stream packet
+	PacketSize = 188
+	// SyncByte is the first byte of every transport stream packet
+	SyncByte = 0x47
+
+	ptsClock = 90000   // PTS values are counted in 90 kHz
+	ptsWrap  = 1 << 33 // PTS values are 33 bit wide
+)
+
+// Timestamps collects the presentation timestamps of the first elementary stream that carries one.
+// Data can be written in chunks of any size, packets spanning two writes are reassembled.
+type Timestamps struct {
+	pid   int
+	first int64
+	last  int64
+	found bool
+	carry []byte
+}
+
+// Write inspects the transport stream packets within p
+func (t *Timestamps) Write(p []byte) (int, error) {
+	data := p
+	if len(t.carry) > 0 {
+		data = append(t.carry, p...)
+		t.carry = nil
+	}
+
+	for len(data) >= PacketSize {
+		if data[0] != SyncByte {
+			// Lost the packet boundary, search for the next sync byte
+			data = data[1:]
+			continue
+		}
+		t.inspect(data[:PacketSize])
+		data = data[PacketSize:]
+	}
+
+	if len(data) > 0 {
+		t.carry = append([]byte{}, data...)
+	}
+	return len(p), nil
+}
+
+// Last returns the latest timestamp found so far
+func (t *Timestamps) Last() (int64, bool) {
+	return t.last, t.found
+}
+
+// Duration returns the time between the first and the latest timestamp found so far
+func (t *Timestamps) Duration() (time.Duration, bool) {
+	if !t.found {
+		return 0, false
+	}
+	return Since(t.first, t.last), true
+}
+
+// Since returns the time between two timestamps, taking the 33 bit wrap around into account
+func Since(from, to int64) time.Duration {
+	diff := (to - from + ptsWrap) % ptsWrap
+	return time.Duration(diff) * time.Second / ptsClock
+}
+
+func (t *Timestamps) inspect(packet []byte) {
+	pts, pid, ok := PTS(packet)
+	if !ok {
+		return
+	}
+
+	if !t.found {
+		t.pid = pid
+		t.first = pts
+		t.found = true
+	}
+
+	if pid == t.pid {
+		t.last = pts
+	}
+}
+
+/*
+PTS returns the presentation timestamp and the PID of the packet.
+The boolean is false if the packet does not start a PES packet with a PTS.
+*/
+func PTS(packet []byte) (int64, int, bool) {
+	if len(packet) < PacketSize || packet[0] != SyncByte {
+		return 0, 0, false
+	}
+
+	// Only the first packet of a PES packet contains the header
+	if packet[1]&0x40 == 0 {
+		return 0, 0, false
+	}
+
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	payload, ok := Payload(packet)
+	if !ok || len(payload) < 14 {
+		return 0, 0, false
+	}
+
+	// PES start code and audio or video stream ID
+	if payload[0] != 0 || payload[1] != 0 || payload[2] != 1 || payload[3] < 0xc0 || payload[3] > 0xef {
+		return 0, 0, false
+	}
+
+	if payload[7]&0x80 == 0 {
+		return 0, 0, false
+	}
+
+	b := payload[9:14]
+	pts := int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+	return pts, pid, true
+}
+
+// Payload returns the payload of the packet behind the adaptation field
+func Payload(packet []byte) ([]byte, bool) {
+	adaptationFieldControl := packet[3] >> 4 & 0x03
+	if adaptationFieldControl&0x01 == 0 {
+		return nil, false
+	}
+
+	offset := 4
+	if adaptationFieldControl&0x02 != 0 {
+		offset += 1 + int(packet[4])
+	}
+
+	if offset >= len(packet) {
+		return nil, false
+	}
+	return packet[offset:], true
+}
@@ -0,0 +1,55 @@
+package mpegts
+
+import (
+	"testing"
+	"time"
+)
+
+// pesPacket creates a transport stream packet that starts a video PES packet with the given PTS
+func pesPacket(pid int, pts int64) []byte {
+	packet := make([]byte, PacketSize)
+	packet[0] = SyncByte
+	packet[1] = 0x40 | byte(pid>>8&0x1f)
+	packet[2] = byte(pid)
+	packet[3] = 0x10
+
+	pes := packet[4:]
+	copy(pes, []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05})
+	pes[9] = byte(0x21 | (pts>>29)&0x0e)
+	pes[10] = byte(pts >> 22)
+	pes[11] = byte(0x01 | (pts>>14)&0xfe)
+	pes[12] = byte(pts >> 7)
+	pes[13] = byte(0x01 | (pts<<1)&0xfe)
+	return packet
+}
+
+func TestPTS(t *testing.T) {
+
+	pts, pid, ok := PTS(pesPacket(0x100, 8589934000))
+	if !ok || pid != 0x100 || pts != 8589934000 {
+		t.Errorf("unexpected result: pts %d pid %d ok %t", pts, pid, ok)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+
+	var data []byte
+	data = append(data, pesPacket(0x100, 90000)...)
+	data = append(data, pesPacket(0x101, 1000)...) // Audio PID, must be ignored
+	data = append(data, pesPacket(0x100, 270000)...)
+
+	var timestamps Timestamps
+	// Write in chunks that do not match the packet boundaries
+	timestamps.Write(data[:100])
+	timestamps.Write(data[100:400])
+	timestamps.Write(data[400:])
+
+	duration, ok := timestamps.Duration()
+	if !ok || duration != 2*time.Second {
+		t.Errorf("unexpected duration: %s", duration)
+	}
+
+	if since := Since(ptsWrap-90000, 90000); since != 2*time.Second {
+		t.Errorf("wrap around not handled: %s", since)
+	}
+}
@@ -0,0 +1,150 @@
+package mpegts
+
+import "io"
+
+const (
+	// PMTPID is the PID of the program map table written by the Muxer
+	PMTPID = 0x1000
+	// VideoPID is the PID of the video elementary stream written by the Muxer
+	VideoPID = 0x100
+
+	streamTypeH264 = 0x1b
+	pcrDelay       = ptsClock / 10 // The PCR runs 100 ms ahead of the PTS
+)
+
+/*
+Muxer writes H.264 access units as a single program transport stream.
+PAT and PMT are repeated in front of every key frame, every frame starts with a PCR.
+*/
+type Muxer struct {
+	w          io.Writer
+	continuity map[int]byte
+	tables     bool
+}
+
+// NewMuxer returns a Muxer that writes the transport stream packets to w
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w, continuity: make(map[int]byte)}
+}
+
+// WriteH264 writes the access unit in Annex B format with the presentation timestamp in 90 kHz
+func (m *Muxer) WriteH264(data []byte, pts int64, key bool) error {
+	var out []byte
+	if key || !m.tables {
+		out = append(out, m.psiPacket(0, m.pat())...)
+		out = append(out, m.psiPacket(PMTPID, m.pmt())...)
+		m.tables = true
+	}
+
+	pts %= ptsWrap
+	pcr := (pts - pcrDelay + ptsWrap) % ptsWrap
+
+	// PES header with the PTS, the length 0 is allowed for video
+	pes := []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05,
+		byte(0x21 | (pts>>29)&0x0e), byte(pts >> 22), byte(0x01 | (pts>>14)&0xfe), byte(pts >> 7), byte(0x01 | (pts<<1)&0xfe)}
+	pes = append(pes, data...)
+
+	out = append(out, m.packetize(VideoPID, pes, pcr, key)...)
+	_, err := m.w.Write(out)
+	return err
+}
+
+// packetize splits the PES packet into transport stream packets, the last packet is filled with stuffing bytes
+func (m *Muxer) packetize(pid int, data []byte, pcr int64, randomAccess bool) (out []byte) {
+	for first := true; len(data) > 0; first = false {
+		var adaptation []byte // Adaptation field without the length byte
+		if first {
+			flags := byte(0x10)
+			if randomAccess {
+				flags |= 0x40
+			}
+			adaptation = []byte{flags, byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1), byte(pcr&0x01)<<7 | 0x7e, 0x00}
+		}
+
+		space := PacketSize - 4
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+
+		if len(data) < space {
+			stuffing := space - len(data)
+			if adaptation == nil {
+				// The length byte is part of the stuffing
+				stuffing--
+				adaptation = []byte{}
+				if stuffing > 0 {
+					adaptation = append(adaptation, 0x00)
+					stuffing--
+				}
+			}
+			for ; stuffing > 0; stuffing-- {
+				adaptation = append(adaptation, 0xff)
+			}
+			space = len(data)
+		}
+
+		packet := m.header(pid, first, adaptation != nil)
+		if adaptation != nil {
+			packet = append(packet, byte(len(adaptation)))
+			packet = append(packet, adaptation...)
+		}
+		packet = append(packet, data[:space]...)
+		out = append(out, packet...)
+		data = data[space:]
+	}
+	return
+}
+
+// psiPacket writes the table section into a single packet
+func (m *Muxer) psiPacket(pid int, section []byte) []byte {
+	packet := m.header(pid, true, false)
+	packet = append(packet, 0x00) // Pointer field
+	packet = append(packet, section...)
+	for len(packet) < PacketSize {
+		packet = append(packet, 0xff)
+	}
+	return packet
+}
+
+func (m *Muxer) header(pid int, start, adaptation bool) []byte {
+	var b1 = byte(pid>>8) & 0x1f
+	if start {
+		b1 |= 0x40
+	}
+	var control = byte(0x10)
+	if adaptation {
+		control = 0x30
+	}
+	cc := m.continuity[pid]
+	m.continuity[pid] = (cc + 1) & 0x0f
+	return []byte{SyncByte, b1, byte(pid), control | cc}
+}
+
+func (m *Muxer) pat() []byte {
+	section := []byte{0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0x00, 0x01, 0xe0 | PMTPID>>8, PMTPID & 0xff}
+	return appendCRC(section)
+}
+
+func (m *Muxer) pmt() []byte {
+	section := []byte{0x02, 0xb0, 18, 0x00, 0x01, 0xc1, 0x00, 0x00,
+		0xe0 | VideoPID>>8, VideoPID & 0xff, 0xf0, 0x00,
+		streamTypeH264, 0xe0 | VideoPID>>8, VideoPID & 0xff, 0xf0, 0x00}
+	return appendCRC(section)
+}
+
+// appendCRC appends the CRC-32/MPEG-2 of the section
+func appendCRC(section []byte) []byte {
+	var crc uint32 = 0xffffffff
+	for _, b := range section {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
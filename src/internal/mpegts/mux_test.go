@@ -0,0 +1,60 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMuxer(t *testing.T) {
+
+	var out bytes.Buffer
+	var muxer = NewMuxer(&out)
+
+	frame := bytes.Repeat([]byte{0xab}, 1000)
+	if err := muxer.WriteH264(frame, 180000, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := muxer.WriteH264(frame[:10], 183600, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data := out.Bytes()
+	if len(data)%PacketSize != 0 {
+		t.Fatalf("unexpected length: %d", len(data))
+	}
+
+	// Same PAT as FFmpeg writes for a single program
+	pat := []byte{0x47, 0x40, 0x00, 0x10, 0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00, 0x2a, 0xb1, 0x04, 0xb2}
+	if !bytes.HasPrefix(data, pat) {
+		t.Errorf("unexpected PAT: %x", data[:len(pat)])
+	}
+
+	var payload []byte
+	var continuity = -1
+	var timestamps Timestamps
+	for i := 0; i < len(data); i += PacketSize {
+		packet := data[i : i+PacketSize]
+		if packet[0] != SyncByte {
+			t.Fatalf("missing sync byte in packet %d", i/PacketSize)
+		}
+		if pid := int(packet[1]&0x1f)<<8 | int(packet[2]); pid != VideoPID {
+			continue
+		}
+		if cc := int(packet[3] & 0x0f); continuity >= 0 && cc != (continuity+1)&0x0f {
+			t.Errorf("continuity error in packet %d", i/PacketSize)
+		} else {
+			continuity = cc
+		}
+		p, _ := Payload(packet)
+		payload = append(payload, p...)
+		timestamps.Write(packet)
+	}
+
+	// PES header of 14 bytes in front of every frame
+	if len(payload) != 2*14+len(frame)+10 {
+		t.Errorf("unexpected payload length: %d", len(payload))
+	}
+	if last, ok := timestamps.Last(); !ok || last != 183600 {
+		t.Errorf("unexpected PTS: %d", last)
+	}
+}
@@ -0,0 +1,205 @@
+package mpegts
+
+// Stream types of the PMT that carry video
+var videoStreamTypes = map[byte]bool{0x01: true, 0x02: true, 0x10: true, 0x1b: true, 0x24: true, 0x42: true, 0xea: true}
+
+// Codecs of the stream types with the names of FFmpeg, the layers of MPEG audio can't be told apart by the stream type
+var streamCodecs = map[byte]string{
+	0x01: "mpeg1video", 0x02: "mpeg2video", 0x10: "mpeg4", 0x1b: "h264", 0x24: "hevc", 0x42: "cavs", 0xea: "vc1",
+	0x03: "mpegaudio", 0x04: "mpegaudio", 0x0f: "aac", 0x11: "aac_latm", 0x81: "ac3", 0x87: "eac3",
+}
+
+const maxTablePackets = 4 // PAT and PMT longer than that are not cached
+
+/*
+RandomAccess keeps the latest PAT and PMT of the transport stream and finds the random access points
+of the first video stream. Without a video stream every PES packet of the first stream is a random access point.
+The packets have to be passed in order.
+*/
+type RandomAccess struct {
+	pmtPID     int
+	pid        int
+	streamType byte
+	codecs     []string
+	pat        [][]byte
+	pmt        [][]byte
+}
+
+// PSI returns the packets of the latest complete PAT and PMT, nil until both are known
+func (r *RandomAccess) PSI() []byte {
+	if len(r.pat) == 0 || len(r.pmt) == 0 || r.pid == 0 {
+		return nil
+	}
+	var psi []byte
+	for _, packet := range append(r.pat, r.pmt...) {
+		psi = append(psi, packet...)
+	}
+	return psi
+}
+
+// Codecs returns the codecs of the elementary streams of the latest PMT, streams of unknown types are left out
+func (r *RandomAccess) Codecs() []string {
+	return r.codecs
+}
+
+// Packet inspects the packet and reports whether a random access point starts with it
+func (r *RandomAccess) Packet(packet []byte) bool {
+	if len(packet) < PacketSize || packet[0] != SyncByte {
+		return false
+	}
+
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	start := packet[1]&0x40 != 0
+
+	switch {
+	case pid == 0:
+		r.pat = collectTable(r.pat, packet, start)
+		if start {
+			r.parsePAT(packet)
+		}
+		return false
+
+	case pid == r.pmtPID && r.pmtPID != 0:
+		r.pmt = collectTable(r.pmt, packet, start)
+		if start {
+			r.parsePMT(packet)
+		}
+		return false
+
+	case pid != r.pid || r.pid == 0:
+		return false
+	}
+
+	// Random access indicator of the adaptation field
+	if packet[3]&0x20 != 0 && packet[4] > 0 && packet[5]&0x40 != 0 {
+		return true
+	}
+
+	if !start {
+		return false
+	}
+	if !videoStreamTypes[r.streamType] {
+		return true
+	}
+	return r.keyFrame(packet)
+}
+
+// keyFrame searches the start of the PES packet for a sequence header or an IDR picture
+func (r *RandomAccess) keyFrame(packet []byte) bool {
+	payload, ok := Payload(packet)
+	if !ok || len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return false
+	}
+	es := payload[min(9+int(payload[8]), len(payload)):]
+
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0 || es[i+1] != 0 || es[i+2] != 1 {
+			continue
+		}
+		b := es[i+3]
+		switch r.streamType {
+		case 0x1b: // H.264: IDR slice or SPS
+			if nalType := b & 0x1f; nalType == 5 || nalType == 7 {
+				return true
+			}
+		case 0x24: // H.265: IRAP picture or parameter sets
+			if nalType := b >> 1 & 0x3f; nalType >= 16 && nalType <= 21 || nalType >= 32 && nalType <= 34 {
+				return true
+			}
+		case 0x01, 0x02: // MPEG-2: sequence header
+			if b == 0xb3 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *RandomAccess) parsePAT(packet []byte) {
+	if pid, ok := programPMTPID(packet); ok {
+		r.pmtPID = pid
+	}
+}
+
+func (r *RandomAccess) parsePMT(packet []byte) {
+	streams, ok := pmtStreams(packet)
+	if !ok {
+		return
+	}
+	r.codecs = nil
+	for _, es := range streams {
+		if codec, ok := streamCodecs[es.streamType]; ok {
+			r.codecs = append(r.codecs, codec)
+		}
+	}
+
+	var pid int
+	var streamType byte
+	for _, es := range streams {
+		if videoStreamTypes[es.streamType] {
+			pid, streamType = es.pid, es.streamType
+			break
+		}
+		if pid == 0 {
+			pid, streamType = es.pid, es.streamType
+		}
+	}
+	r.pid, r.streamType = pid, streamType
+}
+
+type elementaryStream struct {
+	pid        int
+	streamType byte
+}
+
+// programPMTPID returns the PMT PID of the first program of the PAT that isn't the network information table
+func programPMTPID(packet []byte) (int, bool) {
+	section, ok := psiSection(packet)
+	if !ok || section[0] != 0x00 || len(section) < 12 {
+		return 0, false
+	}
+	for programs := section[8:min(3+sectionLength(section)-4, len(section))]; len(programs) >= 4; programs = programs[4:] {
+		if programs[0] != 0 || programs[1] != 0 {
+			return int(programs[2]&0x1f)<<8 | int(programs[3]), true
+		}
+	}
+	return 0, false
+}
+
+// pmtStreams returns the elementary streams of the PMT in their order
+func pmtStreams(packet []byte) (streams []elementaryStream, ok bool) {
+	section, ok := psiSection(packet)
+	if !ok || section[0] != 0x02 || len(section) < 12 {
+		return nil, false
+	}
+	end := min(3+sectionLength(section)-4, len(section))
+	offset := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+
+	for ; offset+5 <= end; offset += 5 + (int(section[offset+3]&0x0f)<<8 | int(section[offset+4])) {
+		streams = append(streams, elementaryStream{pid: int(section[offset+1]&0x1f)<<8 | int(section[offset+2]), streamType: section[offset]})
+	}
+	return streams, true
+}
+
+// collectTable keeps the packets of the table section that started last
+func collectTable(table [][]byte, packet []byte, start bool) [][]byte {
+	if start {
+		table = nil
+	} else if len(table) == 0 || len(table) >= maxTablePackets {
+		return table
+	}
+	return append(table, append([]byte{}, packet[:PacketSize]...))
+}
+
+// psiSection returns the table section behind the pointer field
+func psiSection(packet []byte) ([]byte, bool) {
+	payload, ok := Payload(packet)
+	if !ok || len(payload) < 1 || 1+int(payload[0]) >= len(payload) {
+		return nil, false
+	}
+	return payload[1+int(payload[0]):], true
+}
+
+func sectionLength(section []byte) int {
+	return int(section[1]&0x0f)<<8 | int(section[2])
+}
@@ -0,0 +1,46 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRandomAccess(t *testing.T) {
+
+	var out bytes.Buffer
+	var muxer = NewMuxer(&out)
+
+	idr := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x65, 0x88}
+	slice := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x41, 0x9a}
+
+	muxer.WriteH264(slice, 90000, false) // Starts with PAT and PMT, but isn't a key frame
+	muxer.WriteH264(idr, 93600, true)
+	muxer.WriteH264(slice, 97200, false)
+
+	var ra RandomAccess
+	var keyFrames []int
+	data := out.Bytes()
+	for i := 0; i < len(data); i += PacketSize {
+		if ra.Packet(data[i : i+PacketSize]) {
+			keyFrames = append(keyFrames, i/PacketSize)
+		}
+	}
+
+	// PAT, PMT, slice, PAT, PMT, IDR, slice
+	if len(keyFrames) != 1 || keyFrames[0] != 5 {
+		t.Errorf("unexpected key frames: %v", keyFrames)
+	}
+	if codecs := ra.Codecs(); len(codecs) != 1 || codecs[0] != "h264" {
+		t.Errorf("unexpected codecs: %v", codecs)
+	}
+	if psi := ra.PSI(); !bytes.Equal(psi, data[3*PacketSize:5*PacketSize]) {
+		t.Errorf("unexpected PSI: %x", psi)
+	}
+
+	// Without the random access indicator the IDR slice is found in the elementary stream
+	packet := append([]byte{}, data[5*PacketSize:6*PacketSize]...)
+	packet[5] &^= 0x40
+	if !ra.Packet(packet) {
+		t.Error("IDR slice not detected")
+	}
+}
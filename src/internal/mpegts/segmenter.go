@@ -0,0 +1,129 @@
+package mpegts
+
+import "time"
+
+const (
+	maxTimestampGap   = 10 * time.Second // A larger jump of the timestamps is a discontinuity
+	maxSegmentFactor  = 3                // Without a random access point a segment is cut after three target durations
+	maxSegmentPackets = 128 * 1024       // Segments of streams without timestamps are cut by size (24 MB)
+)
+
+// Segment is a part of the transport stream that starts with the PAT and the PMT
+type Segment struct {
+	Data          []byte
+	Duration      time.Duration
+	Discontinuity bool // The timestamps don't continue the ones of the previous segment
+}
+
+/*
+Segmenter cuts the transport stream into segments for HLS. A segment starts at a random access point with the
+latest PAT and PMT and ends at the first random access point after the target duration. The duration is measured
+with the timestamps of the stream the random access points are found in.
+*/
+type Segmenter struct {
+	target        time.Duration
+	ra            RandomAccess
+	data          []byte // Current segment, nil while waiting for a random access point
+	first, last   int64  // Timestamps at the start of the segment and of the latest packet
+	timed         bool   // first and last are known
+	discontinuity bool   // The next segment follows a discontinuity
+	marked        bool   // The current segment follows a discontinuity
+	carry         []byte // Start of the packet that is completed by the next data
+}
+
+// NewSegmenter returns a Segmenter for segments of the given target duration
+func NewSegmenter(target time.Duration) *Segmenter {
+	return &Segmenter{target: target}
+}
+
+/*
+Discontinuity drops the current segment, the stream continues with other content.
+The next segment starts at the next random access point and is marked.
+*/
+func (s *Segmenter) Discontinuity() {
+	s.data, s.carry, s.timed, s.discontinuity = nil, nil, false, true
+}
+
+// Write inspects the data and returns the segments completed with it
+func (s *Segmenter) Write(data []byte) (segments []Segment) {
+	if len(s.carry) > 0 {
+		data = append(s.carry, data...)
+		s.carry = nil
+	}
+
+	for len(data) >= PacketSize {
+		if data[0] != SyncByte {
+			// Lost the packet boundary, search for the next sync byte
+			data = data[1:]
+			continue
+		}
+		segments = s.packet(data[:PacketSize], segments)
+		data = data[PacketSize:]
+	}
+
+	if len(data) > 0 {
+		s.carry = append([]byte{}, data...)
+	}
+	return segments
+}
+
+func (s *Segmenter) packet(packet []byte, segments []Segment) []Segment {
+	rap := s.ra.Packet(packet)
+
+	if pts, pid, ok := PTS(packet); ok && pid == s.ra.pid {
+		if gap := signedSince(s.last, pts); s.timed && (gap > maxTimestampGap || gap < -maxTimestampGap) {
+			// The segment ends before the jump, the next one starts at a random access point
+			segments = s.cut(segments)
+			s.timed, s.discontinuity = false, true
+		}
+		if !s.timed {
+			s.first, s.timed = pts, true
+		}
+		s.last = pts
+	}
+
+	var elapsed time.Duration
+	if s.timed {
+		elapsed = Since(s.first, s.last)
+	}
+	var overdue = elapsed >= maxSegmentFactor*s.target
+
+	if s.data != nil && (rap && elapsed >= s.target || overdue || len(s.data) >= maxSegmentPackets*PacketSize) {
+		segments = s.cut(segments)
+		s.first = s.last
+	}
+
+	if s.data == nil {
+		psi := s.ra.PSI()
+		if psi == nil || !rap && !overdue {
+			return segments
+		}
+		s.data, s.first = psi, s.last
+		s.marked, s.discontinuity = s.discontinuity, false
+	}
+	s.data = append(s.data, packet...)
+	return segments
+}
+
+// cut completes the current segment
+func (s *Segmenter) cut(segments []Segment) []Segment {
+	if s.data == nil {
+		return segments
+	}
+	var duration = s.target
+	if s.timed && s.last != s.first {
+		duration = Since(s.first, s.last)
+	}
+	segments = append(segments, Segment{Data: s.data, Duration: duration, Discontinuity: s.marked})
+	s.data = nil
+	return segments
+}
+
+// signedSince returns the time between two timestamps, negative if the second one is earlier
+func signedSince(from, to int64) time.Duration {
+	diff := (to - from + ptsWrap) % ptsWrap
+	if diff > ptsWrap/2 {
+		diff -= ptsWrap
+	}
+	return time.Duration(diff) * time.Second / ptsClock
+}
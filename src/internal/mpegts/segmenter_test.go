@@ -0,0 +1,72 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSegmenter(t *testing.T) {
+
+	var out bytes.Buffer
+	var muxer = NewMuxer(&out)
+
+	idr := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x65, 0x88}
+	slice := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x41, 0x9a}
+
+	// 25 frames per second with a key frame every second, starting in the middle of a GOP
+	var pts int64 = 90000
+	for frame := 10; frame < 25*12; frame++ {
+		if frame == 200 {
+			// The timestamps jump back by a minute
+			pts -= 60 * ptsClock
+		}
+		if frame%25 == 0 {
+			muxer.WriteH264(idr, pts, true)
+		} else {
+			muxer.WriteH264(slice, pts, false)
+		}
+		pts += ptsClock / 25
+	}
+
+	var s = NewSegmenter(2 * time.Second)
+	var segments []Segment
+	data := out.Bytes()
+	for i := 0; i < len(data); i += 1000 {
+		segments = append(segments, s.Write(data[i:min(i+1000, len(data))])...)
+	}
+
+	// Key frames every second, the jump at frame 200 ends the fourth segment early and marks the fifth
+	var durations []time.Duration
+	var discontinuities []int
+	for i, segment := range segments {
+		durations = append(durations, segment.Duration)
+		if segment.Discontinuity {
+			discontinuities = append(discontinuities, i)
+		}
+
+		var ra RandomAccess
+		ra.Packet(segment.Data[:PacketSize])
+		ra.Packet(segment.Data[PacketSize : 2*PacketSize])
+		if psi := segment.Data[:2*PacketSize]; !bytes.Equal(ra.PSI(), psi) {
+			t.Errorf("segment %d doesn't start with PAT and PMT", i)
+		}
+		if !ra.Packet(segment.Data[2*PacketSize : 3*PacketSize]) {
+			t.Errorf("segment %d doesn't start with a key frame", i)
+		}
+	}
+
+	want := []time.Duration{2 * time.Second, 2 * time.Second, 2 * time.Second, time.Second - 40*time.Millisecond, 2 * time.Second}
+	if len(durations) != len(want) {
+		t.Fatalf("unexpected durations: %v", durations)
+	}
+	for i := range want {
+		if durations[i] != want[i] {
+			t.Errorf("unexpected durations: %v", durations)
+			break
+		}
+	}
+	if len(discontinuities) != 1 || discontinuities[0] != 4 {
+		t.Errorf("unexpected discontinuities: %v", discontinuities)
+	}
+}
@@ -0,0 +1,103 @@
+package mpegts
+
+const (
+	nullPID      = 0x1fff
+	spliceWindow = 16 * 1024 // PIDs that don't appear within these packets after the switch are not marked
+)
+
+/*
+Splicer marks the switch from one transport stream to another one, e.g. from a looped slate to the live stream.
+The first packet of every PID after the switch is preceded by a packet with the discontinuity indicator, the
+decoder accepts the jump of the timestamps and of the continuity counter. The PSI tables of the new stream are
+repeated in front of it. The data can be written in chunks of any size, the stream is assumed to start at a
+packet boundary.
+*/
+type Splicer struct {
+	offset  int          // Bytes of the current packet within the previous data
+	psi     []byte       // Tables written in front of the next packet
+	marked  map[int]bool // PIDs marked since the switch, nil if there is nothing to mark
+	packets int          // Packets since the switch
+}
+
+// Splice marks the switch to another stream, psi are the PAT and PMT packets of the new stream (nil if unknown)
+func (s *Splicer) Splice(psi []byte) {
+	s.psi, s.marked, s.packets = psi, make(map[int]bool), 0
+}
+
+// Write returns the data with the marks of the switch
+func (s *Splicer) Write(data []byte) []byte {
+	if s.marked == nil {
+		s.offset = (s.offset + len(data)) % PacketSize
+		return data
+	}
+
+	// The rest of the packet that started within the previous data
+	var i = 0
+	if s.offset > 0 {
+		i = min(PacketSize-s.offset, len(data))
+	}
+	var out = append([]byte{}, data[:i]...)
+	s.offset = (s.offset + i) % PacketSize
+
+	for i < len(data) {
+		if data[i] != SyncByte {
+			// Lost the packet boundary, search for the next sync byte
+			out = append(out, data[i])
+			i++
+			continue
+		}
+
+		for len(s.psi) >= PacketSize {
+			out = s.mark(out, s.psi[:PacketSize])
+			out = append(out, s.psi[:PacketSize]...)
+			s.psi = s.psi[PacketSize:]
+		}
+
+		var end = min(i+PacketSize, len(data))
+		if end-i >= 4 {
+			// The header is enough to mark the packet, it may be completed by the next data
+			out = s.mark(out, data[i:end])
+		}
+		out = append(out, data[i:end]...)
+		s.offset = (end - i) % PacketSize
+		i = end
+
+		if s.packets++; s.packets >= spliceWindow {
+			s.psi, s.marked = nil, nil
+			s.offset = (s.offset + len(data) - i) % PacketSize
+			return append(out, data[i:]...)
+		}
+	}
+	return out
+}
+
+// mark writes the discontinuity packet in front of the first packet of the PID
+func (s *Splicer) mark(out []byte, packet []byte) []byte {
+	pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+	if pid == nullPID || s.marked[pid] {
+		return out
+	}
+	s.marked[pid] = true
+
+	// The counter is not incremented by packets without payload, the packet continues with it
+	cc := packet[3] & 0x0f
+	if packet[3]&0x10 != 0 {
+		cc = (cc + 0x0f) & 0x0f
+	}
+	return append(out, discontinuityPacket(pid, cc)...)
+}
+
+// discontinuityPacket returns a packet without payload that has the discontinuity indicator set
+func discontinuityPacket(pid int, cc byte) []byte {
+	packet := make([]byte, PacketSize)
+	packet[0] = SyncByte
+	packet[1] = byte(pid>>8) & 0x1f
+	packet[2] = byte(pid)
+	packet[3] = 0x20 | cc // Adaptation field only
+	packet[4] = PacketSize - 5
+	packet[5] = 0x80 // Discontinuity indicator
+	for i := 6; i < PacketSize; i++ {
+		packet[i] = 0xff
+	}
+	return packet
+}
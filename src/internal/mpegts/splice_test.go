@@ -0,0 +1,71 @@
+package mpegts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplicer(t *testing.T) {
+
+	var out bytes.Buffer
+	var muxer = NewMuxer(&out)
+
+	idr := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x65, 0x88}
+	slice := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x41, 0x9a}
+
+	muxer.WriteH264(idr, 90000, true)
+	muxer.WriteH264(slice, 93600, false)
+	live := append([]byte{}, out.Bytes()...)
+
+	var ra RandomAccess
+	for i := 0; i < len(live); i += PacketSize {
+		ra.Packet(live[i : i+PacketSize])
+	}
+	var psi = ra.PSI()
+
+	// The stream continues after the switch
+	out.Reset()
+	muxer.WriteH264(slice, 97200, false)
+	muxer.WriteH264(idr, 100800, true)
+	data := append(live, out.Bytes()...)
+
+	// The switch happens while a packet is written, the data continues in uneven chunks
+	var s Splicer
+	var split = len(live) - 100
+	var spliced = append([]byte{}, s.Write(data[:split])...)
+	s.Splice(psi)
+	for i := split; i < len(data); i += 50 {
+		spliced = append(spliced, s.Write(data[i:min(i+50, len(data))])...)
+	}
+
+	if !bytes.Equal(spliced[:len(live)], live) {
+		t.Fatal("the data in front of the switch has been changed")
+	}
+	if !bytes.Equal(spliced[len(live)+PacketSize:len(live)+2*PacketSize], psi[:PacketSize]) {
+		t.Error("the PAT doesn't follow the first mark")
+	}
+
+	// Every PID is marked in front of its first packet after the switch, the counters continue behind the marks
+	var marks []int
+	var previous = make(map[int]byte)
+	for i := len(live); i < len(spliced); i += PacketSize {
+		packet := spliced[i : i+PacketSize]
+		pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+		if packet[3]&0x20 != 0 && packet[5]&0x80 != 0 {
+			marks = append(marks, pid)
+			previous[pid] = packet[3] & 0x0f
+			continue
+		}
+		if cc, ok := previous[pid]; ok && packet[3]&0x10 != 0 && packet[3]&0x0f != (cc+1)&0x0f {
+			t.Errorf("continuity counter of PID %d jumps from %d to %d", pid, cc, packet[3]&0x0f)
+		}
+		previous[pid] = packet[3] & 0x0f
+	}
+
+	if len(marks) != 3 || marks[0] != 0 || marks[1] != PMTPID || marks[2] != VideoPID {
+		t.Errorf("unexpected marks: %v", marks)
+	}
+	if len(spliced) != len(data)+5*PacketSize {
+		t.Errorf("unexpected length %d", len(spliced))
+	}
+}
@@ -0,0 +1,128 @@
+package rtp
+
+// NAL unit types of H.264 (RFC 6184)
+const (
+	nalIDR   = 5
+	nalSPS   = 7
+	nalPPS   = 8
+	nalAUD   = 9
+	nalSTAPA = 24
+	nalFUA   = 28
+)
+
+var startCode = []byte{0x00, 0x00, 0x00, 0x01}
+
+// AccessUnit is a complete H.264 frame in Annex B format
+type AccessUnit struct {
+	Data      []byte
+	Timestamp uint32 // RTP timestamp, 90 kHz
+	Key       bool   // The frame contains an IDR slice
+}
+
+/*
+H264Depacketizer reassembles the NAL units of the RTP packets (single NAL unit, STAP-A and FU-A) to access units.
+SPS and PPS from the SDP are inserted in front of key frames that don't carry them.
+*/
+type H264Depacketizer struct {
+	SPS []byte
+	PPS []byte
+
+	nalus     [][]byte
+	fragment  []byte
+	timestamp uint32
+	lastSeq   uint16
+	started   bool
+}
+
+/*
+Push adds the RTP packet. It returns the access units that are complete, a frame ends with the marker bit
+or when the next packet has another timestamp.
+*/
+func (d *H264Depacketizer) Push(p Packet) (units []AccessUnit) {
+	if d.started && p.SequenceNumber != d.lastSeq+1 {
+		// Packet loss, an incomplete fragment can't be used
+		d.fragment = nil
+	}
+	d.lastSeq = p.SequenceNumber
+
+	if d.started && p.Timestamp != d.timestamp && len(d.nalus) > 0 {
+		units = append(units, d.flush())
+	}
+	d.started = true
+	d.timestamp = p.Timestamp
+
+	if len(p.Payload) == 0 {
+		return
+	}
+
+	switch nalType := p.Payload[0] & 0x1f; {
+	case nalType >= 1 && nalType <= 23:
+		d.nalus = append(d.nalus, append([]byte{}, p.Payload...))
+
+	case nalType == nalSTAPA:
+		data := p.Payload[1:]
+		for len(data) > 2 {
+			size := int(data[0])<<8 | int(data[1])
+			if size == 0 || len(data) < 2+size {
+				break
+			}
+			d.nalus = append(d.nalus, append([]byte{}, data[2:2+size]...))
+			data = data[2+size:]
+		}
+
+	case nalType == nalFUA:
+		if len(p.Payload) < 2 {
+			break
+		}
+		indicator, header := p.Payload[0], p.Payload[1]
+		if header&0x80 != 0 {
+			// Start of the fragmented NAL unit, the header is made of both bytes
+			d.fragment = []byte{indicator&0xe0 | header&0x1f}
+		} else if d.fragment == nil {
+			break
+		}
+		d.fragment = append(d.fragment, p.Payload[2:]...)
+		if header&0x40 != 0 {
+			d.nalus = append(d.nalus, d.fragment)
+			d.fragment = nil
+		}
+	}
+
+	if p.Marker && len(d.nalus) > 0 {
+		units = append(units, d.flush())
+	}
+	return
+}
+
+func (d *H264Depacketizer) flush() AccessUnit {
+	var unit = AccessUnit{Timestamp: d.timestamp}
+	var hasSPS, hasPPS bool
+	for _, nalu := range d.nalus {
+		switch nalu[0] & 0x1f {
+		case nalIDR:
+			unit.Key = true
+		case nalSPS:
+			hasSPS = true
+		case nalPPS:
+			hasPPS = true
+		}
+	}
+
+	unit.Data = append(unit.Data, startCode...)
+	unit.Data = append(unit.Data, nalAUD, 0xf0)
+	if unit.Key && !hasSPS && len(d.SPS) > 0 {
+		unit.Data = append(append(unit.Data, startCode...), d.SPS...)
+	}
+	if unit.Key && !hasPPS && len(d.PPS) > 0 {
+		unit.Data = append(append(unit.Data, startCode...), d.PPS...)
+	}
+	for _, nalu := range d.nalus {
+		if nalu[0]&0x1f == nalAUD {
+			continue
+		}
+		unit.Data = append(append(unit.Data, startCode...), nalu...)
+	}
+
+	d.nalus = nil
+	return unit
+}
@@ -0,0 +1,65 @@
+package rtp
+
+import (
+	"errors"
+)
+
+const (
+	// PayloadTypeMP2T is the static payload type of MPEG-TS (RFC 2250)
+	PayloadTypeMP2T = 33
+
+	headerSize = 12
+)
+
+var ErrInvalidPacket = errors.New("invalid RTP packet")
+
+// Packet is a RTP packet (RFC 3550)
+type Packet struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	Payload        []byte
+}
+
+// Parse reads the header of the RTP packet, the payload refers to the given data
+func Parse(data []byte) (Packet, error) {
+	var p Packet
+	if len(data) < headerSize || data[0]>>6 != 2 {
+		return p, ErrInvalidPacket
+	}
+
+	p.Marker = data[1]&0x80 != 0
+	p.PayloadType = data[1] & 0x7f
+	p.SequenceNumber = uint16(data[2])<<8 | uint16(data[3])
+	p.Timestamp = uint32(data[4])<<24 | uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7])
+	p.SSRC = uint32(data[8])<<24 | uint32(data[9])<<16 | uint32(data[10])<<8 | uint32(data[11])
+
+	offset := headerSize + 4*int(data[0]&0x0f) // CSRC list
+	end := len(data)
+
+	if data[0]&0x10 != 0 {
+		// Header extension: profile, length in 32 bit words
+		if len(data) < offset+4 {
+			return p, ErrInvalidPacket
+		}
+		offset += 4 + 4*(int(data[offset+2])<<8|int(data[offset+3]))
+	}
+
+	if data[0]&0x20 != 0 {
+		// Padding, the last byte contains the number of padding bytes
+		end -= int(data[len(data)-1])
+	}
+
+	if offset > end {
+		return p, ErrInvalidPacket
+	}
+	p.Payload = data[offset:end]
+	return p, nil
+}
+
+// IsRTP reports whether the datagram looks like a RTP packet and not like plain MPEG-TS
+func IsRTP(data []byte) bool {
+	return len(data) >= headerSize && data[0] != 0x47 && data[0]>>6 == 2
+}
@@ -0,0 +1,82 @@
+package rtp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func rtpPacket(seq uint16, timestamp uint32, marker bool, payload []byte) []byte {
+	packet := []byte{0x80, 96, byte(seq >> 8), byte(seq), byte(timestamp >> 24), byte(timestamp >> 16), byte(timestamp >> 8), byte(timestamp), 0, 0, 0, 1}
+	if marker {
+		packet[1] |= 0x80
+	}
+	return append(packet, payload...)
+}
+
+func TestParse(t *testing.T) {
+
+	// Padding of 3 bytes and one CSRC
+	data := []byte{0xa1, 0x80 | PayloadTypeMP2T, 0x01, 0x02, 0, 0, 0x03, 0xe8, 0, 0, 0, 1, 0, 0, 0, 2, 0x47, 0x11, 0, 0, 3}
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Marker || p.PayloadType != PayloadTypeMP2T || p.SequenceNumber != 0x0102 || p.Timestamp != 1000 {
+		t.Errorf("unexpected header: %+v", p)
+	}
+	if !bytes.Equal(p.Payload, []byte{0x47, 0x11}) {
+		t.Errorf("unexpected payload: %x", p.Payload)
+	}
+
+	if _, err := Parse([]byte{0x47, 0x00}); err == nil {
+		t.Error("short packet not detected")
+	}
+	if IsRTP(append([]byte{0x47}, make([]byte, 187)...)) {
+		t.Error("MPEG-TS detected as RTP")
+	}
+}
+
+func TestH264Depacketizer(t *testing.T) {
+
+	var d = H264Depacketizer{SPS: []byte{0x67, 0x01}, PPS: []byte{0x68, 0x02}}
+
+	// IDR slice as FU-A in two fragments
+	if units := d.Push(mustParse(t, rtpPacket(1, 3000, false, []byte{0x7c, 0x85, 0xaa}))); len(units) != 0 {
+		t.Fatalf("unexpected units after the first fragment: %d", len(units))
+	}
+	units := d.Push(mustParse(t, rtpPacket(2, 3000, true, []byte{0x7c, 0x45, 0xbb})))
+	if len(units) != 1 || !units[0].Key || units[0].Timestamp != 3000 {
+		t.Fatalf("unexpected units: %+v", units)
+	}
+
+	expected := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x67, 0x01, 0, 0, 0, 1, 0x68, 0x02, 0, 0, 0, 1, 0x65, 0xaa, 0xbb}
+	if !bytes.Equal(units[0].Data, expected) {
+		t.Errorf("unexpected access unit: %x", units[0].Data)
+	}
+
+	// A lost fragment drops the NAL unit
+	d.Push(mustParse(t, rtpPacket(3, 6000, false, []byte{0x7c, 0x81, 0xaa})))
+	units = d.Push(mustParse(t, rtpPacket(5, 6000, true, []byte{0x7c, 0x41, 0xbb})))
+	if len(units) != 0 {
+		t.Errorf("incomplete fragment was used: %+v", units)
+	}
+
+	// STAP-A with two NAL units, finished by the next timestamp
+	d.Push(mustParse(t, rtpPacket(6, 9000, false, []byte{0x18, 0x00, 0x02, 0x41, 0x01, 0x00, 0x02, 0x41, 0x02})))
+	units = d.Push(mustParse(t, rtpPacket(7, 12000, false, []byte{0x41, 0x03})))
+	if len(units) != 1 || units[0].Key || units[0].Timestamp != 9000 {
+		t.Fatalf("unexpected units: %+v", units)
+	}
+	if !bytes.Equal(units[0].Data, []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x41, 0x01, 0, 0, 0, 1, 0x41, 0x02}) {
+		t.Errorf("unexpected access unit: %x", units[0].Data)
+	}
+}
+
+func mustParse(t *testing.T, data []byte) Packet {
+	t.Helper()
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
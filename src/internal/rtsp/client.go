@@ -0,0 +1,386 @@
+package rtsp
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPort = "554"
+
+// StatusError is returned if the server answers a request with an error status
+type StatusError struct {
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("RTSP %s: %s", e.Method, e.Status)
+}
+
+// Response of the RTSP server
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+/*
+Client plays a RTSP stream with the RTP packets interleaved in the TCP connection (RFC 2326 10.12).
+The session is kept alive until Close is called.
+*/
+type Client struct {
+	UserAgent string
+	Track     Track // Selected track, set by Play
+	Channel   int   // Interleaved channel of the RTP packets
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	url       *url.URL
+	user      *url.Userinfo
+	challenge map[string]string // Parameters of the digest challenge
+	basic     bool
+	cseq      int
+	session   string
+	timeout   time.Duration
+
+	mu   sync.Mutex // Writes of the requests
+	done chan struct{}
+	once sync.Once
+}
+
+// Dial connects to the RTSP server of the URL
+func Dial(ctx context.Context, rawURL, userAgent string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "rtsp" {
+		return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var c = &Client{UserAgent: userAgent, conn: conn, reader: bufio.NewReaderSize(conn, 64*1024), user: u.User, timeout: 60 * time.Second, done: make(chan struct{})}
+	u.User = nil
+	c.url = u
+
+	// Stop the handshake if the context is canceled
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Now())
+		case <-c.done:
+		}
+	}()
+
+	return c, nil
+}
+
+/*
+Play runs DESCRIBE, SETUP and PLAY. The track is selected with SelectTrack,
+afterwards the RTP packets of the track are read with ReadPacket.
+*/
+func (c *Client) Play() error {
+	res, err := c.Do("DESCRIBE", c.url.String(), map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return err
+	}
+
+	base := res.Header.Get("Content-Base")
+	if base == "" {
+		base = res.Header.Get("Content-Location")
+	}
+	if base == "" {
+		base = c.url.String()
+	}
+
+	track, ok := SelectTrack(ParseSDP(string(res.Body), base))
+	if !ok {
+		return errors.New("RTSP: no MPEG-TS or H.264 track")
+	}
+	c.Track = track
+
+	res, err = c.Do("SETUP", track.Control, map[string]string{"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1"})
+	if err != nil {
+		return err
+	}
+
+	// Session: 12345678;timeout=60
+	session := strings.Split(res.Header.Get("Session"), ";")
+	c.session = strings.TrimSpace(session[0])
+	for _, parameter := range session[1:] {
+		if name, value, _ := strings.Cut(strings.TrimSpace(parameter), "="); name == "timeout" {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				c.timeout = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	for _, parameter := range strings.Split(res.Header.Get("Transport"), ";") {
+		if name, value, _ := strings.Cut(parameter, "="); name == "interleaved" {
+			c.Channel, _ = strconv.Atoi(strings.Split(value, "-")[0])
+		}
+	}
+
+	if _, err = c.Do("PLAY", base, map[string]string{"Range": "npt=0.000-"}); err != nil {
+		return err
+	}
+
+	go c.keepalive()
+	return nil
+}
+
+/*
+ReadPacket returns the next interleaved RTP packet of the selected track.
+Responses of the keepalive requests and RTCP packets are skipped.
+*/
+func (c *Client) ReadPacket() ([]byte, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+
+		b, err := c.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if b[0] != '$' {
+			if _, err := c.readResponse(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var header [4]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+
+		if int(header[1]) == c.Channel {
+			return data, nil
+		}
+	}
+}
+
+// Close ends the session with a TEARDOWN and closes the connection
+func (c *Client) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		if c.session != "" {
+			c.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+			c.write("TEARDOWN", c.url.String(), nil)
+		}
+	})
+	return c.conn.Close()
+}
+
+/*
+Do sends the request and returns the response. A 401 response is answered once with
+Basic or Digest authentication, if the URL contains the credentials.
+*/
+func (c *Client) Do(method, uri string, header map[string]string) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := c.write(method, uri, header); err != nil {
+			return nil, err
+		}
+
+		res, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode == 401 && attempt == 0 && c.user != nil {
+			c.setChallenge(res.Header.Get("WWW-Authenticate"))
+			continue
+		}
+
+		c.conn.SetDeadline(time.Time{})
+		if res.StatusCode != 200 {
+			return res, &StatusError{Method: method, StatusCode: res.StatusCode, Status: res.Status}
+		}
+		return res, nil
+	}
+}
+
+func (c *Client) write(method, uri string, header map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\nCSeq: %d\r\n", method, uri, c.cseq)
+	if c.UserAgent != "" {
+		fmt.Fprintf(&b, "User-Agent: %s\r\n", c.UserAgent)
+	}
+	if authorization := c.authorization(method, uri); authorization != "" {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", authorization)
+	}
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	for key, value := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+func (c *Client) readResponse() (*Response, error) {
+	for {
+		// Interleaved packets can arrive before the response
+		if b, err := c.reader.Peek(1); err == nil && b[0] == '$' {
+			var header [4]byte
+			if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+				return nil, err
+			}
+			if _, err := c.reader.Discard(int(header[2])<<8 | int(header[3])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		break
+	}
+
+	var reader = textproto.NewReader(c.reader)
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	// RTSP/1.0 200 OK
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "RTSP/") {
+		return nil, fmt.Errorf("RTSP: invalid response: %q", line)
+	}
+
+	var res = &Response{Status: strings.Join(fields[1:], " ")}
+	if res.StatusCode, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("RTSP: invalid status: %q", line)
+	}
+
+	if res.Header, err = reader.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+
+	if length, _ := strconv.Atoi(res.Header.Get("Content-Length")); length > 0 {
+		res.Body = make([]byte, length)
+		if _, err := io.ReadFull(c.reader, res.Body); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// keepalive refreshes the session within the timeout of the server, the responses are skipped by ReadPacket
+func (c *Client) keepalive() {
+	var ticker = time.NewTicker(c.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write("GET_PARAMETER", c.url.String(), nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) setChallenge(header string) {
+	scheme, parameters, _ := strings.Cut(header, " ")
+	if strings.EqualFold(scheme, "Basic") {
+		c.basic = true
+		return
+	}
+
+	c.challenge = make(map[string]string)
+	for _, parameter := range strings.Split(parameters, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+		c.challenge[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+}
+
+func (c *Client) authorization(method, uri string) string {
+	if c.user == nil {
+		return ""
+	}
+	username := c.user.Username()
+	password, _ := c.user.Password()
+
+	if c.basic {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+	if c.challenge == nil {
+		return ""
+	}
+
+	var realm, nonce, qop = c.challenge["realm"], c.challenge["nonce"], ""
+	if strings.Contains(c.challenge["qop"], "auth") {
+		qop = "auth"
+	}
+
+	var cnonce, nc string
+	if qop != "" {
+		var b = make([]byte, 8)
+		rand.Read(b)
+		cnonce, nc = hex.EncodeToString(b), fmt.Sprintf("%08x", c.cseq)
+	}
+
+	response := digestResponse(username, password, realm, nonce, method, uri, qop, nc, cnonce)
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`, username, realm, nonce, uri, response)
+	if qop != "" {
+		authorization += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if opaque := c.challenge["opaque"]; opaque != "" {
+		authorization += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return authorization
+}
+
+// digestResponse calculates the response of the digest authentication (RFC 2617)
+func digestResponse(username, password, realm, nonce, method, uri, qop, nc, cnonce string) string {
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+	if qop == "" {
+		return md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	}
+	return md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
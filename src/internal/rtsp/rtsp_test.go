@@ -0,0 +1,122 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+const testSDP = `v=0
+o=- 0 0 IN IP4 127.0.0.1
+s=Test
+a=control:*
+m=audio 0 RTP/AVP 97
+a=rtpmap:97 MPEG4-GENERIC/48000/2
+a=control:trackID=2
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0IAKeKQ,aM48gA==
+a=control:trackID=1
+`
+
+func TestParseSDP(t *testing.T) {
+
+	tracks := ParseSDP(testSDP, "rtsp://camera/live/")
+	if len(tracks) != 2 {
+		t.Fatalf("unexpected tracks: %+v", tracks)
+	}
+
+	track, ok := SelectTrack(tracks)
+	if !ok || track.Codec != "H264" || track.PayloadType != 96 || track.ClockRate != 90000 {
+		t.Fatalf("unexpected track: %+v", track)
+	}
+	if track.Control != "rtsp://camera/live/trackID=1" {
+		t.Errorf("unexpected control: %s", track.Control)
+	}
+	if !bytes.Equal(track.SPS, []byte{0x67, 0x42, 0x00, 0x29, 0xe2, 0x90}) || !bytes.Equal(track.PPS, []byte{0x68, 0xce, 0x3c, 0x80}) {
+		t.Errorf("unexpected parameter sets: %x %x", track.SPS, track.PPS)
+	}
+
+	// MPEG-TS is preferred
+	tracks = ParseSDP("m=video 0 RTP/AVP 96\na=rtpmap:96 H264/90000\nm=video 0 RTP/AVP 33\na=control:rtsp://other/ts\n", "rtsp://camera")
+	if track, ok := SelectTrack(tracks); !ok || track.Codec != "MP2T" || track.Control != "rtsp://other/ts" {
+		t.Errorf("unexpected track: %+v", track)
+	}
+}
+
+func TestDigestResponse(t *testing.T) {
+
+	// Example of RFC 2617
+	response := digestResponse("Mufasa", "Circle Of Life", "testrealm@host.com", "dcd98b7102dd2f0e8b11d0f600bfb0c093", "GET", "/dir/index.html", "auth", "00000001", "0a4f113b")
+	if response != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("unexpected response: %s", response)
+	}
+}
+
+func TestClient(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := textproto.NewReader(bufio.NewReader(conn))
+		for {
+			line, err := reader.ReadLine()
+			if err != nil {
+				return
+			}
+			header, _ := reader.ReadMIMEHeader()
+			cseq := header.Get("CSeq")
+
+			switch method := strings.Fields(line)[0]; {
+			case header.Get("Authorization") == "":
+				fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nWWW-Authenticate: Digest realm=\"test\", nonce=\"abc\"\r\n\r\n", cseq)
+			case method == "DESCRIBE":
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Base: rtsp://%s/live/\r\nContent-Length: %d\r\n\r\n%s", cseq, listener.Addr(), len(testSDP), testSDP)
+			case method == "SETUP":
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: 1234;timeout=60\r\nTransport: RTP/AVP/TCP;unicast;interleaved=2-3\r\n\r\n", cseq)
+			case method == "PLAY":
+				fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: 1234\r\n\r\n", cseq)
+				conn.Write([]byte{'$', 3, 0, 2, 0xff, 0xff}) // RTCP
+				conn.Write([]byte{'$', 2, 0, 3, 0x80, 0x60, 0x00})
+			case method == "TEARDOWN":
+				return
+			}
+		}
+	}()
+
+	client, err := Dial(context.Background(), fmt.Sprintf("rtsp://user:secret@%s/live", listener.Addr()), "Threadfin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Play(); err != nil {
+		t.Fatal(err)
+	}
+	if client.Channel != 2 || client.Track.Codec != "H264" {
+		t.Errorf("unexpected setup: channel %d track %+v", client.Channel, client.Track)
+	}
+
+	packet, err := client.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet, []byte{0x80, 0x60, 0x00}) {
+		t.Errorf("unexpected packet: %x", packet)
+	}
+}
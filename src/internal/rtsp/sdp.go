@@ -0,0 +1,119 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// Track is a media description of the SDP
+type Track struct {
+	Media       string // video, audio
+	Control     string // URL of the track for SETUP
+	PayloadType int
+	Codec       string // Encoding name of the rtpmap, H264 or MP2T
+	ClockRate   int
+	SPS         []byte // sprop-parameter-sets of H.264
+	PPS         []byte
+}
+
+// ParseSDP returns the tracks of the session description, the control URLs are resolved against base
+func ParseSDP(sdp string, base string) (tracks []Track) {
+	var track *Track
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		key, value := line[0], line[2:]
+
+		switch {
+		case key == 'm':
+			fields := strings.Fields(value)
+			if len(fields) < 4 {
+				track = nil
+				continue
+			}
+			tracks = append(tracks, Track{Media: fields[0], Control: base})
+			track = &tracks[len(tracks)-1]
+			track.PayloadType, _ = strconv.Atoi(fields[3])
+			if track.PayloadType == 33 {
+				track.Codec, track.ClockRate = "MP2T", 90000
+			}
+
+		case key == 'a' && strings.HasPrefix(value, "control:"):
+			control := resolveControl(base, strings.TrimPrefix(value, "control:"))
+			if track == nil {
+				// Session level control, base of the tracks
+				base = control
+				continue
+			}
+			track.Control = control
+
+		case key == 'a' && track != nil && strings.HasPrefix(value, "rtpmap:"):
+			// a=rtpmap:96 H264/90000
+			fields := strings.Fields(strings.TrimPrefix(value, "rtpmap:"))
+			if len(fields) < 2 || fields[0] != strconv.Itoa(track.PayloadType) {
+				continue
+			}
+			encoding := strings.Split(fields[1], "/")
+			track.Codec = strings.ToUpper(encoding[0])
+			if len(encoding) > 1 {
+				track.ClockRate, _ = strconv.Atoi(encoding[1])
+			}
+
+		case key == 'a' && track != nil && strings.HasPrefix(value, "fmtp:"):
+			// a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0IAH5Wo...,aM48gA==
+			fields := strings.SplitN(strings.TrimPrefix(value, "fmtp:"), " ", 2)
+			if len(fields) < 2 {
+				continue
+			}
+			for _, parameter := range strings.Split(fields[1], ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(parameter), "=")
+				if !strings.EqualFold(name, "sprop-parameter-sets") {
+					continue
+				}
+				for _, set := range strings.Split(value, ",") {
+					nalu, err := base64.StdEncoding.DecodeString(set)
+					if err != nil || len(nalu) == 0 {
+						continue
+					}
+					switch nalu[0] & 0x1f {
+					case 7:
+						track.SPS = nalu
+					case 8:
+						track.PPS = nalu
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// SelectTrack returns the track that can be ingested: MPEG-TS if available, otherwise the first H.264 video track
+func SelectTrack(tracks []Track) (Track, bool) {
+	for _, track := range tracks {
+		if track.Codec == "MP2T" {
+			return track, true
+		}
+	}
+	for _, track := range tracks {
+		if track.Media == "video" && track.Codec == "H264" {
+			return track, true
+		}
+	}
+	return Track{}, false
+}
+
+func resolveControl(base, control string) string {
+	switch {
+	case control == "*" || control == "":
+		return base
+	case strings.Contains(control, "://"):
+		return control
+	case strings.HasSuffix(base, "/"):
+		return base + control
+	}
+	return base + "/" + control
+}
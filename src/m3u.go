@@ -192,7 +192,7 @@ func buildM3U(groups []string) (m3u string, err error) {
 			if xepgChannel.TvgName == "" {
 				xepgChannel.TvgName = xepgChannel.Name
 			}
-			if xepgChannel.XActive && !xepgChannel.XHideChannel {
+			if xepgChannel.XActive && !xepgChannel.XHideChannel && !isDeadChannelHidden(xepgChannel) {
 				if len(groups) > 0 {
 
 					if indexOfString(xepgChannel.XGroupTitle, groups) == -1 {
@@ -232,9 +232,14 @@ func buildM3U(groups []string) (m3u string, err error) {
 		if channel.TvgLogo != "" {
 			logo = Data.Cache.Images.GetImageURL(channel.TvgLogo)
 		}
-		var parameter = fmt.Sprintf(`#EXTINF:0 channelID="%s" tvg-chno="%s" tvg-name="%s" tvg-id="%s" tvg-logo="%s" group-title="%s",%s`+"\n", channel.XEPG, channel.XChannelID, channel.XName, channel.XChannelID, logo, group, channel.XName)
+		// Radio channels are marked for the clients, the same way as in the playlists of the providers
+		radio := ""
+		if isRadioChannel(channel) {
+			radio = ` radio="true"`
+		}
+		var parameter = fmt.Sprintf(`#EXTINF:0 channelID="%s" tvg-chno="%s" tvg-name="%s" tvg-id="%s" tvg-logo="%s"%s group-title="%s",%s`+"\n", channel.XEPG, channel.XChannelID, channel.XName, channel.XChannelID, logo, radio, group, channel.XName)
 		var stream = ""
-		stream, err = createStreamingURL(channel.FileM3UID, channel.XChannelID, channel.XName, channel.URL, channel.BackupChannel1URL, channel.BackupChannel2URL, channel.BackupChannel3URL)
+		stream, err = createChannelStreamingURL(channel)
 		if err == nil {
 			m3u = m3u + parameter + stream + "\n"
 		} else {
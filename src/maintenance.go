@@ -13,6 +13,19 @@ func InitMaintenance() (err error) {
 
 	go maintenance()
 
+	err = loadRecordings()
+	if err != nil {
+		ShowError(err, 0)
+	}
+	go recorder.schedule()
+
+	go healthScanner.schedule()
+
+	err = openHistory()
+	if err != nil {
+		ShowError(err, 0)
+	}
+
 	return
 }
 
@@ -63,6 +76,11 @@ func maintenance() {
 
 			}
 
+			// Remove expired sessions from the history
+			if t.Format("1504") == "0400" {
+				pruneHistory()
+			}
+
 			// Update Threadfin (Binary)
 			if System.TimeForAutoUpdate == t.Format("1504") {
 				BinaryUpdate(false)
@@ -0,0 +1,219 @@
+package src
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricDefinitions are written in this order by /metrics, summaries consist of the samples name_sum and name_count
+var metricDefinitions = []struct {
+	name, kind, help string
+}{
+	{"threadfin_streams_active", "gauge", "Number of running upstream connections per playlist"},
+	{"threadfin_clients_active", "gauge", "Number of connected clients per playlist"},
+	{"threadfin_stream_bytes_total", "counter", "Bytes received from the upstream and relayed to the clients per stream"},
+	{"threadfin_buffer_restarts_total", "counter", "Restarts of the buffer input after the upstream failed"},
+	{"threadfin_backup_switches_total", "counter", "Switches to a backup channel"},
+	{"threadfin_provider_downloads_total", "counter", "Downloads of playlist and XMLTV files by result"},
+	{"threadfin_provider_download_duration_seconds", "summary", "Duration of the downloads of playlist and XMLTV files"},
+	{"threadfin_xepg_build_duration_seconds", "gauge", "Duration of the last XEPG build"},
+	{"threadfin_xepg_channels", "gauge", "Number of XEPG channels, all and active"},
+	{"threadfin_image_cache_hits", "gauge", "Image URLs found in the image cache since the last XEPG build"},
+	{"threadfin_image_cache_misses", "gauge", "Image URLs not found in the image cache since the last XEPG build"},
+	{"threadfin_ffmpeg_bitrate_kbps", "gauge", "Output bitrate of the FFmpeg buffer per stream"},
+	{"threadfin_ffmpeg_fps", "gauge", "Frames per second of the FFmpeg buffer per stream"},
+	{"threadfin_ffmpeg_dropped_frames", "gauge", "Frames dropped by the FFmpeg buffer per stream"},
+	{"threadfin_ffmpeg_speed", "gauge", "Processing speed of the FFmpeg buffer per stream, 1 is realtime"},
+}
+
+type metricSample struct {
+	name   string
+	labels string
+}
+
+// MetricsRegistry holds the counters, the gauges are collected when /metrics is requested
+type MetricsRegistry struct {
+	mu      sync.Mutex
+	samples map[metricSample]float64
+}
+
+var metrics = &MetricsRegistry{samples: make(map[metricSample]float64)}
+
+// formatMetricLabels turns the pairs of label names and values into the Prometheus label format
+func formatMetricLabels(labels ...string) string {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		var value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *MetricsRegistry) add(name string, value float64, labels ...string) {
+	m.addSample(metricSample{name, formatMetricLabels(labels...)}, value)
+}
+
+func (m *MetricsRegistry) addSample(sample metricSample, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples[sample] += value
+}
+
+func (m *MetricsRegistry) set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples[metricSample{name, formatMetricLabels(labels...)}] = value
+}
+
+// streamMetricLabels returns the labels of the stream metrics, they are formatted once when the stream is created
+func streamMetricLabels(streamInfo *StreamInfo) string {
+	return formatMetricLabels("playlist", getProviderParameter(streamInfo.PlaylistID, GetPlaylistType(streamInfo.PlaylistID), "name"), "channel", streamInfo.Name)
+}
+
+func (m *MetricsRegistry) streamBytes(s *Stream, n int) {
+	if n > 0 {
+		m.addSample(metricSample{"threadfin_stream_bytes_total", s.metricLabels}, float64(n))
+	}
+}
+
+func (m *MetricsRegistry) bufferRestart(s *Stream) {
+	m.addSample(metricSample{"threadfin_buffer_restarts_total", s.metricLabels}, 1)
+}
+
+func (m *MetricsRegistry) backupSwitch(s *Stream) {
+	m.addSample(metricSample{"threadfin_backup_switches_total", s.metricLabels}, 1)
+}
+
+func (m *MetricsRegistry) providerDownload(fileType, provider string, duration time.Duration, err error) {
+	var result = "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.add("threadfin_provider_downloads_total", 1, "type", fileType, "provider", provider, "result", result)
+	m.add("threadfin_provider_download_duration_seconds_sum", duration.Seconds(), "type", fileType, "provider", provider)
+	m.add("threadfin_provider_download_duration_seconds_count", 1, "type", fileType, "provider", provider)
+}
+
+func (m *MetricsRegistry) xepgBuild(duration time.Duration) {
+	m.set("threadfin_xepg_build_duration_seconds", duration.Seconds())
+}
+
+// collect returns the counters together with the current gauges
+func (m *MetricsRegistry) collect() map[metricSample]float64 {
+	var samples = make(map[metricSample]float64)
+	m.mu.Lock()
+	for sample, value := range m.samples {
+		samples[sample] = value
+	}
+	m.mu.Unlock()
+
+	var gauge = func(name string, value float64, labels ...string) {
+		samples[metricSample{name, formatMetricLabels(labels...)}] = value
+	}
+
+	streamManager.mu.Lock()
+	for playlistID, playlist := range streamManager.Playlists {
+		var streams, clients int
+		for streamID, stream := range playlist.Streams {
+			if streamID == "TunerLimitReached" || stream == nil {
+				continue
+			}
+			streams++
+			clients += stream.clientCount()
+
+			if buffer, ok := stream.Buffer.(*ThirdPartyBuffer); ok && buffer.Progress != nil {
+				if stats, ok := buffer.Progress.Get(); ok {
+					var labels = []string{"playlist", playlist.Name, "channel", stream.Name}
+					gauge("threadfin_ffmpeg_bitrate_kbps", stats.Bitrate, labels...)
+					gauge("threadfin_ffmpeg_fps", stats.FPS, labels...)
+					gauge("threadfin_ffmpeg_dropped_frames", float64(stats.DroppedFrames), labels...)
+					gauge("threadfin_ffmpeg_speed", stats.Speed, labels...)
+				}
+			}
+		}
+		gauge("threadfin_streams_active", float64(streams), "playlist", playlist.Name, "playlist_id", playlistID)
+		gauge("threadfin_clients_active", float64(clients), "playlist", playlist.Name, "playlist_id", playlistID)
+	}
+	streamManager.mu.Unlock()
+
+	gauge("threadfin_xepg_channels", float64(len(Data.XEPG.Channels)), "state", "all")
+	gauge("threadfin_xepg_channels", float64(Data.XEPG.XEPGCount), "state", "active")
+
+	if Data.Cache.Images != nil {
+		hits, misses := Data.Cache.Images.Stats()
+		gauge("threadfin_image_cache_hits", float64(hits))
+		gauge("threadfin_image_cache_misses", float64(misses))
+	}
+	return samples
+}
+
+// export writes all metrics in the Prometheus text format
+func (m *MetricsRegistry) export() string {
+	var samples = m.collect()
+
+	var builder strings.Builder
+	for _, definition := range metricDefinitions {
+		var names = []string{definition.name}
+		if definition.kind == "summary" {
+			names = []string{definition.name + "_sum", definition.name + "_count"}
+		}
+
+		var lines []string
+		for sample, value := range samples {
+			for _, name := range names {
+				if sample.name != name {
+					continue
+				}
+				var line = name
+				if sample.labels != "" {
+					line += "{" + sample.labels + "}"
+				}
+				lines = append(lines, line+" "+strconv.FormatFloat(value, 'g', -1, 64))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		sort.Strings(lines)
+
+		fmt.Fprintf(&builder, "# HELP %s %s\n", definition.name, definition.help)
+		fmt.Fprintf(&builder, "# TYPE %s %s\n", definition.name, definition.kind)
+		for _, line := range lines {
+			builder.WriteString(line + "\n")
+		}
+	}
+	return builder.String()
+}
+
+/*
+Metrics : Prometheus metrics /metrics
+The endpoint is part of the API. With API authentication enabled the user needs the API permission (basic auth).
+*/
+func Metrics(w http.ResponseWriter, r *http.Request) {
+
+	if !Settings.API {
+		httpStatusError(w, http.StatusLocked)
+		return
+	}
+
+	if Settings.AuthenticationAPI {
+		var authenticated bool
+		if _, _, ok := r.BasicAuth(); ok {
+			_, err := basicAuth(r, "authentication.api")
+			authenticated = err == nil
+		}
+		if !authenticated {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+System.Name+`"`)
+			httpStatusError(w, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(metrics.export()))
+}
@@ -0,0 +1,158 @@
+package src
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UpstreamForbiddenError    = 4030 //errMsg = "The provider denied access to the stream (403 Forbidden)"
+	UpstreamNotFoundError     = 4031 //errMsg = "The stream was not found at the provider (404 Not Found)"
+	UpstreamUnauthorizedError = 4032 //errMsg = "The provider requires authorization (401 Unauthorized)"
+	UpstreamRefusedError      = 4033 //errMsg = "The provider refused the connection"
+
+	processStopTimeout = 5 * time.Second // Time between SIGTERM and SIGKILL
+)
+
+// fatalStdErrPatterns are messages of FFmpeg and VLC after which the upstream won't deliver data
+var fatalStdErrPatterns = []struct {
+	pattern string
+	code    int
+}{
+	{"403 Forbidden", UpstreamForbiddenError},
+	{"404 Not Found", UpstreamNotFoundError},
+	{"401 Unauthorized", UpstreamUnauthorizedError},
+	{"Connection refused", UpstreamRefusedError},
+}
+
+// ProcessStats is the progress reported by FFmpeg with -progress
+type ProcessStats struct {
+	Bitrate         float64   `json:"bitrate"` // kbit/s
+	FPS             float64   `json:"fps"`
+	Frames          int64     `json:"frames"`
+	DroppedFrames   int64     `json:"droppedFrames"`
+	DuplicateFrames int64     `json:"duplicateFrames"`
+	Speed           float64   `json:"speed"` // 1 is realtime
+	Updated         time.Time `json:"updated"`
+}
+
+// ProcessProgress collects the progress of a running FFmpeg process
+type ProcessProgress struct {
+	mu    sync.Mutex
+	stats ProcessStats
+}
+
+/*
+parseLine reads a line of the -progress output. FFmpeg writes a block of key=value lines,
+every block ends with progress=continue or progress=end. It reports false for all other lines.
+*/
+func (p *ProcessProgress) parseLine(line string) bool {
+	key, value, found := strings.Cut(line, "=")
+	if !found {
+		return false
+	}
+	value = strings.TrimSpace(value)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch key {
+	case "bitrate":
+		p.stats.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "fps":
+		p.stats.FPS, _ = strconv.ParseFloat(value, 64)
+	case "frame":
+		p.stats.Frames, _ = strconv.ParseInt(value, 10, 64)
+	case "drop_frames":
+		p.stats.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "dup_frames":
+		p.stats.DuplicateFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "speed":
+		p.stats.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "progress":
+		p.stats.Updated = time.Now()
+	case "total_size", "out_time_us", "out_time_ms", "out_time":
+	default:
+		return strings.HasPrefix(key, "stream_")
+	}
+	return true
+}
+
+// Get returns the latest progress, ok is false if FFmpeg didn't report any progress yet
+func (p *ProcessProgress) Get() (stats ProcessStats, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats, !p.stats.Updated.IsZero()
+}
+
+/*
+handleStdErr reads the stderr of the third party tool line by line. The progress of FFmpeg is parsed,
+all other lines are logged. Known fatal messages are reported as input error, so the watchdog
+switches to the backup URL without waiting for the stall timeout.
+*/
+func (sb *ThirdPartyBuffer) handleStdErr(stdErr io.ReadCloser, progress *ProcessProgress) {
+	defer stdErr.Close()
+
+	scanner := bufio.NewScanner(stdErr)
+	scanner.Split(bufio.ScanLines)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || progress.parseLine(line) {
+			continue
+		}
+		ShowInfo(fmt.Sprintf("%s log:%s", sb.BufferType, line))
+
+		for _, fatal := range fatalStdErrPatterns {
+			if strings.Contains(line, fatal.pattern) {
+				sb.inputFailed(errors.New(getErrMsg(fatal.code)), fatal.code)
+				break
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		ShowError(err, 4018)
+	}
+}
+
+/*
+stopProcess stops the process group with SIGTERM and kills it if it doesn't exit within processStopTimeout.
+exited has to be closed when the process has exited.
+*/
+func stopProcess(process *os.Process, exited chan struct{}) {
+	terminateProcess(process)
+	awaitProcess(process, exited)
+}
+
+// terminateProcess sends SIGTERM to the process group without waiting for it
+func terminateProcess(process *os.Process) {
+	if err := terminateProcessGroup(process); err != nil {
+		ShowDebug(fmt.Sprintf("Streaming:Could not terminate process %d: %s", process.Pid, err.Error()), 3)
+	}
+}
+
+// awaitProcess waits for the terminated process group and kills it if it doesn't exit within processStopTimeout
+func awaitProcess(process *os.Process, exited chan struct{}) {
+	select {
+	case <-exited:
+	case <-time.After(processStopTimeout):
+		ShowInfo(fmt.Sprintf("Streaming:Process %d did not stop within %s, killing it", process.Pid, processStopTimeout))
+	}
+
+	// Remove the remaining processes of the group too
+	killProcessGroup(process)
+
+	select {
+	case <-exited:
+	case <-time.After(processStopTimeout):
+		ShowDebug(fmt.Sprintf("Streaming:Process %d could not be killed", process.Pid), 1)
+	}
+}
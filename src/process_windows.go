@@ -0,0 +1,21 @@
+package src
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group, so the tool and its children can be stopped together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateProcessGroup kills the process, Windows has no SIGTERM for processes without a console
+func terminateProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
+
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
@@ -17,6 +17,7 @@ func getProviderData(fileType, fileID string) (err error) {
 	var fileExtension, serverFileName string
 	var body = make([]byte, 0)
 	var newProvider = false
+	var downloadStart time.Time
 	var dataMap = make(map[string]interface{})
 
 	var saveDateFromProvider = func(fileSource, serverFileName, id string, body []byte) (err error) {
@@ -31,7 +32,7 @@ func getProviderData(fileType, fileID string) (err error) {
 		}
 
 		// Default keys für die Providerdaten
-		var keys = []string{"name", "description", "type", "file." + System.AppName, "file.source", "tuner", "http_proxy.ip", "http_proxy.port", "last.update", "compatibility", "counter.error", "counter.download", "provider.availability"}
+		var keys = []string{"name", "description", "type", "file." + System.AppName, "file.source", "tuner", "http_proxy.ip", "http_proxy.port", providerProxyType, providerUserAgent, providerHeaders, providerCookies, providerTimeout, providerBuffer, providerBufferOptions, providerBufferSize, "last.update", "compatibility", "counter.error", "counter.download", "provider.availability"}
 
 		for _, key := range keys {
 
@@ -70,6 +71,24 @@ func getProviderData(fileType, fileID string) (err error) {
 				case "http_proxy.port":
 					data[key] = ""
 
+				case providerProxyType:
+					data[key] = "http"
+
+				case providerUserAgent, providerCookies:
+					data[key] = ""
+
+				case providerHeaders:
+					data[key] = make(map[string]interface{})
+
+				case providerTimeout:
+					data[key] = providerDefaultTimeout.Seconds()
+
+				case providerBuffer, providerBufferOptions:
+					data[key] = ""
+
+				case providerBufferSize:
+					data[key] = 0
+
 				case "compatibility":
 					data[key] = make(map[string]interface{})
 
@@ -179,6 +198,8 @@ func getProviderData(fileType, fileID string) (err error) {
 			}
 		}
 
+		downloadStart = time.Now()
+
 		switch fileType {
 
 		case "hdhr":
@@ -220,6 +241,8 @@ func getProviderData(fileType, fileID string) (err error) {
 
 		}
 
+		metrics.providerDownload(fileType, getProviderParameter(dataID, fileType, "name"), time.Since(downloadStart), err)
+
 		if err != nil {
 
 			ShowError(err, 000)
@@ -0,0 +1,252 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys of the HTTP settings in the provider data (Settings.Files.M3U / HDHR)
+const (
+	providerProxyType     = "http_proxy.type" // http, https or socks5
+	providerProxyUser     = "http_proxy.user"
+	providerProxyPassword = "http_proxy.password"
+	providerUserAgent     = "http.userAgent"
+	providerHeaders       = "http.headers" // Object with additional request headers
+	providerCookies       = "http.cookies" // name=value; name2=value2
+	providerTimeout       = "http.timeout" // Seconds for connecting and waiting for the response headers
+)
+
+const providerDefaultTimeout = 10 * time.Second
+
+type providerClient struct {
+	config string // Proxy and timeout the client was created with
+	client *http.Client
+}
+
+/*
+providerClients holds one HTTP client per playlist. The clients keep their connections and the cookies
+set by the provider, they are created again when the proxy or the timeout of the provider changes.
+*/
+var providerClients = struct {
+	sync.Mutex
+	clients map[string]*providerClient
+}{clients: make(map[string]*providerClient)}
+
+// getProviderSettings returns the settings of the M3U or HDHR playlist
+func getProviderSettings(playlistID string) map[string]interface{} {
+	var dataMap map[string]interface{}
+	if playlistID == "" {
+		return map[string]interface{}{}
+	}
+	switch GetPlaylistType(playlistID) {
+	case "m3u":
+		dataMap = Settings.Files.M3U
+	case "hdhr":
+		dataMap = Settings.Files.HDHR
+	}
+	if data, ok := dataMap[playlistID].(map[string]interface{}); ok {
+		return data
+	}
+	return map[string]interface{}{}
+}
+
+// getProviderProxy returns the proxy URL of the playlist, or nil if no proxy is set
+func getProviderProxy(data map[string]interface{}) (*url.URL, error) {
+	ip, _ := data["http_proxy.ip"].(string)
+	port, _ := data["http_proxy.port"].(string)
+	if ip == "" || port == "" {
+		return nil, nil
+	}
+
+	var scheme, _ = data[providerProxyType].(string)
+	switch scheme {
+	case "":
+		scheme = "http"
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy type %s", scheme)
+	}
+
+	var proxyURL = &url.URL{Scheme: scheme, Host: net.JoinHostPort(ip, port)}
+	if user, _ := data[providerProxyUser].(string); user != "" {
+		password, _ := data[providerProxyPassword].(string)
+		proxyURL.User = url.UserPassword(user, password)
+	}
+	return proxyURL, nil
+}
+
+func getProviderTimeout(data map[string]interface{}) time.Duration {
+	switch timeout := data[providerTimeout].(type) {
+	case float64:
+		if timeout > 0 {
+			return time.Duration(timeout * float64(time.Second))
+		}
+	case string:
+		if seconds, err := strconv.ParseFloat(timeout, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return providerDefaultTimeout
+}
+
+/*
+getProviderHTTPClient returns the HTTP client of the playlist with its proxy (HTTP or SOCKS5) and timeouts.
+The timeout applies to connecting and to the response headers only, so the streams can be read as long as they run.
+*/
+func getProviderHTTPClient(playlistID string) (*http.Client, error) {
+	var data = getProviderSettings(playlistID)
+
+	proxyURL, err := getProviderProxy(data)
+	if err != nil {
+		return nil, err
+	}
+	var timeout = getProviderTimeout(data)
+	var config = fmt.Sprintf("%v|%s", proxyURL, timeout)
+
+	providerClients.Lock()
+	defer providerClients.Unlock()
+
+	if c, ok := providerClients.clients[playlistID]; ok && c.config == config {
+		return c.client, nil
+	}
+
+	var transport = http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != nil {
+		ShowInfo(fmt.Sprintf("Streaming:Using %s proxy %s for %s", proxyURL.Scheme, proxyURL.Host, getProviderParameter(playlistID, GetPlaylistType(playlistID), "name")))
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var client = &http.Client{Transport: transport, Jar: jar}
+	providerClients.clients[playlistID] = &providerClient{config: config, client: client}
+	return client, nil
+}
+
+/*
+splitURLHeaders separates the headers appended to a stream URL (url|Header=Value&Header2=Value2)
+*/
+func splitURLHeaders(rawURL string) (string, map[string]string) {
+	var headers = make(map[string]string)
+	rawURL, suffix, found := strings.Cut(rawURL, "|")
+	if !found {
+		return rawURL, headers
+	}
+	for _, header := range strings.Split(suffix, "&") {
+		if key, value, ok := strings.Cut(header, "="); ok {
+			headers[key] = value
+		}
+	}
+	return rawURL, headers
+}
+
+/*
+newProviderRequest creates a GET or HEAD request for the stream. The user agent, headers and cookies
+of the provider are set first, the headers of the stream URL take precedence.
+*/
+func newProviderRequest(ctx context.Context, method string, streamInfo *StreamInfo, rawURL string) (*http.Request, error) {
+	rawURL, urlHeaders := splitURLHeaders(rawURL)
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data = getProviderSettings(streamInfo.PlaylistID)
+	req.Header.Set("User-Agent", getProviderUserAgent(streamInfo.PlaylistID))
+
+	if headers, ok := data[providerHeaders].(map[string]interface{}); ok {
+		for key, value := range headers {
+			if v, ok := value.(string); ok {
+				req.Header.Set(key, v)
+			}
+		}
+	}
+
+	if cookies, _ := data[providerCookies].(string); cookies != "" {
+		req.Header.Set("Cookie", cookies)
+	}
+
+	for key, value := range streamInfo.HTTP_HEADER {
+		req.Header.Set(key, value)
+	}
+	for key, value := range urlHeaders {
+		req.Header.Set(key, value)
+	}
+	return req, nil
+}
+
+// getProviderUserAgent returns the user agent of the provider, the global setting is the default
+func getProviderUserAgent(playlistID string) string {
+	if userAgent, _ := getProviderSettings(playlistID)[providerUserAgent].(string); userAgent != "" {
+		return userAgent
+	}
+	return Settings.UserAgent
+}
+
+/*
+upstreamKey identifies the upstream connection of a stream by the effective URL, request headers and proxy.
+Streams with the same key receive the same data and can share one connection.
+*/
+func upstreamKey(playlistID, rawURL string, headers map[string]string) string {
+	req, err := newProviderRequest(context.Background(), http.MethodGet, &StreamInfo{PlaylistID: playlistID, HTTP_HEADER: headers}, rawURL)
+	if err != nil {
+		return getMD5(playlistID + "-" + rawURL)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(req.URL.String() + "\n")
+	if proxyURL, err := getProviderProxy(getProviderSettings(playlistID)); err == nil && proxyURL != nil {
+		builder.WriteString("Proxy: " + proxyURL.String() + "\n")
+	}
+
+	var keys = make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		builder.WriteString(key + ": " + strings.Join(req.Header.Values(key), ", ") + "\n")
+	}
+	return getMD5(builder.String())
+}
+
+/*
+doProviderRequest sends the request with the HTTP client of the playlist and treats every non 2xx status code as error
+*/
+func doProviderRequest(ctx context.Context, method string, streamInfo *StreamInfo, rawURL string) (*http.Response, error) {
+	client, err := getProviderHTTPClient(streamInfo.PlaylistID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newProviderRequest(ctx, method, streamInfo, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned status %s", req.URL.Redacted(), resp.Status)
+	}
+	return resp, nil
+}
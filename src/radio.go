@@ -0,0 +1,217 @@
+package src
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"threadfin/src/internal/mpegts"
+)
+
+const RadioTunerLimitError = 4070 //errMsg = "Tuner limit reached, no stream available for the radio channel"
+
+// radioRemuxProbeSize is the MPEG-TS data a radio client waits for the PMT, without it the client gets the MPEG-TS
+const radioRemuxProbeSize = 1024 * 1024
+
+// Content types of audio-only upstreams without a MPEG-TS container, the aliases are served with the standard type
+var radioContentTypes = map[string]string{
+	"audio/aac":    "audio/aac",
+	"audio/aacp":   "audio/aac",
+	"audio/x-aac":  "audio/aac",
+	"audio/mpeg":   "audio/mpeg",
+	"audio/mp3":    "audio/mpeg",
+	"audio/x-mpeg": "audio/mpeg",
+}
+
+// audioContentType returns the content type of a response with ADTS or MP3 audio, empty for every other response
+func audioContentType(resp *http.Response) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return radioContentTypes[mediaType]
+}
+
+/*
+detectedAudio keeps the content types the streams detected, by XEPG channel. An empty type means the upstream
+is no longer audio-only. The streams don't write the XEPG database, the health scan applies the types (x-audio)
+the next time it saves the database. Until then they take precedence over the database.
+*/
+var detectedAudio = struct {
+	sync.Mutex
+	channels map[string]string
+}{channels: make(map[string]string)}
+
+// channelAudio returns the content type of the audio-only upstream of the XEPG channel, empty for MPEG-TS
+func channelAudio(channel XEPGChannelStruct) string {
+	detectedAudio.Lock()
+	defer detectedAudio.Unlock()
+	if contentType, found := detectedAudio.channels[channel.XEPG]; found {
+		return contentType
+	}
+	return channel.XAudio
+}
+
+// takeDetectedAudio returns the detected content types that are not in the XEPG database yet
+func takeDetectedAudio() map[string]string {
+	detectedAudio.Lock()
+	defer detectedAudio.Unlock()
+	var channels = detectedAudio.channels
+	detectedAudio.channels = make(map[string]string)
+	return channels
+}
+
+/*
+isRadioChannel reports whether the XEPG channel is a radio channel. It is marked in the playlist (radio="true")
+or the upstream has been detected as audio-only (x-audio).
+*/
+func isRadioChannel(channel XEPGChannelStruct) bool {
+	return channel.Radio == "true" || channelAudio(channel) != ""
+}
+
+// markRadioStream returns a copy of the stream info for a radio channel, the cached stream info is not changed
+func markRadioStream(streamInfo *StreamInfo) *StreamInfo {
+	if channel, found := getStreamChannel(streamInfo); found && isRadioChannel(channel) {
+		var info = *streamInfo
+		info.Radio = true
+		return &info
+	}
+	return streamInfo
+}
+
+/*
+setChannelAudio remembers the content type of an audio-only upstream (x-audio) for the XEPG channel of the stream.
+The M3U file is created again, the channel is marked as radio.
+*/
+func setChannelAudio(streamInfo *StreamInfo, contentType string) {
+	channel, found := getStreamChannel(streamInfo)
+	if !found || channelAudio(channel) == contentType {
+		return
+	}
+
+	detectedAudio.Lock()
+	detectedAudio.channels[channel.XEPG] = contentType
+	detectedAudio.Unlock()
+
+	if contentType == "" {
+		ShowInfo(fmt.Sprintf("Streaming:%s is no longer audio-only", channel.XName))
+	} else {
+		ShowInfo(fmt.Sprintf("Streaming:Detected radio channel %s (%s)", channel.XName, contentType))
+	}
+
+	go func() {
+		for System.ScanInProgress != 0 {
+			time.Sleep(time.Second)
+		}
+
+		System.ScanInProgress = 1
+		defer func() { System.ScanInProgress = 0 }()
+
+		createM3UFile()
+	}()
+}
+
+// setAudio remembers the content type of an audio-only upstream, empty for MPEG-TS
+func (s *Stream) setAudio(contentType string) {
+	s.mu.Lock()
+	s.audio = contentType
+	s.mu.Unlock()
+	setChannelAudio(s.StreamInfo, contentType)
+}
+
+// audioType returns the content type of an audio-only upstream, empty for MPEG-TS
+func (s *Stream) audioType() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.audio
+}
+
+/*
+clientWriter writes the data of the client queue to the response. The headers are written with the first data,
+at this time the content type of the upstream is known. Audio-only upstreams are passed through with their content type,
+radio channels in MPEG-TS are remuxed to ADTS or MP3 if radio.remux is enabled.
+*/
+type clientWriter struct {
+	stream  *Stream
+	client  *Client
+	started bool
+
+	remux     bool
+	decided   bool // The PMT showed which audio is extracted
+	extractor mpegts.AudioExtractor
+	pending   []byte // MPEG-TS data until the decision, the client gets it if there is no audio to extract
+	carry     []byte // Start of the packet that is completed by the next data
+}
+
+func (cw *clientWriter) write(data []byte) error {
+	if !cw.started {
+		cw.started = true
+		if contentType := cw.stream.audioType(); contentType != "" {
+			cw.client.w.Header().Set("Content-Type", contentType)
+		} else if cw.stream.Radio && Settings.RadioRemux {
+			cw.remux = true
+		}
+	}
+
+	if cw.remux {
+		if data = cw.remuxAudio(data); len(data) == 0 {
+			return nil
+		}
+	}
+
+	if _, err := cw.client.w.Write(data); err != nil {
+		return err
+	}
+	if flusher, ok := cw.client.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// remuxAudio returns the audio frames of the MPEG-TS data, or the MPEG-TS data if the program has no audio to extract
+func (cw *clientWriter) remuxAudio(data []byte) []byte {
+	if len(cw.carry) > 0 {
+		data = append(cw.carry, data...)
+		cw.carry = nil
+	}
+
+	var audio []byte
+	for len(data) >= mpegts.PacketSize {
+		if data[0] != mpegts.SyncByte {
+			data = data[1:]
+			continue
+		}
+		packet := data[:mpegts.PacketSize]
+		audio = append(audio, cw.extractor.Packet(packet)...)
+		if !cw.decided {
+			cw.pending = append(cw.pending, packet...)
+		}
+		data = data[mpegts.PacketSize:]
+	}
+	if len(data) > 0 {
+		cw.carry = append([]byte{}, data...)
+	}
+
+	if cw.decided {
+		return audio
+	}
+
+	contentType, known := cw.extractor.ContentType()
+	switch {
+	case known && contentType != "":
+		cw.decided, cw.pending = true, nil
+		cw.client.w.Header().Set("Content-Type", contentType)
+		ShowDebug(fmt.Sprintf("Streaming:Remuxing %s as %s", cw.stream.Name, contentType), 2)
+		return audio
+
+	case known || len(cw.pending) >= radioRemuxProbeSize:
+		// No audio that can be served without a container, the client gets the MPEG-TS
+		ShowDebug(fmt.Sprintf("Streaming:%s has no ADTS or MP3 audio, sending MPEG-TS", cw.stream.Name), 2)
+		data = append(cw.pending, cw.carry...)
+		cw.remux, cw.pending, cw.carry = false, nil, nil
+		return data
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Status of a recording
+const (
+	RecordingScheduled = "scheduled"
+	RecordingActive    = "recording"
+	RecordingCompleted = "completed"
+	RecordingFailed    = "failed"
+	RecordingCanceled  = "canceled"
+)
+
+const (
+	recordingCheckInterval = 10 * time.Second
+	recordingRuleInterval  = 5 * time.Minute
+	recordingRetryDelay    = 5 * time.Second
+)
+
+// Recording is a one-off recording of a XEPG channel, either from a programme or a manual time window
+type Recording struct {
+	ID          string    `json:"id"`
+	ChannelID   string    `json:"channelID"`
+	ChannelName string    `json:"channelName"`
+	Title       string    `json:"title"`
+	Start       time.Time `json:"start"`
+	Stop        time.Time `json:"stop"`
+	RuleID      string    `json:"ruleID,omitempty"`
+	Status      string    `json:"status"`
+	File        string    `json:"file,omitempty"`
+	Size        int64     `json:"size"`
+	Error       string    `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// RecordingRule schedules every programme with a matching title, optionally only on one channel
+type RecordingRule struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	ChannelID string `json:"channelID,omitempty"`
+}
+
+// Recorder schedules the recordings and keeps them in recordings.json
+type Recorder struct {
+	Recordings map[string]*Recording     `json:"recordings"`
+	Rules      map[string]*RecordingRule `json:"rules"`
+	mu         sync.Mutex
+}
+
+var recorder = &Recorder{
+	Recordings: make(map[string]*Recording),
+	Rules:      make(map[string]*RecordingRule),
+}
+
+/*
+loadRecordings reads the recordings from the config folder.
+Recordings that were running while Threadfin was stopped can not be continued.
+*/
+func loadRecordings() (err error) {
+	content, err := readByteFromFile(System.File.Recordings)
+	if err != nil {
+		return
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	err = json.Unmarshal(content, recorder)
+	if err != nil {
+		return
+	}
+
+	if recorder.Recordings == nil {
+		recorder.Recordings = make(map[string]*Recording)
+	}
+	if recorder.Rules == nil {
+		recorder.Rules = make(map[string]*RecordingRule)
+	}
+
+	for _, rec := range recorder.Recordings {
+		if rec.Status == RecordingActive {
+			rec.Status = RecordingFailed
+			rec.Error = "Threadfin was stopped during the recording"
+		}
+	}
+	return
+}
+
+// save writes the recordings to the config folder, the caller must hold the lock
+func (rc *Recorder) save() {
+	if err := saveMapToJSONFile(System.File.Recordings, rc); err != nil {
+		ShowError(err, 0)
+	}
+}
+
+/*
+schedule starts the recordings when they are due and applies the rules to the programme data
+*/
+func (rc *Recorder) schedule() {
+	ticker := time.NewTicker(recordingCheckInterval)
+	defer ticker.Stop()
+
+	var lastRuleCheck time.Time
+	for range ticker.C {
+		if time.Since(lastRuleCheck) > recordingRuleInterval && System.ScanInProgress == 0 {
+			rc.applyRules()
+			lastRuleCheck = time.Now()
+		}
+		rc.startDueRecordings()
+	}
+}
+
+func (rc *Recorder) startDueRecordings() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var padding = time.Duration(Settings.RecordingPadding) * time.Minute
+	var now = time.Now()
+	var changed bool
+	for _, rec := range rc.Recordings {
+		if rec.Status != RecordingScheduled || now.Before(rec.Start.Add(-padding)) {
+			continue
+		}
+		changed = true
+		if now.After(rec.Stop.Add(padding)) {
+			rec.Status = RecordingFailed
+			rec.Error = "The recording was missed"
+			continue
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), rec.Stop.Add(padding))
+		rec.cancel = cancel
+		rec.Status = RecordingActive
+		go rc.record(ctx, rec)
+	}
+
+	if changed {
+		rc.save()
+	}
+}
+
+/*
+record joins the stream of the channel like a regular client and writes it into the recording file.
+The stream is joined again if it ends before the end of the recording.
+*/
+func (rc *Recorder) record(ctx context.Context, rec *Recording) {
+	defer rec.cancel()
+
+	ShowInfo(fmt.Sprintf("Recording:Start %s (%s)", rec.Title, rec.ChannelName))
+
+	streamInfo, err := getRecordingStreamInfo(rec.ChannelID)
+	if err != nil {
+		rc.finish(rec, err)
+		return
+	}
+
+	if err = checkFolder(Settings.RecordingPath); err != nil {
+		rc.finish(rec, err)
+		return
+	}
+
+	f, err := createRecordingFile(rec)
+	if err != nil {
+		ShowError(err, 4204)
+		rc.finish(rec, err)
+		return
+	}
+	defer f.Close()
+
+	rc.mu.Lock()
+	rec.File = f.Name()
+	rc.mu.Unlock()
+
+	var writer = &recordingWriter{file: f, header: make(http.Header)}
+	for ctx.Err() == nil {
+		// StartStream changes the URL ID if the tuner limit has been reached, don't touch the cached stream info
+		var info = *streamInfo
+
+		if err := streamManager.CanStartStream(&info, Settings.TunerRecordingPriority); err == nil {
+			rc.setError(rec, "")
+			req, err := http.NewRequestWithContext(withClientPriority(ctx, Settings.TunerRecordingPriority), http.MethodGet, "/stream/"+info.URLid, nil)
+			if err != nil {
+				rc.finish(rec, err)
+				return
+			}
+			req.RemoteAddr = "recording"
+			streamManager.ServeStream(&info, writer, req)
+		} else {
+			rc.setError(rec, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(recordingRetryDelay):
+		}
+	}
+
+	rc.mu.Lock()
+	rec.Size = writer.written
+	rc.mu.Unlock()
+
+	if writer.written == 0 {
+		rc.finish(rec, errors.New("no data has been received"))
+		return
+	}
+	rc.finish(rec, nil)
+}
+
+// finish sets the final status of the recording
+func (rc *Recorder) finish(rec *Recording, err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	switch {
+	case rec.Status == RecordingCanceled:
+	case err != nil:
+		rec.Status = RecordingFailed
+		rec.Error = err.Error()
+	default:
+		rec.Status = RecordingCompleted
+		rec.Error = ""
+	}
+
+	// The recording has been deleted while it was running
+	if _, exists := rc.Recordings[rec.ID]; !exists && rec.File != "" {
+		os.Remove(rec.File)
+	}
+
+	ShowInfo(fmt.Sprintf("Recording:%s %s (%s)", rec.Status, rec.Title, rec.ChannelName))
+	rc.save()
+}
+
+func (rc *Recorder) setError(rec *Recording, msg string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rec.Error = msg
+}
+
+/*
+AddRecording schedules a new recording. Without a stop time the programme of the channel
+that starts at the given time is used.
+*/
+func (rc *Recorder) AddRecording(request Recording) (*Recording, error) {
+	channel, err := getRecordingChannel(request.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec = &Recording{
+		ID:          uuid.New().String(),
+		ChannelID:   channel.XChannelID,
+		ChannelName: channel.XName,
+		Title:       request.Title,
+		Start:       request.Start,
+		Stop:        request.Stop,
+		Status:      RecordingScheduled,
+	}
+
+	if rec.Stop.IsZero() {
+		program, err := findRecordingProgram(channel, rec.Start)
+		if err != nil {
+			return nil, err
+		}
+		rec.Start, rec.Stop, rec.Title = program.start, program.stop, program.title
+	}
+
+	if rec.Title == "" {
+		rec.Title = channel.XName
+	}
+
+	if !rec.Stop.After(rec.Start) || rec.Stop.Before(time.Now()) {
+		return nil, errors.New(getErrMsg(4205))
+	}
+
+	rc.mu.Lock()
+	rc.Recordings[rec.ID] = rec
+	rc.save()
+	rc.mu.Unlock()
+
+	ShowInfo(fmt.Sprintf("Recording:Scheduled %s (%s) %s", rec.Title, rec.ChannelName, rec.Start.Format(time.RFC3339)))
+	return rec, nil
+}
+
+// CancelRecording stops a running recording or removes it from the schedule, the file is kept
+func (rc *Recorder) CancelRecording(id string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rec, exists := rc.Recordings[id]
+	if !exists {
+		return errors.New(getErrMsg(4200))
+	}
+
+	switch rec.Status {
+	case RecordingScheduled:
+		rec.Status = RecordingCanceled
+	case RecordingActive:
+		rec.Status = RecordingCanceled
+		rec.cancel()
+	}
+	rc.save()
+	return nil
+}
+
+// DeleteRecording cancels the recording and removes it together with its file
+func (rc *Recorder) DeleteRecording(id string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rec, exists := rc.Recordings[id]
+	if !exists {
+		return errors.New(getErrMsg(4200))
+	}
+
+	delete(rc.Recordings, id)
+	if rec.Status == RecordingActive {
+		// The file is removed when the recording has been finished
+		rec.Status = RecordingCanceled
+		rec.cancel()
+	} else if rec.File != "" {
+		if err := os.Remove(rec.File); err != nil && !os.IsNotExist(err) {
+			ShowError(err, 0)
+		}
+	}
+	rc.save()
+	return nil
+}
+
+// GetRecordings returns all recordings and rules, sorted by their start time and title
+func (rc *Recorder) GetRecordings() (recordings []*Recording, rules []*RecordingRule) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for _, rec := range rc.Recordings {
+		var copy = *rec
+		recordings = append(recordings, &copy)
+	}
+	for _, rule := range rc.Rules {
+		var copy = *rule
+		rules = append(rules, &copy)
+	}
+
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].Start.Before(recordings[j].Start) })
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Title < rules[j].Title })
+	return
+}
+
+// AddRecordingRule adds a series rule and schedules the matching programmes
+func (rc *Recorder) AddRecordingRule(request RecordingRule) (*RecordingRule, error) {
+	if strings.TrimSpace(request.Title) == "" {
+		return nil, errors.New("the title of the recording rule is missing")
+	}
+	if request.ChannelID != "" {
+		if _, err := getRecordingChannel(request.ChannelID); err != nil {
+			return nil, err
+		}
+	}
+
+	var rule = &RecordingRule{
+		ID:        uuid.New().String(),
+		Title:     strings.TrimSpace(request.Title),
+		ChannelID: request.ChannelID,
+	}
+
+	rc.mu.Lock()
+	rc.Rules[rule.ID] = rule
+	rc.save()
+	rc.mu.Unlock()
+
+	rc.applyRules()
+	return rule, nil
+}
+
+// DeleteRecordingRule removes the rule and the recordings it has scheduled but not started yet
+func (rc *Recorder) DeleteRecordingRule(id string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if _, exists := rc.Rules[id]; !exists {
+		return errors.New(getErrMsg(4206))
+	}
+	delete(rc.Rules, id)
+
+	for recID, rec := range rc.Recordings {
+		if rec.RuleID == id && rec.Status == RecordingScheduled {
+			delete(rc.Recordings, recID)
+		}
+	}
+	rc.save()
+	return nil
+}
+
+/*
+applyRules schedules all upcoming programmes of the XMLTV data whose title contains the title of a rule
+*/
+func (rc *Recorder) applyRules() {
+	rc.mu.Lock()
+	var rules []RecordingRule
+	for _, rule := range rc.Rules {
+		rules = append(rules, *rule)
+	}
+	rc.mu.Unlock()
+
+	if len(rules) == 0 {
+		return
+	}
+
+	var now = time.Now()
+	var scheduled []*Recording
+	var guide = make(recordingGuide)
+	for _, channel := range getRecordingChannels() {
+		for _, program := range guide.programs(channel) {
+			if program.stop.Before(now) {
+				continue
+			}
+			for _, rule := range rules {
+				if rule.ChannelID != "" && rule.ChannelID != channel.XChannelID {
+					continue
+				}
+				if !strings.Contains(strings.ToLower(program.title), strings.ToLower(rule.Title)) {
+					continue
+				}
+				scheduled = append(scheduled, &Recording{
+					ChannelID:   channel.XChannelID,
+					ChannelName: channel.XName,
+					Title:       program.title,
+					Start:       program.start,
+					Stop:        program.stop,
+					RuleID:      rule.ID,
+					Status:      RecordingScheduled,
+				})
+				break
+			}
+		}
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var added int
+	for _, rec := range scheduled {
+		if rc.isScheduled(rec) {
+			continue
+		}
+		rec.ID = uuid.New().String()
+		rc.Recordings[rec.ID] = rec
+		added++
+		ShowInfo(fmt.Sprintf("Recording:Scheduled %s (%s) %s", rec.Title, rec.ChannelName, rec.Start.Format(time.RFC3339)))
+	}
+
+	if added > 0 {
+		rc.save()
+	}
+}
+
+// isScheduled reports whether the programme already has a recording, the caller must hold the lock
+func (rc *Recorder) isScheduled(rec *Recording) bool {
+	for _, existing := range rc.Recordings {
+		if existing.ChannelID == rec.ChannelID && existing.Start.Equal(rec.Start) {
+			return true
+		}
+	}
+	return false
+}
+
+type recordingProgram struct {
+	title string
+	start time.Time
+	stop  time.Time
+}
+
+/*
+recordingGuide reads the provider XMLTV files for the recordings, each file once. The XMLTV cache of the XEPG
+build is not used, the recorder runs at the same time as the build.
+*/
+type recordingGuide map[string]*XMLTV
+
+// programs returns the programmes of the provider XMLTV file mapped to the channel
+func (guide recordingGuide) programs(channel XEPGChannelStruct) (programs []recordingProgram) {
+	if channel.XmltvFile == "" || channel.XmltvFile == "-" || channel.XmltvFile == "Threadfin Dummy" {
+		return
+	}
+
+	xmltv, read := guide[channel.XmltvFile]
+	if !read {
+		xmltv = &XMLTV{}
+		content, err := readByteFromFile(System.Folder.Data + channel.XmltvFile)
+		if err != nil {
+			ShowError(err, 1004)
+			xmltv = nil
+		} else if err = xml.Unmarshal(content, xmltv); err != nil {
+			ShowError(err, 0)
+			xmltv = nil
+		}
+		guide[channel.XmltvFile] = xmltv
+	}
+	if xmltv == nil {
+		return
+	}
+
+	for _, program := range xmltv.Program {
+		if program.Channel != channel.XMapping || len(program.Title) == 0 {
+			continue
+		}
+		start, err := parseXMLTVTime(program.Start)
+		if err != nil {
+			continue
+		}
+		stop, err := parseXMLTVTime(program.Stop)
+		if err != nil {
+			continue
+		}
+		programs = append(programs, recordingProgram{title: program.Title[0].Value, start: start, stop: stop})
+	}
+	return
+}
+
+func findRecordingProgram(channel XEPGChannelStruct, start time.Time) (recordingProgram, error) {
+	for _, program := range make(recordingGuide).programs(channel) {
+		if program.start.Equal(start) {
+			return program, nil
+		}
+	}
+	return recordingProgram{}, errors.New(getErrMsg(4202))
+}
+
+// parseXMLTVTime parses the start and stop times of the XMLTV programmes, without time zone the local time is used
+func parseXMLTVTime(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102150405 -0700", strings.TrimSpace(value)); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("20060102150405", strings.TrimSpace(value), time.Local)
+}
+
+// getRecordingChannels returns the active XEPG channels
+func getRecordingChannels() (channels []XEPGChannelStruct) {
+	for _, dxc := range Data.XEPG.Channels {
+		var channel XEPGChannelStruct
+		if err := json.Unmarshal([]byte(mapToJSON(dxc)), &channel); err != nil {
+			continue
+		}
+		if channel.XActive {
+			channels = append(channels, channel)
+		}
+	}
+	return
+}
+
+func getRecordingChannel(channelID string) (XEPGChannelStruct, error) {
+	for _, channel := range getRecordingChannels() {
+		if channel.XChannelID == channelID {
+			return channel, nil
+		}
+	}
+	return XEPGChannelStruct{}, errors.New(getErrMsg(4201))
+}
+
+// getRecordingStreamInfo returns the stream info of the channel, the same as for the /stream/ URL of the M3U file
+func getRecordingStreamInfo(channelID string) (*StreamInfo, error) {
+	channel, err := getRecordingChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	streamURL, err := createChannelStreamingURL(channel)
+	if err != nil {
+		return nil, err
+	}
+	streamInfo, err := getStreamInfo(path.Base(streamURL))
+	if err != nil {
+		return nil, err
+	}
+	// The copy of the request gets the URLs the provider is reached with, like the stream of a client
+	streamInfo = streamInfo.withChannel()
+	rewriteUpstreamURLs(streamInfo)
+	streamInfo = applyTranscodingProfile(streamInfo, selectTranscodingProfile(streamInfo, nil))
+	if getBufferConfig(streamInfo).Type == "-" {
+		return nil, errors.New(getErrMsg(4207))
+	}
+	return streamInfo, nil
+}
+
+var recordingFileNameReplacer = regexp.MustCompile(`[^\pL\pN _.-]+`)
+
+// getRecordingFileName returns the file name of the recording, the same programme may be recorded on several channels
+func getRecordingFileName(rec *Recording) string {
+	var name = strings.TrimSpace(recordingFileNameReplacer.ReplaceAllString(rec.Title, "_"))
+	var channel = strings.TrimSpace(recordingFileNameReplacer.ReplaceAllString(rec.ChannelName, "_"))
+	return fmt.Sprintf("%s_%s_%s", name, channel, rec.Start.Local().Format("20060102-1504"))
+}
+
+// createRecordingFile creates a new recording file, an existing file is never overwritten
+func createRecordingFile(rec *Recording) (f *os.File, err error) {
+	var name = getPlatformFile(Settings.RecordingPath + getRecordingFileName(rec))
+	for i := 1; i <= 100; i++ {
+		var file = name + ".ts"
+		if i > 1 {
+			file = fmt.Sprintf("%s_%d.ts", name, i)
+		}
+		f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return
+		}
+	}
+	return
+}
+
+// recordingWriter takes the place of the http.ResponseWriter for the recording client
+type recordingWriter struct {
+	file    *os.File
+	header  http.Header
+	written int64
+}
+
+func (w *recordingWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *recordingWriter) WriteHeader(statusCode int) {}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,228 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"threadfin/src/internal/mpegts"
+	"threadfin/src/internal/rtp"
+	"threadfin/src/internal/rtsp"
+)
+
+const (
+	UDPJoinError    = 4060
+	RTSPError       = 4061
+	RTPPayloadError = 4062
+)
+
+const (
+	udpReadBuffer = 4 * 1024 * 1024 // Socket buffer, multicast bursts must not get lost while the buffer writes to disk
+	rtpStartPTS   = 90000           // First PTS of the muxed H.264 stream, 1 second leaves room for the PCR
+)
+
+// isRTPURL reports whether the URL is ingested by ingestUDP or ingestRTSP instead of HTTP
+func isRTPURL(rawURL string) bool {
+	for _, scheme := range []string{"udp://", "rtp://", "rtsp://"} {
+		if strings.HasPrefix(strings.ToLower(rawURL), scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// ingestRTP starts the ingest of the UDP, RTP or RTSP URL and writes the MPEG-TS stream into HandleByteOutput
+func (sb *ThreadfinBuffer) ingestRTP(ctx context.Context, rawURL string) {
+	pipeReader, pipeWriter := io.Pipe()
+	go sb.HandleByteOutput(pipeReader)
+
+	var err error
+	var errCode int
+	if strings.HasPrefix(strings.ToLower(rawURL), "rtsp://") {
+		err, errCode = sb.ingestRTSP(ctx, rawURL, pipeWriter)
+	} else {
+		err, errCode = sb.ingestUDP(ctx, rawURL, pipeWriter)
+	}
+
+	if err != nil && ctx.Err() == nil {
+		ShowError(err, errCode)
+		sb.inputFailed(err, errCode)
+	}
+	pipeWriter.CloseWithError(err)
+}
+
+/*
+ingestUDP receives the datagrams of udp://@group:port or rtp://@group:port. Multicast groups are joined
+on the interface of the udp.interface setting. The datagrams can contain plain MPEG-TS or RTP with MPEG-TS.
+*/
+func (sb *ThreadfinBuffer) ingestUDP(ctx context.Context, rawURL string, w io.Writer) (error, int) {
+	conn, err := listenUDP(rawURL)
+	if err != nil {
+		return err, UDPJoinError
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	ShowInfo(fmt.Sprintf("Streaming:Listening on %s", conn.LocalAddr()))
+
+	var ingest rtpIngest
+	var buffer = make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err, ReadIntoBufferError
+		}
+
+		data := buffer[:n]
+		if rtp.IsRTP(data) {
+			packet, err := rtp.Parse(data)
+			if err != nil {
+				continue
+			}
+			if packet.PayloadType != rtp.PayloadTypeMP2T {
+				return fmt.Errorf("RTP payload type %d", packet.PayloadType), RTPPayloadError
+			}
+			data = packet.Payload
+		}
+
+		if err := ingest.writeTS(w, data); err != nil {
+			return err, ReadIntoBufferError
+		}
+	}
+}
+
+// listenUDP joins the multicast group of the URL or listens on the unicast address
+func listenUDP(rawURL string) (*net.UDPConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The source of udp://source@group:port is not supported, the group is joined for all sources
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn *net.UDPConn
+	if addr.IP != nil && addr.IP.IsMulticast() {
+		var iface *net.Interface
+		if Settings.UDPInterface != "" {
+			if iface, err = net.InterfaceByName(Settings.UDPInterface); err != nil {
+				return nil, err
+			}
+		}
+		conn, err = net.ListenMulticastUDP("udp", iface, addr)
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadBuffer(udpReadBuffer)
+	return conn, nil
+}
+
+/*
+ingestRTSP plays the RTSP stream with the RTP packets interleaved in the TCP connection.
+MPEG-TS payloads are passed through, H.264 is depacketized and muxed to MPEG-TS.
+*/
+func (sb *ThreadfinBuffer) ingestRTSP(ctx context.Context, rawURL string, w io.Writer) (error, int) {
+	client, err := rtsp.Dial(ctx, rawURL, getProviderUserAgent(sb.Stream.PlaylistID))
+	if err != nil {
+		return err, RTSPError
+	}
+
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+	defer client.Close()
+
+	if err := client.Play(); err != nil {
+		return err, RTSPError
+	}
+
+	var track = client.Track
+	ShowInfo(fmt.Sprintf("Streaming:RTSP track %s (%d)", track.Codec, track.PayloadType))
+
+	var ingest = rtpIngest{h264: &rtp.H264Depacketizer{SPS: track.SPS, PPS: track.PPS}}
+	for {
+		data, err := client.ReadPacket()
+		if err != nil {
+			return err, ReadIntoBufferError
+		}
+
+		packet, err := rtp.Parse(data)
+		if err != nil || int(packet.PayloadType) != track.PayloadType {
+			continue
+		}
+
+		if track.Codec == "MP2T" {
+			err = ingest.writeTS(w, packet.Payload)
+		} else {
+			err = ingest.writeH264(w, packet)
+		}
+		if err != nil {
+			return err, ReadIntoBufferError
+		}
+	}
+}
+
+// rtpIngest converts the payloads of the RTP packets to a MPEG-TS stream
+type rtpIngest struct {
+	h264   *rtp.H264Depacketizer
+	muxer  *mpegts.Muxer
+	synced bool
+
+	pts           int64
+	lastTimestamp uint32
+	hasTimestamp  bool
+}
+
+// writeTS writes the MPEG-TS payload, data before the first sync byte is dropped
+func (in *rtpIngest) writeTS(w io.Writer, data []byte) error {
+	if !in.synced {
+		if len(data) == 0 || data[0] != mpegts.SyncByte {
+			return nil
+		}
+		in.synced = true
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// writeH264 depacketizes the RTP packet, the access units are muxed starting with the first key frame
+func (in *rtpIngest) writeH264(w io.Writer, packet rtp.Packet) error {
+	if in.muxer == nil {
+		in.muxer = mpegts.NewMuxer(w)
+	}
+
+	for _, unit := range in.h264.Push(packet) {
+		if !in.synced && !unit.Key {
+			continue
+		}
+		in.synced = true
+
+		// Extend the 32 bit RTP timestamp, the PTS continues across the wrap around
+		if in.hasTimestamp {
+			in.pts += int64(int32(unit.Timestamp - in.lastTimestamp))
+		} else {
+			in.pts, in.hasTimestamp = rtpStartPTS, true
+		}
+		in.lastTimestamp = unit.Timestamp
+
+		if in.pts < 0 {
+			return errors.New("RTP timestamps are not monotonic")
+		}
+		if err := in.muxer.WriteH264(unit.Data, in.pts, unit.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
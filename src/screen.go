@@ -320,6 +320,8 @@ func getErrMsg(errCode int) (errMsg string) {
 		errMsg = "No valid streaming URL"
 	case 2020:
 		errMsg = "FFmpeg binary was not found. Check the FFmpeg binary path in the Threadfin settings."
+	case 2022:
+		errMsg = "Command binary was not found. Check the command path in the Threadfin settings."
 
 	case 2098:
 		errMsg = "Updates are disabled in the settings"
@@ -384,7 +386,7 @@ func getErrMsg(errCode int) (errMsg string) {
 	case 4016:
 		errMsg = "Could not read buffered file before sending to clients"
 	case 4017:
-		errMsg = "Cannot stream from M3U file, please use ffmpeg or VLC"
+		errMsg = "Cannot stream HLS with fMP4 segments, please use ffmpeg or VLC"
 	case 4018:
 		errMsg = "Error while reading thirdparty stdErr"
 	case 4019:
@@ -395,6 +397,28 @@ func getErrMsg(errCode int) (errMsg string) {
 		errMsg = "Error when writing chunks to pipe"
 	case 4022:
 		errMsg = "Error when writing bytes to pipe"
+	case 4023:
+		errMsg = "Tuner limit reached, no HLS stream available"
+	case 4024:
+		errMsg = "Client could not keep up with the stream"
+	case 4025:
+		errMsg = "Upstream stopped sending data"
+	case 4026:
+		errMsg = "Client not found"
+	case 4027:
+		errMsg = "Stream not found"
+	case 4028:
+		errMsg = "Buffer quota reached, no new stream possible"
+	case 4029:
+		errMsg = "Not enough free space in the temp folder"
+	case 4030:
+		errMsg = "The provider denied access to the stream (403 Forbidden)"
+	case 4031:
+		errMsg = "The stream was not found at the provider (404 Not Found)"
+	case 4032:
+		errMsg = "The provider requires authorization (401 Unauthorized)"
+	case 4033:
+		errMsg = "The provider refused the connection"
 
 	// PID saving and deleting
 	case 4040:
@@ -415,6 +439,26 @@ func getErrMsg(errCode int) (errMsg string) {
 		errMsg = "Invalid M3U8 file"
 	case 4051:
 		errMsg = "#EXTM3U header is missing"
+	case 4052:
+		errMsg = "Could not load HLS media playlist"
+	case 4053:
+		errMsg = "Could not download HLS segment"
+	case 4054:
+		errMsg = "Could not decrypt HLS segment"
+	case 4055:
+		errMsg = "Cannot stream HLS with separate audio renditions, please use ffmpeg or VLC"
+
+	// Buffer (UDP, RTP, RTSP)
+	case 4060:
+		errMsg = "Could not join the UDP multicast group"
+	case 4061:
+		errMsg = "Could not play the RTSP stream"
+	case 4062:
+		errMsg = "Unsupported RTP payload, only MPEG-TS and H.264 can be ingested"
+	case 4070:
+		errMsg = "Tuner limit reached, no stream available for the radio channel"
+	case 4080:
+		errMsg = "HEAD request to the streaming server failed"
 
 	// Caching
 	case 4100:
@@ -422,6 +466,38 @@ func getErrMsg(errCode int) (errMsg string) {
 	case 4101:
 		errMsg = "Invalid URL, original URL is used for this image"
 
+	// Recordings
+	case 4200:
+		errMsg = "Recording not found"
+	case 4201:
+		errMsg = "Channel for the recording not found"
+	case 4202:
+		errMsg = "No programme found for the recording"
+	case 4203:
+		errMsg = "Tuner limit reached, the recording is waiting for a free tuner"
+	case 4204:
+		errMsg = "Could not create recording file"
+	case 4205:
+		errMsg = "Invalid recording time window"
+	case 4206:
+		errMsg = "Recording rule not found"
+	case 4207:
+		errMsg = "Recordings require a buffer (Threadfin, FFmpeg or VLC)"
+
+	// Channel health
+	case 4300:
+		errMsg = "Channel health scan failed"
+	case 4301:
+		errMsg = "No MPEG-TS sync bytes found"
+	case 4302:
+		errMsg = "The URL can't be probed"
+
+	// Slates
+	case 4310:
+		errMsg = "Unknown slate"
+	case 4311:
+		errMsg = "Could not create the slate video"
+
 	// API
 	case 5000:
 		errMsg = "Invalid API command"
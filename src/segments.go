@@ -0,0 +1,187 @@
+package src
+
+import (
+	"sync"
+	"time"
+)
+
+// Segment describes a completed buffer file (<Sequence>.ts within the stream folder)
+type Segment struct {
+	Sequence int
+	Duration time.Duration
+	Size     int
+	Created  time.Time
+}
+
+// SegmentList keeps track of the completed segments that are still available in the buffer folder
+type SegmentList struct {
+	mu       sync.RWMutex
+	segments []Segment
+}
+
+func NewSegmentList() *SegmentList {
+	return &SegmentList{}
+}
+
+// Add appends a completed segment to the list
+func (l *SegmentList) Add(segment Segment) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.segments) == 0 {
+		bufferedStreams.Add(1)
+	}
+	l.segments = append(l.segments, segment)
+	bufferedBytes.Add(int64(segment.Size))
+}
+
+// Remove deletes the segment with the given sequence number from the list
+func (l *SegmentList) Remove(sequence int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, segment := range l.segments {
+		if segment.Sequence == sequence {
+			l.segments = append(l.segments[:i], l.segments[i+1:]...)
+			bufferedBytes.Add(-int64(segment.Size))
+			if len(l.segments) == 0 {
+				bufferedStreams.Add(-1)
+			}
+			return
+		}
+	}
+}
+
+// Get returns the segment with the given sequence number
+func (l *SegmentList) Get(sequence int) (Segment, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, segment := range l.segments {
+		if segment.Sequence == sequence {
+			return segment, true
+		}
+	}
+	return Segment{}, false
+}
+
+// Latest returns up to count of the newest segments, oldest first
+func (l *SegmentList) Latest(count int) []Segment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	start := 0
+	if count > 0 && len(l.segments) > count {
+		start = len(l.segments) - count
+	}
+	return append([]Segment{}, l.segments[start:]...)
+}
+
+// After returns all segments with a sequence number greater than the given one, oldest first
+func (l *SegmentList) After(sequence int) []Segment {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i, segment := range l.segments {
+		if segment.Sequence > sequence {
+			return append([]Segment{}, l.segments[i:]...)
+		}
+	}
+	return nil
+}
+
+/*
+SequenceBefore returns the segment that contains the byte, which is the given number of bytes before the end
+of the given segment. If there are not enough segments, the oldest one is returned.
+*/
+func (l *SegmentList) SequenceBefore(sequence, bytes int) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		if l.segments[i].Sequence > sequence {
+			continue
+		}
+		if bytes < l.segments[i].Size || i == 0 {
+			return l.segments[i].Sequence
+		}
+		bytes -= l.segments[i].Size
+	}
+	return sequence
+}
+
+// DurationAfter returns the playback time of all segments newer than the given sequence number
+func (l *SegmentList) DurationAfter(sequence int) (duration time.Duration) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, segment := range l.segments {
+		if segment.Sequence > sequence {
+			duration += segment.Duration
+		}
+	}
+	return
+}
+
+/*
+SequenceBehindLive returns the sequence number of the segment that starts the given time before the live edge.
+If the offset is larger than the available segments, the oldest segment is returned.
+*/
+func (l *SegmentList) SequenceBehindLive(offset time.Duration) (int, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.segments) == 0 {
+		return 0, false
+	}
+	var duration time.Duration
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		duration += l.segments[i].Duration
+		if duration >= offset {
+			return l.segments[i].Sequence, true
+		}
+	}
+	return l.segments[0].Sequence, true
+}
+
+// Oldest returns the oldest available segment
+func (l *SegmentList) Oldest() (Segment, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.segments) == 0 {
+		return Segment{}, false
+	}
+	return l.segments[0], true
+}
+
+// Newest returns the latest completed segment
+func (l *SegmentList) Newest() (Segment, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.segments) == 0 {
+		return Segment{}, false
+	}
+	return l.segments[len(l.segments)-1], true
+}
+
+// Size returns the size of all available segments in bytes
+func (l *SegmentList) Size() (size int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, segment := range l.segments {
+		size += segment.Size
+	}
+	return
+}
+
+// Len returns the number of available segments
+func (l *SegmentList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.segments)
+}
+
+// Clear removes all segments from the list
+func (l *SegmentList) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.segments) > 0 {
+		bufferedStreams.Add(-1)
+	}
+	for _, segment := range l.segments {
+		bufferedBytes.Add(-int64(segment.Size))
+	}
+	l.segments = nil
+}
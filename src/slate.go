@@ -0,0 +1,223 @@
+package src
+
+import (
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"threadfin/src/internal/mpegts"
+)
+
+// Slates are looped to the clients instead of the stream, while the upstream is not available
+const (
+	SlateOffline      = "offline"      // The upstream stopped sending data
+	SlateError        = "error"        // The provider returned an error or could not be reached
+	SlateReconnecting = "reconnecting" // The watchdog reconnects to the upstream or switches to a backup URL
+)
+
+var slateKinds = []string{SlateOffline, SlateError, SlateReconnecting}
+
+const (
+	SlateUnknownError = 4310 //errMsg = "Unknown slate"
+	SlateCreateError  = 4311 //errMsg = "Could not create the slate video"
+
+	slateDefaultDuration = 750 * time.Millisecond // Used if the slate video has no timestamps
+)
+
+// slatePlayer loops a slate to the clients of a stream
+type slatePlayer struct {
+	mu   sync.Mutex
+	kind string
+	stop chan struct{}
+}
+
+func isSlateKind(kind string) bool {
+	for _, k := range slateKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func getSlateFile(kind string) string {
+	return System.Folder.Slates + kind + ".ts"
+}
+
+/*
+slateForError returns the slate for the error of the upstream.
+A stalled or ended upstream is offline, every other error is a provider error.
+*/
+func slateForError(errCode int) string {
+	switch errCode {
+	case StreamStallError, EndOfFileError:
+		return SlateOffline
+	default:
+		return SlateError
+	}
+}
+
+// GetSlates returns the slates which have been uploaded
+func GetSlates() (slates []string) {
+	slates = []string{}
+	for _, kind := range slateKinds {
+		if _, err := os.Stat(getSlateFile(kind)); err == nil {
+			slates = append(slates, kind)
+		}
+	}
+	return
+}
+
+/*
+uploadSlate saves the uploaded image or video as slate. Images and videos other than MPEG-TS are converted with FFmpeg.
+*/
+func uploadSlate(kind, input, filename string) error {
+	if !isSlateKind(kind) {
+		return errors.New(getErrMsg(SlateUnknownError))
+	}
+
+	fileBytes, err := b64.StdEncoding.DecodeString(input[strings.IndexByte(input, ',')+1:])
+	if err != nil {
+		return err
+	}
+
+	var extension = strings.ToLower(filepath.Ext(filename))
+	if extension == ".ts" {
+		return writeByteToFile(getSlateFile(kind), fileBytes)
+	}
+
+	var source = System.Folder.Slates + kind + "-upload" + extension
+	if err = writeByteToFile(source, fileBytes); err != nil {
+		return err
+	}
+	defer os.Remove(source)
+
+	return convertSlate(source, getSlateFile(kind), extension)
+}
+
+// convertSlate converts the image or video into a MPEG-TS video
+func convertSlate(source, target, extension string) error {
+	if _, err := os.Stat(Settings.FFmpegPath); err != nil {
+		return fmt.Errorf("%s: FFmpeg path is not valid", getErrMsg(SlateCreateError))
+	}
+
+	var arguments []string
+	switch extension {
+	case ".png", ".jpg", ".jpeg", ".gif", ".bmp", ".webp":
+		arguments = []string{"-y", "-loop", "1", "-i", source, "-t", "1", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-vf", "scale=1920:1080", "-f", "mpegts", target}
+	default:
+		arguments = []string{"-y", "-i", source, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-vf", "scale=1920:1080", "-c:a", "aac", "-f", "mpegts", target}
+	}
+
+	ShowInfo("Streaming Status:Creating slate video " + filepath.Base(target))
+	if output, err := exec.Command(Settings.FFmpegPath, arguments...).CombinedOutput(); err != nil {
+		os.Remove(target)
+		ShowDebug(fmt.Sprintf("Streaming:FFmpeg output: %s", output), 3)
+		return fmt.Errorf("%s: %s", getErrMsg(SlateCreateError), err.Error())
+	}
+	ShowInfo("Streaming Status:Successfully created slate video " + filepath.Base(target))
+	return nil
+}
+
+// deleteSlate removes the slate, the connection is closed again if the upstream fails
+func deleteSlate(kind string) error {
+	if !isSlateKind(kind) {
+		return errors.New(getErrMsg(SlateUnknownError))
+	}
+	if err := os.Remove(getSlateFile(kind)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+slateLoop returns the slate with its PAT and PMT in front. Every loop is marked as discontinuity,
+the timestamps and continuity counters start again.
+*/
+func slateLoop(content []byte) []byte {
+	var randomAccess mpegts.RandomAccess
+	for i := 0; i+mpegts.PacketSize <= len(content); i += mpegts.PacketSize {
+		randomAccess.Packet(content[i : i+mpegts.PacketSize])
+	}
+
+	var splicer mpegts.Splicer
+	splicer.Splice(randomAccess.PSI())
+	return splicer.Write(content)
+}
+
+/*
+showSlate loops the slate to the clients of the stream until hideSlate is called or the stream ends.
+It reports false if the slate has not been uploaded. HLS and time-shift clients read the segments
+on their own and don't get the slate.
+*/
+func (s *Stream) showSlate(kind string) bool {
+	if s.Radio || s.audioType() != "" {
+		// The slates are videos
+		return false
+	}
+
+	s.slate.mu.Lock()
+	defer s.slate.mu.Unlock()
+
+	if s.slate.kind == kind {
+		return true
+	}
+
+	content, err := os.ReadFile(getSlateFile(kind))
+	if err != nil {
+		return false
+	}
+
+	var duration = slateDefaultDuration
+	var timestamps mpegts.Timestamps
+	timestamps.Write(content)
+	if d, ok := timestamps.Duration(); ok && d > 0 {
+		duration = d
+	}
+
+	if s.slate.stop != nil {
+		close(s.slate.stop)
+	}
+	s.slate.kind, s.slate.stop = kind, make(chan struct{})
+	s.resetWarmStart()
+	ShowInfo(fmt.Sprintf("Streaming:Showing the %s slate (%s)", kind, s.Name))
+
+	var loop = slateLoop(content)
+	go func(stop chan struct{}) {
+		for {
+			s.pushToClients(loop, false)
+			select {
+			case <-stop:
+				return
+			case <-s.Ctx.Done():
+				return
+			case <-time.After(duration):
+			}
+		}
+	}(s.slate.stop)
+	return true
+}
+
+// hideSlate stops the slate, the clients receive the stream again
+func (s *Stream) hideSlate() {
+	s.slate.mu.Lock()
+	defer s.slate.mu.Unlock()
+
+	if s.slate.stop == nil {
+		return
+	}
+	close(s.slate.stop)
+	ShowInfo(fmt.Sprintf("Streaming:Hiding the %s slate (%s)", s.slate.kind, s.Name))
+	s.slate.kind, s.slate.stop = "", nil
+
+	// The live data continues with the tables of the stream, the timestamps jump back to the live ones
+	s.mu.Lock()
+	s.splice.Splice(s.warm.randomAccess.PSI())
+	s.mu.Unlock()
+}
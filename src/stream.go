@@ -1,16 +1,21 @@
 package src
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/avfs/avfs"
+
+	"threadfin/src/internal/history"
+	"threadfin/src/internal/mpegts"
 )
 
 // Stream repräsentiert einen einzelnen Stream
@@ -24,20 +29,40 @@ type Stream struct {
 	Cancel    context.CancelFunc
 
 	Folder            string
+	BufferConfig      BufferConfig
 	UseBackup         bool
 	BackupNumber      int
 	DoAutoReconnect   bool
 
 	StopTimer *time.Timer
 	TimerCancel context.CancelFunc
+
+	slate  slatePlayer
+	warm   warmStart       // Guarded by mu
+	splice mpegts.Splicer  // Marks the switch from a slate back to the live data, guarded by mu
+	events []history.Event // Failover events for the session history, guarded by mu
+	audio  string          // Content type of an audio-only upstream, guarded by mu
+	source *StreamInfo     // Cached stream info the URLs are taken from, guarded by mu
+	hls    *hlsSegments    // HLS segments, created when the first HLS client joins, guarded by mu
+
+	metricLabels string // Labels of the stream metrics
 }
 
 type Client struct {
 	w http.ResponseWriter
 	r *http.Request
-	buffer *bytes.Buffer
-	flushChannel chan struct{}
-	doneChannel chan struct{}
+	queue *ClientQueue
+	writerDone chan struct{}
+	hls bool // HLS clients request the segments on their own and don't get data from Broadcast
+	timeshift bool // Time-shift clients read the buffered segments on their own and don't get data from Broadcast
+	priority int
+
+	remoteAddr string
+	userAgent  string
+	user       string // Authenticated user of the request, empty without credentials
+	started    time.Time
+	hlsSent    atomic.Int64 // Bytes of the segments requested by a HLS client
+	sequence   atomic.Int64 // Segment read by a time-shift client
 }
 
 type ErrorInfo struct {
@@ -61,10 +86,13 @@ func CreateStream(streamInfo *StreamInfo, fileSystem avfs.VFS, errorChan chan Er
 		PipeReader: pipeReader,
 		StopChan: make(chan struct{}),
 		CloseChan: make(chan struct{}),
+		Segments: NewSegmentList(),
+		Activity: NewInputActivity(),
 	}
+	var bufferConfig = getBufferConfig(streamInfo)
 	var buffer BufferInterface
-	switch Settings.Buffer {
-	case "vlc", "ffmpeg":
+	switch bufferConfig.Type {
+	case "vlc", "ffmpeg", "command":
 		buffer = &ThirdPartyBuffer{
 			StreamBuffer: streamBuffer,
 		}
@@ -77,22 +105,28 @@ func CreateStream(streamInfo *StreamInfo, fileSystem avfs.VFS, errorChan chan Er
 		return nil		
 	}
 	
+	// The backup handling changes the URL, don't touch the cached stream info
+	var info = *streamInfo
 	stream := &Stream{
-		StreamInfo: streamInfo,
+		StreamInfo: &info,
 		Buffer:            buffer,
 		ErrorChan:         errorChan,
 		Ctx:               ctx,
 		Cancel:            cancel,
 		Folder:            folder,
+		BufferConfig:      bufferConfig,
 		Clients:           make(map[string]*Client),
 		BackupNumber:      0,
 		UseBackup:         false,
 		DoAutoReconnect:   Settings.BufferAutoReconnect,
+		source:            Data.Cache.StreamingURLS[streamInfo.channelURLid()],
+		metricLabels:      streamMetricLabels(streamInfo),
 	}
 	if err := buffer.StartBuffer(stream); err != nil {
 		return nil
 	}
 	go buffer.addBufferedFilesToPipe()
+	go stream.watch()
 	return stream
 }
 
@@ -105,6 +139,8 @@ func CreateTunerLimitReachedStream() *Stream {
 		PipeReader: pipeReader,
 		StopChan: make(chan struct{}),
 		CloseChan: make(chan struct{}),
+		Segments: NewSegmentList(),
+		Activity: NewInputActivity(),
 	}
 	var buffer BufferInterface = streamBuffer
 	stream := &Stream{
@@ -124,6 +160,10 @@ func CreateTunerLimitReachedStream() *Stream {
 }
 
 func CloseClientConnection(w http.ResponseWriter) {
+	if w == nil {
+		// HLS clients have no open connection
+		return
+	}
 	// Set the header
 	w.Header().Set("Connection", "close")
 	// Close the connection explicitly
@@ -150,52 +190,122 @@ func (s *Stream) Broadcast() {
 		default:
 			n, err := pipeReader.Read(buffer)
 			if err != nil {
-				if err != io.EOF {
+				// The pipe has been closed, the stream has ended
+				if err != io.EOF && err != io.ErrClosedPipe {
 					fmt.Printf("Streaming:Error when reading from pipe: %v\n", err)
 				}
-				break
-			}
-			
-			s.mu.Lock()
-			for clientID, client := range s.Clients {
-				_, err := client.buffer.Write(buffer[:n])
-				if err != nil {
-					s.ReportError(err, 0, clientID, false)
-				}
-				select {
-                case client.flushChannel <- struct{}{}:
-					<-client.doneChannel
-                default:
-					// Skip sending if the channel is full
-					ShowDebug(fmt.Sprintf("Skipped sending data to client: %s", clientID), 3)
-                }
+				return
 			}
-			s.mu.Unlock()
+			s.pushToClients(buffer[:n], true)
 		}
     }
 }
 
+/*
+pushToClients adds the data to the queues of the clients which receive the live stream.
+Every client has its own queue, a slow client can not hold up the others.
+The data of the upstream (live) is kept for the warm start of new clients.
+*/
+func (s *Stream) pushToClients(data []byte, live bool) {
+	var slowClients []string
+	var hls *hlsSegments
+	var out = data
+	s.mu.Lock()
+	if live && s.audio == "" {
+		s.warm.add(data)
+	}
+	if live {
+		hls = s.hls
+		out = s.splice.Write(data)
+	}
+	for clientID, client := range s.Clients {
+		if client.hls || client.timeshift {
+			continue
+		}
+		if !client.queue.Push(out, Settings.BufferSlowClientPolicy) {
+			slowClients = append(slowClients, clientID)
+		}
+	}
+	s.mu.Unlock()
+
+	// The HLS segments are written outside of the lock of the stream, startHLS keeps the order of the data
+	if hls != nil {
+		hls.mu.Lock()
+		hls.write(data)
+		hls.mu.Unlock()
+	}
+
+	for _, clientID := range slowClients {
+		s.ReportError(errors.New(getErrMsg(SlowClientError)), SlowClientError, clientID, false)
+	}
+}
+
+/*
+handleClientWrites sends the queued data to the client until the queue gets closed
+*/
 func (s *Stream) handleClientWrites(client *Client, clientID string) {
-    for {
-        select {
-        case <-client.flushChannel:
-			if client.buffer == nil || client.w == nil {
-				s.ReportError(fmt.Errorf("client or writer is nil"),0 ,clientID, false)
-				return
-			}
-            _, err := client.buffer.WriteTo(client.w)
-			if err != nil {
+	defer close(client.writerDone)
+	var out = &clientWriter{stream: s, client: client}
+	for {
+		data, err := client.queue.Pop(32 * 1024)
+		if err != nil {
+			return
+		}
+		if err := out.write(data); err != nil {
+			if client.r.Context().Err() == nil {
 				s.ReportError(err, 0, clientID, false)
-				return
 			}
-            if flusher, ok := client.w.(http.Flusher); ok {
-                flusher.Flush()
-            }
-			client.doneChannel <- struct{}{}
-        case <-client.r.Context().Done():
-            return
-        }
-    }
+			return
+		}
+	}
+}
+
+/*
+handleTimeshift feeds the client from the buffered segments, starting offset behind the live edge
+or with the given sequence (-1 for none). A paused client fills its queue and holds up only this reader.
+If the client has been paused for longer than the time-shift window, it continues with the oldest available segment.
+*/
+func (s *Stream) handleTimeshift(client *Client, clientID string, offset time.Duration, sequence int) {
+	var segments = s.Buffer.GetSegments()
+
+	for {
+		if sequence == -1 {
+			if start, ok := segments.SequenceBehindLive(offset); ok {
+				sequence = start
+				ShowDebug(fmt.Sprintf("Streaming:Time-shift client %s starts with segment %d", clientID, sequence), 2)
+			}
+		}
+
+		if sequence != -1 {
+			if _, exists := segments.Get(sequence); exists {
+				client.sequence.Store(int64(sequence))
+				data, err := s.Buffer.ReadSegment(sequence)
+				if err == nil {
+					if !client.queue.PushWait(data) {
+						return
+					}
+					sequence++
+					continue
+				}
+			}
+
+			// The segment has been deleted while the client was paused
+			if oldest, ok := segments.Oldest(); ok && oldest.Sequence > sequence {
+				ShowDebug(fmt.Sprintf("Streaming:Time-shift client %s skipped to segment %d", clientID, oldest.Sequence), 2)
+				sequence = oldest.Sequence
+				continue
+			}
+		}
+
+		// Wait for the next segment
+		select {
+		case <-client.writerDone:
+			return
+		case <-s.Ctx.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
 
 func (s *Stream) ReportError(err error, errCode int, clientID string, closed bool) {
@@ -203,41 +313,93 @@ func (s *Stream) ReportError(err error, errCode int, clientID string, closed boo
 }
 
 func (s *Stream) StopStream(streamID string) {
-	var pipeWriter *io.PipeWriter
-	switch buffer := s.Buffer.(type) {
-	case *ThirdPartyBuffer:
-		pipeWriter = buffer.PipeWriter
+	if pipeWriter := s.Buffer.GetPipeWriter(); pipeWriter != nil {
+		pipeWriter.Close()
 	}
-	pipeWriter.Close()
-	for clientID, client := range s.Clients {
-		CloseClientConnection(client.w)
-		delete(s.Clients, clientID)
-		ShowInfo(fmt.Sprintf("Streaming:Client kicked %s, total: %d", streamID, len(s.Clients)))
-		if len(s.Clients) == 0 {
-			s.Cancel() // Tell everyone about the ending of the stream
-			s.Buffer.CloseBuffer()
-		}
+	var clients = s.removeAllClients()
+	for clientID, client := range clients {
+		client.Disconnect()
+		s.recordSession(streamID, clientID, client)
+		ShowInfo(fmt.Sprintf("Streaming:Client kicked %s", streamID))
+	}
+	if len(clients) > 0 {
+		s.Cancel() // Tell everyone about the ending of the stream
+		s.Buffer.CloseBuffer()
 	}
 }
 
+/*
+RemoveClientFromStream disconnects a single client, the other clients of the stream are not affected
+*/
 func (s *Stream) RemoveClientFromStream(streamID, clientID string) {
-	var pipeWriter *io.PipeWriter
-	switch buffer := s.Buffer.(type) {
-	case *ThirdPartyBuffer:
-		pipeWriter = buffer.PipeWriter
+	if client, remaining, exists := s.removeClient(clientID); exists {
+		client.Disconnect()
+		s.recordSession(streamID, clientID, client)
+		ShowInfo(fmt.Sprintf("Streaming:Removed client from %s, total: %d", streamID, remaining))
 	}
-	pipeWriter.Close()
-	if client, exists := s.Clients[clientID]; exists {
-		CloseClientConnection(client.w)
-		delete(s.Clients, clientID)
-		ShowInfo(fmt.Sprintf("Streaming:Removed client from %s, total: %d", streamID, len(s.Clients)))
+}
+
+/*
+removeClient removes the client from the stream, it returns the client and the number of the remaining clients.
+The clients are added and removed while Broadcast and the buffer iterate over them, every access to the map
+of the clients goes through the lock of the stream.
+*/
+func (s *Stream) removeClient(clientID string) (client *Client, remaining int, exists bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, exists = s.Clients[clientID]
+	delete(s.Clients, clientID)
+	return client, len(s.Clients), exists
+}
+
+// removeAllClients removes the clients from the stream and returns them
+func (s *Stream) removeAllClients() map[string]*Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clients = s.Clients
+	s.Clients = make(map[string]*Client)
+	return clients
+}
+
+// getClient returns the client of the stream with the given ID
+func (s *Stream) getClient(clientID string) (*Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, exists := s.Clients[clientID]
+	return client, exists
+}
+
+// clientList returns a copy of the clients of the stream
+func (s *Stream) clientList() map[string]*Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.Clients)
+}
+
+// clientCount returns the number of clients of the stream
+func (s *Stream) clientCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Clients)
+}
+
+/*
+Disconnect ends the response to the client. The writer goroutine stops and ServeStream returns.
+*/
+func (c *Client) Disconnect() {
+	if c.queue != nil {
+		c.queue.Close()
+		return
 	}
+	CloseClientConnection(c.w)
 }
 
 /*
 UpdateStreamURLForBackup will set the ther stream url when a backup will be used
 */
 func (s *Stream) UpdateStreamURLForBackup() {
+	metrics.backupSwitch(s)
+	s.addEvent("backup", fmt.Sprintf("Backup channel %d", s.BackupNumber))
 	switch s.BackupNumber {
 	case 1:
 		s.URL = s.BackupChannel1URL
@@ -254,6 +416,35 @@ func (s *Stream) UpdateStreamURLForBackup() {
 	}
 }
 
+/*
+refreshURLs takes over the URLs of the channel if the provider changed them since the stream was started
+(e.g. a rotated token). It is called before a reconnect, the running connection keeps its URL.
+*/
+func (s *Stream) refreshURLs() bool {
+	cached, ok := Data.Cache.StreamingURLS[s.channelURLid()]
+
+	s.mu.Lock()
+	var changed = ok && s.source != nil && cached != s.source && !sameUpstream(cached, s.source)
+	if ok && s.source != nil {
+		s.source = cached
+	}
+	s.mu.Unlock()
+
+	if !changed {
+		return false
+	}
+
+	var info = *cached
+	rewriteUpstreamURLs(&info)
+	s.URL, s.HTTP_HEADER = info.URL, info.HTTP_HEADER
+	s.BackupChannel1URL, s.BackupChannel2URL, s.BackupChannel3URL = info.BackupChannel1URL, info.BackupChannel2URL, info.BackupChannel3URL
+	s.UseBackup, s.BackupNumber = false, 0
+
+	ShowInfo(fmt.Sprintf("Streaming:%s continues with the new URL of the provider", s.Name))
+	s.addEvent("url", "New URL of the provider")
+	return true
+}
+
 /*
 HandleBufferError will retry running the Buffer function with the next backup number
 */
@@ -1,12 +1,14 @@
 package src
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -24,6 +26,8 @@ type StreamManager struct {
 	stopChan              chan bool
 	LockAgainstNewStreams bool
 	FileSystem            avfs.VFS
+	HLSSessions           map[string]*HLSSession
+	ClientSessions        map[string]*ClientSession
 	mu                    sync.Mutex
 }
 
@@ -41,8 +45,13 @@ func NewStreamManager() *StreamManager {
 		errorChan:  make(chan ErrorInfo),
 		stopChan:   make(chan bool),
 		FileSystem: nil,
+		HLSSessions: make(map[string]*HLSSession),
+		ClientSessions: make(map[string]*ClientSession),
 	}
 
+	// Remove HLS clients that stopped requesting the playlist
+	go sm.expireHLSSessions()
+
 	// Start a go routine that will check for the error channel
 	go func() {
 		for {
@@ -60,10 +69,12 @@ func NewStreamManager() *StreamManager {
 					errorInfo.Stream.RemoveClientFromStream(streamID, errorInfo.ClientID)
 				} else {
 					// Buffer disconnect error
-					clients := stream.Clients
-					if len(clients) > 0 && errorInfo.BufferClosed {
+					if stream.clientCount() > 0 && errorInfo.BufferClosed {
 						if stream.DoAutoReconnect{
 							if buffer, ok := stream.Buffer.(*ThirdPartyBuffer); ok {
+								metrics.bufferRestart(stream)
+								stream.addEvent("restart", errorInfo.Error.Error())
+								stream.refreshURLs()
 								buffer.StartBuffer(stream)
 								continue
 							} 
@@ -81,9 +92,11 @@ func NewStreamManager() *StreamManager {
 
 /*
 StartStream starts the ffmpeg process for buffering a stream
-It will check if the stream already exists
+It will check if the stream already exists. If the tuner limit has been reached,
+a stream with a lower priority is stopped. An error is returned if the buffer quota or the free space
+of the temp folder does not allow a new stream.
 */
-func (sm *StreamManager) StartStream(streamInfo *StreamInfo) (clientID string, playlistID string) {
+func (sm *StreamManager) StartStream(streamInfo *StreamInfo, priority int) (clientID string, playlistID string, err error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
@@ -94,9 +107,22 @@ func (sm *StreamManager) StartStream(streamInfo *StreamInfo) (clientID string, p
 	// set URL ID as stream ID
 	streamID := streamInfo.URLid
 
+	// attach to a running stream of another playlist or channel with the same upstream
+	if playlist, exists := sm.Playlists[playlistID]; !exists || playlist.Streams[streamID] == nil {
+		if sharedPlaylistID, sharedStreamID, found := sm.findSharedStream(streamInfo); found {
+			ShowInfo(fmt.Sprintf("Streaming:Sharing the upstream connection of %s with %s", sharedStreamID, streamID))
+			playlistID, streamID = sharedPlaylistID, sharedStreamID
+			streamInfo.URLid = sharedStreamID
+		}
+	}
+
 	// check if playlist already exists
 	_, exists := sm.Playlists[playlistID]
 	if !exists {
+		if err = sm.checkBufferSpace(); err != nil {
+			return "", "", err
+		}
+
 		// create a new one
 		playlist := &Playlist{
 			Name:    getProviderParameter(playlistID, GetPlaylistType(playlistID), "name"),
@@ -106,13 +132,16 @@ func (sm *StreamManager) StartStream(streamInfo *StreamInfo) (clientID string, p
 		sm.Playlists[playlistID] = playlist
 
 		// check if a new stream is possible
-		if sm.IsNewStreamPossible(streamInfo) {
+		if sm.IsNewStreamPossible(streamInfo) || sm.preemptStream(streamInfo, priority) {
 			// create a new buffer and add the stream to the map within the new playlist
 			sm.Playlists[playlistID].Streams[streamID] = CreateStream(streamInfo, sm.FileSystem, sm.errorChan)
 			if sm.Playlists[playlistID].Streams[streamID] == nil {
-				return "", ""
+				return "", "", nil
 			}
 			ShowInfo(fmt.Sprintf("Streaming:Started streaming for %s", streamID))
+		} else if streamInfo.Radio {
+			// The tuner limit video can't be played by radio clients
+			return "", "", errors.New(getErrMsg(RadioTunerLimitError))
 		} else {
 			streamInfo.URLid = "TunerLimitReached"
 			if _, exists := sm.Playlists[playlistID].Streams["TunerLimitReached"]; !exists {
@@ -125,11 +154,17 @@ func (sm *StreamManager) StartStream(streamInfo *StreamInfo) (clientID string, p
 		// check if the stream already exists
 		stream, exists := sm.Playlists[playlistID].Streams[streamID]
 		if !exists {
+			if err = sm.checkBufferSpace(); err != nil {
+				return "", "", err
+			}
+
 			// check if a new stream is possible
-			if sm.IsNewStreamPossible(streamInfo) {
+			if sm.IsNewStreamPossible(streamInfo) || sm.preemptStream(streamInfo, priority) {
 				// create a new buffer and add the stream to the map within the existing playlist
 				sm.Playlists[playlistID].Streams[streamID] = CreateStream(streamInfo, sm.FileSystem, sm.errorChan)
 				ShowInfo(fmt.Sprintf("Streaming:Started streaming for %s", streamID))
+			} else if streamInfo.Radio {
+				return "", "", errors.New(getErrMsg(RadioTunerLimitError))
 			} else {
 				streamInfo.URLid = "TunerLimitReached"
 				if _, exists := sm.Playlists[playlistID].Streams["TunerLimitReached"]; !exists {
@@ -139,17 +174,40 @@ func (sm *StreamManager) StartStream(streamInfo *StreamInfo) (clientID string, p
 				}
 			}
 		} else {
-			if len(stream.Clients) == 0 {
-				if stream.StopTimer != nil {
-					stream.StopTimer.Stop()
-					stream.StopTimer = nil
-					stream.TimerCancel = nil
-				}
-				stream.Buffer.SetStopChan(make(chan struct{}))
-				go stream.Buffer.addBufferedFilesToPipe()
+			var clients = stream.clientCount()
+			if clients == 0 {
+				stream.reactivate()
 			}
 			// Here we can check if multiple clients for one stream is allowed!
-			ShowInfo(fmt.Sprintf("Streaming:Client joined %s, total: %d", streamID, len(stream.Clients)+1))
+			ShowInfo(fmt.Sprintf("Streaming:Client joined %s, total: %d", streamID, clients+1))
+		}
+	}
+	return
+}
+
+/*
+findSharedStream returns the running stream which receives the same upstream as the given stream,
+its URL and request headers are equal. The stream must use the same buffer type and options, the
+channels may override them. The caller must hold the lock.
+*/
+func (sm *StreamManager) findSharedStream(streamInfo *StreamInfo) (playlistID, streamID string, found bool) {
+	var key = upstreamKey(streamInfo.PlaylistID, streamInfo.URL, streamInfo.HTTP_HEADER)
+	var bufferConfig *BufferConfig
+	for pID, playlist := range sm.Playlists {
+		for sID, stream := range playlist.Streams {
+			if sID == "TunerLimitReached" || stream == nil || stream.Ctx.Err() != nil || stream.Profile != streamInfo.Profile {
+				continue
+			}
+			if upstreamKey(stream.PlaylistID, stream.URL, stream.HTTP_HEADER) != key {
+				continue
+			}
+			if bufferConfig == nil {
+				var config = getBufferConfig(streamInfo)
+				bufferConfig = &config
+			}
+			if stream.BufferConfig.Type == bufferConfig.Type && stream.BufferConfig.Options == bufferConfig.Options {
+				return pID, sID, true
+			}
 		}
 	}
 	return
@@ -164,15 +222,39 @@ func InitBufferVFS(virtual bool) avfs.VFS {
 }
 
 /*
-IsNewStreamPossible reports whether there is a new connection allowed
+IsNewStreamPossible reports whether there is a new connection allowed.
+The tuner limit of the playlist, the tuner pools of the playlist and the global limit are checked.
 */
 func (sm *StreamManager) IsNewStreamPossible(streamInfo *StreamInfo) bool {
-	playlistID := streamInfo.PlaylistID
-	if len(sm.Playlists[playlistID].Streams) < GetTuner(playlistID, GetPlaylistType(playlistID)) {
-		return true
-	} else {
-		return false
+	return sm.isNewStreamPossibleWithout(streamInfo, nil)
+}
+
+/*
+CanStartStream reports an error if a client of the stream with the given priority would not get the stream,
+because of the buffer quota or the tuner limit
+*/
+func (sm *StreamManager) CanStartStream(streamInfo *StreamInfo, priority int) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.LockAgainstNewStreams {
+		return errors.New(getErrMsg(4203))
 	}
+	if playlist, exists := sm.Playlists[streamInfo.PlaylistID]; exists {
+		if _, exists := playlist.Streams[streamInfo.URLid]; exists {
+			return nil
+		}
+	}
+	if err := sm.checkBufferSpace(); err != nil {
+		return err
+	}
+	if sm.IsNewStreamPossible(streamInfo) {
+		return nil
+	}
+	if _, _, found := sm.findPreemptableStream(streamInfo, priority); found {
+		return nil
+	}
+	return errors.New(getErrMsg(4203))
 }
 
 /*
@@ -185,42 +267,72 @@ func (sm *StreamManager) StopStream(playlistID string, streamID string, clientID
 	playlist, exists := sm.Playlists[playlistID]
 	if exists {
 		if stream, exists := playlist.Streams[streamID]; exists {
-			if client, exists := stream.Clients[clientID]; exists {
-				CloseClientConnection(client.w)
-				delete(stream.Clients, clientID)
-				ShowInfo(fmt.Sprintf("Streaming:Client left %s, total: %d", streamID, len(stream.Clients)))
-				if len(stream.Clients) == 0 {
+			if client, remaining, exists := stream.removeClient(clientID); exists {
+				client.Disconnect()
+				stream.recordSession(streamID, clientID, client)
+				ShowInfo(fmt.Sprintf("Streaming:Client left %s, total: %d", streamID, remaining))
+				if remaining == 0 {
 					stream.Buffer.StopBuffer()
-					// Start a timer to stop the stream after a delay
+					// Start a timer to stop the stream after a delay, a client that left may come back within the grace window
+					timeout := time.Duration(Settings.BufferTerminationTimeout) * time.Second
+					if sm.hasClientSession(playlistID, streamID) {
+						timeout = max(timeout, clientSessionGrace())
+					}
                     cancel := func() {
 						sm.mu.Lock()
 						defer sm.mu.Unlock()
-						stream.Cancel() // Tell everyone about the ending of the stream
-						stream.Buffer.CloseBuffer()
-
-						ShowInfo(fmt.Sprintf("Streaming:Stopped streaming for %s", streamID))
-						var debug = fmt.Sprintf("Streaming:Remove temporary files (%s)", stream.Folder)
-						ShowDebug(debug, 1)
-
-						debug = fmt.Sprintf("Streaming:Remove tmp folder %s", stream.Folder)
-						ShowDebug(debug, 1)
-
-						if err := sm.FileSystem.RemoveAll(stream.Folder); err != nil {
-							ShowError(err, 4005)
-						}
-						delete(sm.Playlists[playlistID].Streams, streamID)				
-						if len(sm.Playlists[playlistID].Streams) == 0 {
-							delete(sm.Playlists, playlistID)
-						}
+						sm.removeStream(playlistID, streamID, stream)
 					}
 					stream.TimerCancel = cancel
-                    stream.StopTimer = time.AfterFunc(time.Duration(Settings.BufferTerminationTimeout) * time.Second, cancel)
+                    stream.StopTimer = time.AfterFunc(timeout, cancel)
 				}
 			}
 		}
 	}
 }
 
+/*
+removeStream stops the buffer of the stream and removes it with its temporary files.
+The caller must hold the lock.
+*/
+func (sm *StreamManager) removeStream(playlistID, streamID string, stream *Stream) {
+	playlist, exists := sm.Playlists[playlistID]
+	if !exists || playlist.Streams[streamID] != stream {
+		// The stream has already been removed
+		return
+	}
+
+	if stream.StopTimer != nil {
+		stream.StopTimer.Stop()
+		stream.StopTimer = nil
+		stream.TimerCancel = nil
+	}
+	stream.Cancel() // Tell everyone about the ending of the stream
+	stream.Buffer.StopBuffer()
+	stream.Buffer.CloseBuffer()
+	if hls := stream.hlsOutput(); hls != nil {
+		hls.clear()
+	}
+	stream.keepStreamCodecs(streamID)
+
+	ShowInfo(fmt.Sprintf("Streaming:Stopped streaming for %s", streamID))
+	var debug = fmt.Sprintf("Streaming:Remove temporary files (%s)", stream.Folder)
+	ShowDebug(debug, 1)
+
+	debug = fmt.Sprintf("Streaming:Remove tmp folder %s", stream.Folder)
+	ShowDebug(debug, 1)
+
+	if stream.Folder != "" {
+		if err := sm.FileSystem.RemoveAll(stream.Folder); err != nil {
+			ShowError(err, 4005)
+		}
+	}
+	delete(playlist.Streams, streamID)
+	if len(playlist.Streams) == 0 {
+		delete(sm.Playlists, playlistID)
+	}
+}
+
 func (sm *StreamManager) StopAllStreams() {
 	for _, playlist := range sm.Playlists {
 		for streamID, stream := range playlist.Streams {
@@ -243,7 +355,28 @@ func (sm *StreamManager) ServeStream(streamInfo *StreamInfo, w http.ResponseWrit
 		sm.FileSystem = InitBufferVFS(Settings.StoreBufferInRAM)
 	}
 
-	clientID, playlistID := sm.StartStream(streamInfo)
+	// StartStream changes the URL ID for shared streams and the tuner limit video, don't touch the cached stream info
+	var info = *streamInfo
+	streamInfo = &info
+
+	var priority = getClientPriority(r)
+	var sessionKey = getClientSessionKey(streamInfo, r)
+	var resumeSequence = -1
+	var clientID, playlistID string
+	var err error
+
+	// A client that came back within the grace window continues in the buffered segments. The connection gets
+	// its own client ID, the handler of the old connection may not have removed its client yet.
+	if session, _, ok := sm.resumeClientSession(sessionKey); ok {
+		clientID, playlistID, resumeSequence = uuid.New().String(), session.PlaylistID, session.Sequence
+		streamInfo.URLid = session.StreamID
+	} else {
+		clientID, playlistID, err = sm.StartStream(streamInfo, priority)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
 	if clientID == "" || playlistID == "" {
 		return
 	}
@@ -253,25 +386,57 @@ func (sm *StreamManager) ServeStream(streamInfo *StreamInfo, w http.ResponseWrit
 	client := &Client{
 		r: r,
 		w: w,
-		buffer: new(bytes.Buffer),
-		flushChannel: make(chan struct{}, 1),
-		doneChannel: make(chan struct{}, 1),
+		queue: NewClientQueue(Settings.BufferClientSize * 1024),
+		writerDone: make(chan struct{}),
+		priority: priority,
+		remoteAddr: r.RemoteAddr,
+		userAgent: r.UserAgent(),
+		user: getStreamUser(r),
+		started: time.Now(),
 	}
 	stream := sm.Playlists[playlistID].Streams[streamInfo.URLid]
-	stream.Clients[clientID] = client
+
+	// Only a client that asks for an offset or resumes reads the segments on its own, the others get the live data
+	// with the warm start, the slates and the slow client policy. The tuner limit video has no segments to shift.
+	var offset = getTimeshiftOffset(r)
+	client.timeshift = (offset > 0 || resumeSequence >= 0) && stream.Folder != ""
+	client.sequence.Store(int64(resumeSequence))
+	var first = stream.addClient(clientID, client)
 
 	// Start a goroutine to handle writing to the client
     go stream.handleClientWrites(client, clientID)
 
-	// Make sure Broadcast is running only once
-	if len(stream.Clients) == 1 {
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-type", "video/mpeg2ts")
+	if client.timeshift {
+		go stream.handleTimeshift(client, clientID, offset, resumeSequence)
+	}
+
+	// Make sure Broadcast is running only once, the headers are written with the first data
+	if first {
 		go stream.Broadcast()
 	}
 
-	// Wait for the client context to get closed
-	<-r.Context().Done()
+	// Wait for the client context to get closed or the client to be removed from the stream
+	select {
+	case <-r.Context().Done():
+	case <-client.writerDone:
+	}
+	if r.Context().Err() != nil {
+		// The client closed the connection, it wasn't removed by Threadfin
+		sm.leaveClientSession(sessionKey, playlistID, streamInfo.URLid, clientID, stream, client)
+	}
+	client.queue.Close()
+	<-client.writerDone
+}
+
+/*
+getTimeshiftOffset returns the requested delay (?offset=<seconds>), limited to the time-shift window
+*/
+func getTimeshiftOffset(r *http.Request) time.Duration {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, time.Duration(Settings.BufferTimeshift)*time.Minute)
 }
 
 /*
@@ -323,16 +488,129 @@ func CreatePlaylistStruct(name string, streams map[string]*Stream) *PlaylistStru
 		PlaylistName:      name,
 		ActiveChannels:    &[]string{},
 		ClientConnections: 0,
+		Clients:           []*ClientStruct{},
 	}
 
 	// Iterate over every stream within the map
-	for _, stream := range streams {
+	for streamID, stream := range streams {
 		*playlist.ActiveChannels = append(*playlist.ActiveChannels, stream.Name)
-		playlist.ClientConnections += len(stream.Clients)
+		var clients = stream.clientList()
+		playlist.ClientConnections += len(clients)
+		for clientID, client := range clients {
+			playlist.Clients = append(playlist.Clients, CreateClientStruct(streamID, stream, clientID, client))
+		}
 	}
 	return playlist
 }
 
+/*
+CreateClientStruct will extract the info of a single client of the stream
+*/
+func CreateClientStruct(streamID string, stream *Stream, clientID string, client *Client) *ClientStruct {
+	var clientStruct = &ClientStruct{
+		ClientID:    clientID,
+		PlaylistID:  stream.PlaylistID,
+		StreamID:    streamID,
+		ChannelName: stream.Name,
+		IP:          client.remoteAddr,
+		UserAgent:   client.userAgent,
+		Started:     client.started,
+		Priority:    client.priority,
+		HLS:         client.hls,
+		Timeshift:   client.timeshift,
+		BytesSent:   client.hlsSent.Load(),
+	}
+	if ip, _, err := net.SplitHostPort(client.remoteAddr); err == nil {
+		clientStruct.IP = ip
+	}
+	if client.queue != nil {
+		clientStruct.Lag = client.queue.Stats()
+		clientStruct.BytesSent = clientStruct.Lag.Sent
+	}
+	return clientStruct
+}
+
+/*
+GetClients returns every client of every stream, sorted by the start time
+*/
+func (sm *StreamManager) GetClients() []*ClientStruct {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	var clients = []*ClientStruct{}
+	for _, playlist := range sm.Playlists {
+		for streamID, stream := range playlist.Streams {
+			for clientID, client := range stream.clientList() {
+				clients = append(clients, CreateClientStruct(streamID, stream, clientID, client))
+			}
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Started.Before(clients[j].Started)
+	})
+	return clients
+}
+
+/*
+KickClient disconnects a single client. If it was the last client of the stream, the stream is stopped
+right away and the tuner is free again.
+*/
+func (sm *StreamManager) KickClient(clientID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for playlistID, playlist := range sm.Playlists {
+		for streamID, stream := range playlist.Streams {
+			if _, exists := stream.getClient(clientID); !exists {
+				continue
+			}
+
+			ShowInfo(fmt.Sprintf("Streaming:Kicking client %s from %s", clientID, stream.Name))
+			stream.RemoveClientFromStream(streamID, clientID)
+			sm.removeHLSSessions(func(session *HLSSession) bool { return session.ClientID == clientID })
+			if stream.clientCount() == 0 {
+				sm.removeStream(playlistID, streamID, stream)
+			}
+			return nil
+		}
+	}
+	return errors.New(getErrMsg(4026))
+}
+
+/*
+TerminateStream disconnects all clients of the stream and stops it, so a stuck tuner is released
+*/
+func (sm *StreamManager) TerminateStream(playlistID, streamID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	playlist, exists := sm.Playlists[playlistID]
+	if !exists {
+		return errors.New(getErrMsg(4027))
+	}
+	stream, exists := playlist.Streams[streamID]
+	if !exists || stream == nil {
+		return errors.New(getErrMsg(4027))
+	}
+
+	ShowInfo(fmt.Sprintf("Streaming:Terminating stream %s", stream.Name))
+	stream.StopStream(streamID)
+	sm.removeHLSSessions(func(session *HLSSession) bool {
+		return session.PlaylistID == playlistID && session.StreamID == streamID
+	})
+	sm.removeStream(playlistID, streamID, stream)
+	return nil
+}
+
+// removeHLSSessions deletes the matching HLS sessions, the caller must hold the lock
+func (sm *StreamManager) removeHLSSessions(match func(session *HLSSession) bool) {
+	for sessionID, session := range sm.HLSSessions {
+		if match(session) {
+			delete(sm.HLSSessions, sessionID)
+		}
+	}
+}
+
 /*
 GetStreamLimitContent will check if there is already a custuom video that will be provided to client.
 
@@ -426,11 +704,11 @@ GetTuner returns the maximum number of connections for a playlist.
 It will check if the buffer type is matching the third party buffers
 */
 func GetTuner(id, playlistType string) (tuner int) {
-	switch Settings.Buffer {
+	switch getPlaylistBufferType(id) {
 	case "-":
 		tuner = Settings.Tuner
 
-	case "ffmpeg", "vlc", "threadfin":
+	case "ffmpeg", "vlc", "command", "threadfin":
 		i, err := strconv.Atoi(getProviderParameter(id, playlistType, "tuner"))
 		if err == nil {
 			tuner = i
@@ -487,7 +765,7 @@ func CreateAlternativNoMoreStreamsVideo(pathToFile string) error {
 // TODO: Add description
 func prepareArguments(pathToFile string) (string, []string) {
 	switch Settings.Buffer {
-	case "ffmpeg", "threadfin", "-":
+	case "ffmpeg", "command", "threadfin", "-":
 		return Settings.FFmpegPath, []string{"-loop", "1", "-i", pathToFile, "-c:v", "libx264", "-t", "1", "-pix_fmt", "yuv420p", "-vf", "scale=1920:1080", fmt.Sprintf("%sstream-limit.ts", System.Folder.Video)}
 	case "vlc":
 		return Settings.VLCPath, []string{"--no-audio", "--loop", "--sout", fmt.Sprintf("'#transcode{vcodec=h264,vb=1024,scale=1,width=1920,height=1080,acodec=none,venc=x264{preset=ultrafast}}:standard{access=file,mux=ts,dst=%sstream-limit.ts}'", System.Folder.Video), System.Folder.Video, pathToFile}
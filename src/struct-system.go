@@ -36,10 +36,16 @@ type SystemStruct struct {
 		Path           string
 	}
 
+	Command struct {
+		DefaultOptions string
+	}
+
 	File struct {
 		Authentication string
+		History        string
 		M3U            string
 		PMS            string
+		Recordings     string
 		Settings       string
 		URLS           string
 		XEPG           string
@@ -68,6 +74,7 @@ type SystemStruct struct {
 		Data         string
 		ImagesCache  string
 		ImagesUpload string
+		Slates       string
 		Temp         string
 		Video        string
 	}
@@ -209,6 +216,13 @@ type XEPGChannelStruct struct {
 	BackupChannel1URL  string `json:"backup_channel_1_url"`
 	BackupChannel2URL  string `json:"backup_channel_2_url"`
 	BackupChannel3URL  string `json:"backup_channel_3_url"`
+	XHealth            *ChannelHealth `json:"x-health,omitempty"`
+	XBuffer            string `json:"x-buffer,omitempty"`         // Buffer type of the channel, empty uses the provider or global setting
+	XBufferOptions     string `json:"x-buffer-options,omitempty"` // FFmpeg or VLC arguments of the channel
+	XBufferSize        string `json:"x-buffer-size,omitempty"`    // Buffer size of the channel in KB
+	XTranscodingProfile string `json:"x-transcoding-profile,omitempty"` // Default transcoding profile of the channel
+	Radio              string `json:"radio,omitempty"`   // Radio attribute of the playlist
+	XAudio             string `json:"x-audio,omitempty"` // Content type of the audio-only upstream, detected by Threadfin
 }
 
 // M3UChannelStructXEPG : M3U Struktur für XEPG
@@ -222,6 +236,7 @@ type M3UChannelStructXEPG struct {
 	TvgLogo     string `json:"tvg-logo"`
 	TvgChno     string `json:"tvg-chno"`
 	TvgName     string `json:"tvg-name"`
+	Radio       string `json:"radio"`
 	URL         string `json:"url"`
 	UUIDKey     string `json:"_uuid.key"`
 	UUIDValue   string `json:"_uuid.value"`
@@ -259,6 +274,11 @@ type StreamInfo struct {
 	BackupChannel3URL string `json:"backup_channel_3_url"`
 	URLid             string `json:"urlID"`
 	HTTP_HEADER		  map[string]string
+	Profile           string `json:"profile,omitempty"` // Transcoding profile, set for the request of a client
+	Radio             bool   `json:"radio,omitempty"`   // Radio channel, set for the request of a client
+	XEPG              string `json:"xepg,omitempty"`    // XEPG channel of the stable URL ID, empty for URL IDs of the provider URL
+
+	channel *resolvedChannel // XEPG channel resolved for the request of a client, see withChannel
 }
 
 // Notification : Notifikationen im Webinterface
@@ -286,6 +306,29 @@ type SettingsStruct struct {
 	BufferTimeout     float64    `json:"buffer.timeout"`
 	BufferAutoReconnect bool     `json:"buffer.autoReconnect"`
 	BufferTerminationTimeout int `json:"buffer.terminationTimeout"`
+	BufferSlowClientPolicy string `json:"buffer.slowClientPolicy"`
+	BufferClientSize  int        `json:"buffer.client.size.kb"`
+	BufferTimeshift   int        `json:"buffer.timeshift.minutes"`
+	BufferStallTimeout int       `json:"buffer.stallTimeout"`
+	BufferReconnectAttempts int  `json:"buffer.reconnectAttempts"`
+	BufferQuotaStream int        `json:"buffer.quota.stream.mb"`
+	BufferQuotaTotal  int        `json:"buffer.quota.total.mb"`
+	BufferMinFreeSpace int       `json:"buffer.minFreeSpace.mb"`
+	SlateTimeout      int        `json:"slate.timeout"`
+	BufferSessionGrace int       `json:"buffer.session.grace"`
+	HistoryRetention  int        `json:"history.retention.days"`
+	RadioRemux        bool       `json:"radio.remux"`
+	RadioHDHR         bool       `json:"radio.hdhr"`
+	TranscodingProfiles []TranscodingProfile `json:"transcoding.profiles"`
+	TranscodingRules  []TranscodingRule `json:"transcoding.rules"`
+	HealthScanInterval int       `json:"health.scan.interval"`
+	HealthMode        string     `json:"health.mode"`
+	HeadMode          string     `json:"head.mode"`
+	HealthTimeout     int        `json:"health.timeout"`
+	HealthDeadAfter   int        `json:"health.deadAfter"`
+	HealthHideDead    bool       `json:"health.hideDead"`
+	RecordingPath     string     `json:"recording.path"`
+	RecordingPadding  int        `json:"recording.padding.minutes"`
 	CacheImages       bool       `json:"cache.images"`
 	ChangeVersion     bool       `json:"changeVersion"`
 	EpgSource         string     `json:"epgSource"`
@@ -293,6 +336,8 @@ type SettingsStruct struct {
 	FFmpegPath        string     `json:"ffmpeg.path"`
 	VLCOptions        string     `json:"vlc.options"`
 	VLCPath           string     `json:"vlc.path"`
+	CommandOptions    string     `json:"command.options"`
+	CommandPath       string     `json:"command.path"`
 	FileM3U           []string   `json:"file,omitempty"`  // Beim Wizard wird die M3U in ein Slice gespeichert
 	FileXMLTV         []string   `json:"xmltv,omitempty"` // Altes Speichersystem der Provider XML Datei Slice (Wird für die Umwandlung auf das neue benötigt)
 
@@ -313,11 +358,16 @@ type SettingsStruct struct {
 	SSDP                      bool                  `json:"ssdp"`
 	TempPath                  string                `json:"temp.path"`
 	Tuner                     int                   `json:"tuner"`
+	TunerGlobal               int                   `json:"tuner.global"`
+	TunerPools                []TunerPool           `json:"tuner.pools"`
+	TunerPriorities           []ClientPriority      `json:"tuner.priorities"`
+	TunerRecordingPriority    int                   `json:"tuner.priority.recording"`
 	Update                    []string              `json:"update"`
 	UpdateURL                 string                `json:"update.url,omitempty"`
 	UserAgent                 string                `json:"user.agent"`
 	UUID                      string                `json:"uuid"`
 	UDPxy                     string                `json:"udpxy"`
+	UDPInterface              string                `json:"udp.interface"` // Network interface for multicast, empty uses the system default
 	Version                   string                `json:"version"`
 	XepgReplaceMissingImages  bool                  `json:"xepg.replace.missing.images"`
 	XepgReplaceChannelTitle   bool                  `json:"xepg.replace.channel.title"`
@@ -1,6 +1,11 @@
 package src
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+
+	"threadfin/src/internal/history"
+)
 
 type WebServer struct {
 	Server *http.Server
@@ -22,6 +27,11 @@ type RequestStruct struct {
 	// Restore
 	Base64 string `json:"base64,omitempty"`
 
+	// Streams and clients
+	ClientID   string `json:"clientID,omitempty"`
+	PlaylistID string `json:"playlistID,omitempty"`
+	StreamID   string `json:"streamID,omitempty"`
+
 	// Neue Werte für die Einstellungen (settings.json)
 	Settings struct {
 		API                      *bool     `json:"api,omitempty"`
@@ -38,16 +48,46 @@ type RequestStruct struct {
 		BufferTimeout            *float64  `json:"buffer.timeout,omitempty"`
 		BufferTerminationTimeout *int      `json:"buffer.terminationTimeout,omitempty"`
 		BufferAutoReconnect		 *bool	   `json:"buffer.autoReconnect,omitempty"`
+		BufferSlowClientPolicy   *string   `json:"buffer.slowClientPolicy,omitempty"`
+		BufferClientSize         *int      `json:"buffer.client.size.kb,omitempty"`
+		BufferTimeshift          *int      `json:"buffer.timeshift.minutes,omitempty"`
+		BufferStallTimeout       *int      `json:"buffer.stallTimeout,omitempty"`
+		BufferReconnectAttempts  *int      `json:"buffer.reconnectAttempts,omitempty"`
+		BufferQuotaStream        *int      `json:"buffer.quota.stream.mb,omitempty"`
+		BufferQuotaTotal         *int      `json:"buffer.quota.total.mb,omitempty"`
+		BufferMinFreeSpace       *int      `json:"buffer.minFreeSpace.mb,omitempty"`
+		SlateTimeout             *int      `json:"slate.timeout,omitempty"`
+		BufferSessionGrace       *int      `json:"buffer.session.grace,omitempty"`
+		HistoryRetention         *int      `json:"history.retention.days,omitempty"`
+		RadioRemux               *bool     `json:"radio.remux,omitempty"`
+		RadioHDHR                *bool     `json:"radio.hdhr,omitempty"`
+		TranscodingProfiles      *[]TranscodingProfile `json:"transcoding.profiles,omitempty"`
+		TranscodingRules         *[]TranscodingRule    `json:"transcoding.rules,omitempty"`
+		HealthScanInterval       *int      `json:"health.scan.interval,omitempty"`
+		HealthMode               *string   `json:"health.mode,omitempty"`
+		HeadMode                 *string   `json:"head.mode,omitempty"`
+		HealthTimeout            *int      `json:"health.timeout,omitempty"`
+		HealthDeadAfter          *int      `json:"health.deadAfter,omitempty"`
+		HealthHideDead           *bool     `json:"health.hideDead,omitempty"`
+		RecordingPath            *string   `json:"recording.path,omitempty"`
+		RecordingPadding         *int      `json:"recording.padding.minutes,omitempty"`
 		CacheImages              *bool     `json:"cache.images,omitempty"`
 		EpgSource                *string   `json:"epgSource,omitempty"`
 		FFmpegOptions            *string   `json:"ffmpeg.options,omitempty"`
 		FFmpegPath               *string   `json:"ffmpeg.path,omitempty"`
 		VLCOptions               *string   `json:"vlc.options,omitempty"`
 		VLCPath                  *string   `json:"vlc.path,omitempty"`
+		CommandOptions           *string   `json:"command.options,omitempty"`
+		CommandPath              *string   `json:"command.path,omitempty"`
 		FilesUpdate              *bool     `json:"files.update,omitempty"`
 		TempPath                 *string   `json:"temp.path,omitempty"`
 		Tuner                    *int      `json:"tuner,omitempty"`
+		TunerGlobal              *int      `json:"tuner.global,omitempty"`
+		TunerPools               *[]TunerPool      `json:"tuner.pools,omitempty"`
+		TunerPriorities          *[]ClientPriority `json:"tuner.priorities,omitempty"`
+		TunerRecordingPriority   *int      `json:"tuner.priority.recording,omitempty"`
 		UDPxy                    *string   `json:"udpxy,omitempty"`
+		UDPInterface             *string   `json:"udp.interface,omitempty"`
 		Update                   *[]string `json:"update,omitempty"`
 		UserAgent                *string   `json:"user.agent,omitempty"`
 		XepgReplaceMissingImages *bool     `json:"xepg.replace.missing.images,omitempty"`
@@ -74,6 +114,9 @@ type RequestStruct struct {
 	// Upload Logo
 	Filename string `json:"filename,omitempty"`
 
+	// Slate (offline, error or reconnecting)
+	Slate string `json:"slate,omitempty"`
+
 	// Filter
 	Filter map[int64]interface{} `json:"filter,omitempty"`
 
@@ -140,6 +183,7 @@ type ResponseStruct struct {
 	Status              bool                   `json:"status"`
 	Token               string                 `json:"token,omitempty"`
 	Users               map[string]interface{} `json:"users,omitempty"`
+	Clients             []*ClientStruct        `json:"clients,omitempty"`
 	Wizard              int                    `json:"wizard,omitempty"`
 	XEPG                map[string]interface{} `json:"xepg"`
 
@@ -152,6 +196,23 @@ type APIRequestStruct struct {
 	Password string `json:"password"`
 	Token    string `json:"token"`
 	Username string `json:"username"`
+
+	// Streams and clients
+	ClientID   string `json:"clientID,omitempty"`
+	PlaylistID string `json:"playlistID,omitempty"`
+	StreamID   string `json:"streamID,omitempty"`
+
+	// Slates
+	Slate    string `json:"slate,omitempty"`
+	Base64   string `json:"base64,omitempty"`
+	Filename string `json:"filename,omitempty"`
+
+	// Recordings
+	Recording     *Recording     `json:"recording,omitempty"`
+	RecordingRule *RecordingRule `json:"recordingRule,omitempty"`
+
+	// History, sessions of the last days (0 = all)
+	Days int `json:"days,omitempty"`
 }
 
 // APIResponseStruct : Antwort an den Client (API)
@@ -159,6 +220,14 @@ type APIResponseStruct struct {
 	Error         string               `json:"error,omitempty"`
 	SystemInfo    *SystemInfoStruct    `json:"systemInfo,omitempty"`
 	ActiveStreams *ActiveStreamsStruct `json:"activeStreams,omitempty"`
+	Clients       []*ClientStruct      `json:"clients,omitempty"`
+	Recordings    []*Recording         `json:"recordings,omitempty"`
+	RecordingRules []*RecordingRule    `json:"recordingRules,omitempty"`
+	ChannelHealth []ChannelHealthEntry `json:"channelHealth,omitempty"`
+	BufferUsage   *BufferUsageStruct   `json:"bufferUsage,omitempty"`
+	Slates        []string             `json:"slates,omitempty"`
+	History       []history.Session    `json:"history,omitempty"`
+	HistoryStats  *HistoryStatsStruct  `json:"historyStats,omitempty"`
 	Token         string               `json:"token,omitempty"`
 }
 
@@ -167,9 +236,25 @@ type ActiveStreamsStruct struct {
 }
 
 type PlaylistStruct struct {
-	PlaylistName      string    `json:"playlistName"`
-	ActiveChannels    *[]string `json:"activeChannels"`
-	ClientConnections int       `json:"clienConnections"`
+	PlaylistName      string          `json:"playlistName"`
+	ActiveChannels    *[]string       `json:"activeChannels"`
+	ClientConnections int             `json:"clienConnections"`
+	Clients           []*ClientStruct `json:"clients"`
+}
+
+type ClientStruct struct {
+	ClientID    string           `json:"clientID"`
+	PlaylistID  string           `json:"playlistID"`
+	StreamID    string           `json:"streamID"`
+	ChannelName string           `json:"channelName"`
+	IP          string           `json:"ip"`
+	UserAgent   string           `json:"userAgent"`
+	Started     time.Time        `json:"started"`
+	BytesSent   int64            `json:"bytesSent"`
+	Priority    int              `json:"priority"`
+	HLS         bool             `json:"hls"`
+	Timeshift   bool             `json:"timeshift"`
+	Lag         ClientQueueStats `json:"lag"`
 }
 
 type SystemInfoStruct struct {
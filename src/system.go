@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/url"
 	"os"
 	"reflect"
@@ -81,11 +82,16 @@ func createSystemFiles() (err error) {
 			System.File.XEPG = filename
 		case "urls.json":
 			System.File.URLS = filename
+		case "recordings.json":
+			System.File.Recordings = filename
 
 		}
 
 	}
 
+	// The session history is a JSON lines file, it is created when it is opened
+	System.File.History = getPlatformFile(System.Folder.Config + "history.jsonl")
+
 	return
 }
 
@@ -135,10 +141,34 @@ func loadSettings() (settings SettingsStruct, err error) {
 	defaults["buffer.timeout"] = 500
 	defaults["buffer.autoReconnect"] = false
 	defaults["buffer.terminationTimeout"] = 5
+	defaults["buffer.slowClientPolicy"] = SlowClientDrop
+	defaults["buffer.client.size.kb"] = 4096
+	defaults["buffer.timeshift.minutes"] = 0
+	defaults["buffer.stallTimeout"] = 10
+	defaults["buffer.reconnectAttempts"] = 5
+	defaults["buffer.quota.stream.mb"] = 0
+	defaults["buffer.quota.total.mb"] = 0
+	defaults["buffer.minFreeSpace.mb"] = 100
+	defaults["slate.timeout"] = 300
+	defaults["buffer.session.grace"] = 30
+	defaults["history.retention.days"] = 30
+	defaults["radio.remux"] = false
+	defaults["radio.hdhr"] = false
+	defaults["transcoding.profiles"] = defaultTranscodingProfiles
+	defaults["transcoding.rules"] = []interface{}{}
+	defaults["health.scan.interval"] = 0
+	defaults["health.mode"] = HealthModeRead
+	defaults["head.mode"] = HeadModeSynthesize
+	defaults["health.timeout"] = 10
+	defaults["health.deadAfter"] = 3
+	defaults["health.hideDead"] = false
+	defaults["recording.path"] = System.Folder.Config + "recordings" + string(os.PathSeparator)
+	defaults["recording.padding.minutes"] = 2
 	defaults["cache.images"] = false
 	defaults["epgSource"] = "PMS"
 	defaults["ffmpeg.options"] = System.FFmpeg.DefaultOptions
 	defaults["vlc.options"] = System.VLC.DefaultOptions
+	defaults["command.options"] = System.Command.DefaultOptions
 	defaults["files"] = dataMap
 	defaults["files.update"] = true
 	defaults["filter"] = make(map[string]interface{})
@@ -161,10 +191,15 @@ func loadSettings() (settings SettingsStruct, err error) {
 	defaults["epgCategories"] = "Kids:kids|News:news|Movie:movie|Series:series|Sports:sports"
 	defaults["epgCategoriesColors"] = "kids:mediumpurple|news:tomato|movie:royalblue|series:gold|sports:yellowgreen"
 	defaults["tuner"] = 1
+	defaults["tuner.global"] = 0
+	defaults["tuner.pools"] = []interface{}{}
+	defaults["tuner.priorities"] = []interface{}{}
+	defaults["tuner.priority.recording"] = 100
 	defaults["update"] = []string{"0000"}
 	defaults["user.agent"] = System.Name
 	defaults["uuid"] = createUUID()
 	defaults["udpxy"] = ""
+	defaults["udp.interface"] = ""
 	defaults["version"] = System.DBVersion
 	defaults["ThreadfinAutoUpdate"] = true
 	if isRunningInContainer() {
@@ -205,6 +240,10 @@ func loadSettings() (settings SettingsStruct, err error) {
 		settings.VLCPath = searchFileInOS("cvlc")
 	}
 
+	if len(settings.CommandPath) == 0 {
+		settings.CommandPath = searchFileInOS("streamlink")
+	}
+
 	// Initialze virutal filesystem for the Buffer
 	InitBufferVFS(settings.StoreBufferInRAM)
 
@@ -224,6 +263,10 @@ func loadSettings() (settings SettingsStruct, err error) {
 		ShowWarning(2021)
 	}
 
+	if len(Settings.CommandPath) == 0 && Settings.Buffer == "command" {
+		ShowWarning(2022)
+	}
+
 	// Setzen der globalen Domain
 	// Domainnamen setzen
 	var domain = ""
@@ -269,6 +312,10 @@ func saveSettings(settings SettingsStruct) (err error) {
 		settings.BackupPath = System.Folder.Backup
 	}
 
+	if len(settings.RecordingPath) == 0 {
+		settings.RecordingPath = System.Folder.Config + "recordings" + string(os.PathSeparator)
+	}
+
 	if settings.BufferTimeout < 0 {
 		settings.BufferTimeout = 0
 	}
@@ -347,66 +394,139 @@ func setDeviceID() {
 
 // Provider Streaming-URL zu Threadfin Streaming-URL konvertieren
 func createStreamingURL(playlistID, channelNumber, channelName, url_string string, backup_url_1 string, backup_url_2 string, backup_url_3 string) (streamingURL string, err error) {
+	var urlID = legacyStreamID(playlistID, url_string)
+	return cacheStreamingURL(urlID, "", playlistID, channelNumber, channelName, url_string, backup_url_1, backup_url_2, backup_url_3)
+}
 
-	var streamInfo = &StreamInfo{}
+/*
+createChannelStreamingURL returns the streaming URL of the XEPG channel. The URL ID depends on the channel and not on
+the URL of the provider, a new URL (e.g. a rotated token) replaces the stream info of the same URL ID.
+*/
+func createChannelStreamingURL(channel XEPGChannelStruct) (streamingURL string, err error) {
+	return cacheStreamingURL(channelStreamID(channel), channel.XEPG, channel.FileM3UID, channel.XChannelID, channel.XName, channel.URL, channel.BackupChannel1URL, channel.BackupChannel2URL, channel.BackupChannel3URL)
+}
 
-	if len(Data.Cache.StreamingURLS) == 0 {
-		Data.Cache.StreamingURLS = make(map[string]*StreamInfo)
-	}
+// legacyStreamID returns the URL ID of earlier versions, the hash of the playlist and the provider URL
+func legacyStreamID(playlistID, url string) string {
+	return getMD5(fmt.Sprintf("%s-%s", playlistID, url))
+}
 
-	var urlID = getMD5(fmt.Sprintf("%s-%s", playlistID, url_string))
+/*
+forwardLegacyStreamIDs replaces the cached stream infos of earlier versions of the XEPG channels (URL IDs of the
+provider URLs) with entries that forward to the URL ID of the channel. Clients keep the URLs of an old lineup until
+they scan it again. Forwarding entries of channels that no longer have a stream info are removed.
+*/
+func forwardLegacyStreamIDs(channels []XEPGChannelStruct) {
+	type channelKey struct{ playlistID, number, name string }
+	var channelIDs = make(map[channelKey]XEPGChannelStruct)
+	for _, channel := range channels {
+		channelIDs[channelKey{channel.FileM3UID, channel.XChannelID, channel.XName}] = channel
+	}
+
+	for id, streamInfo := range Data.Cache.StreamingURLS {
+		switch {
+		case streamInfo.XEPG == "" && id == legacyStreamID(streamInfo.PlaylistID, streamInfo.URL):
+			// The streaming URL of the XEPG channel was created with the provider URL
+			channel, ok := channelIDs[channelKey{streamInfo.PlaylistID, streamInfo.ChannelNumber, streamInfo.Name}]
+			if !ok {
+				continue
+			}
+			Data.Cache.StreamingURLS[id] = &StreamInfo{
+				ChannelNumber: channel.XChannelID,
+				Name:          channel.XName,
+				PlaylistID:    channel.FileM3UID,
+				URLid:         channelStreamID(channel),
+				XEPG:          channel.XEPG,
+			}
 
-	if s, ok := Data.Cache.StreamingURLS[urlID]; ok {
-		streamInfo = s
-	} else {
-		streamInfo.HTTP_HEADER = make(map[string]string)
-		u, err := url.Parse(url_string)
-		if err != nil {
-			return "", err
+		case streamInfo.XEPG != "" && id != streamInfo.URLid:
+			if _, ok := Data.Cache.StreamingURLS[streamInfo.URLid]; !ok {
+				delete(Data.Cache.StreamingURLS, id)
+			}
 		}
-		if u.Path == "" {
-			return "", fmt.Errorf("no path in the url")
+	}
+}
+
+// loadStreamingURLs reads the cached stream infos (urls.json), they are kept until the playlists are saved again
+func loadStreamingURLs() error {
+	tmp, err := loadJSONFileToMap(System.File.URLS)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(mapToJSON(tmp)), &Data.Cache.StreamingURLS)
+}
+
+// channelStreamID returns the URL ID of the XEPG channel
+func channelStreamID(channel XEPGChannelStruct) string {
+	return getMD5(fmt.Sprintf("%s-%s", channel.FileM3UID, channel.XEPG))
+}
+
+// cacheStreamingURL stores the stream info of the URL ID, a cached stream info is only replaced if the channel changed
+func cacheStreamingURL(urlID, xepg, playlistID, channelNumber, channelName, url_string, backup_url_1, backup_url_2, backup_url_3 string) (streamingURL string, err error) {
+
+	var streamInfo = &StreamInfo{}
+
+	if len(Data.Cache.StreamingURLS) == 0 {
+		// The stream infos of the clients' lineups are kept, the forwarding entries rely on them
+		if err := loadStreamingURLs(); err != nil || Data.Cache.StreamingURLS == nil {
+			Data.Cache.StreamingURLS = make(map[string]*StreamInfo)
 		}
-		splittedPath := strings.Split(u.Path, "|")
-		if len(splittedPath) > 1 {
-			headers := strings.Split(splittedPath[1], "&")
-			for _, value := range headers {
-				pair := strings.Split(value, "=")
-				if len(pair) < 2 {
-					break
-				}
-				streamInfo.HTTP_HEADER[pair[0]] = pair[1]
+	}
+
+	streamInfo.HTTP_HEADER = make(map[string]string)
+	u, err := url.Parse(url_string)
+	if err != nil {
+		return "", err
+	}
+	if u.Path == "" {
+		return "", fmt.Errorf("no path in the url")
+	}
+	splittedPath := strings.Split(u.Path, "|")
+	if len(splittedPath) > 1 {
+		headers := strings.Split(splittedPath[1], "&")
+		for _, value := range headers {
+			pair := strings.Split(value, "=")
+			if len(pair) < 2 {
+				break
 			}
-			streamInfo.URL = strings.Join([]string{u.Scheme + "://", u.Host, splittedPath[0]}, "")
-		} else {
-			streamInfo.URL = url_string
+			streamInfo.HTTP_HEADER[pair[0]] = pair[1]
+		}
+		streamInfo.URL = strings.Join([]string{u.Scheme + "://", u.Host, splittedPath[0]}, "")
+	} else {
+		streamInfo.URL = url_string
+	}
+	streamInfo.BackupChannel1URL = backup_url_1
+	streamInfo.BackupChannel2URL = backup_url_2
+	streamInfo.BackupChannel3URL = backup_url_3
+	streamInfo.Name = channelName
+	streamInfo.PlaylistID = playlistID
+	streamInfo.ChannelNumber = channelNumber
+	streamInfo.URLid = urlID
+	streamInfo.XEPG = xepg
+
+	// Running streams keep the replaced stream info until their next reconnect
+	if s, ok := Data.Cache.StreamingURLS[urlID]; !ok || !reflect.DeepEqual(s, streamInfo) {
+		if ok && !sameUpstream(s, streamInfo) {
+			ShowInfo(fmt.Sprintf("Streaming:New URL for %s", channelName))
 		}
-		streamInfo.BackupChannel1URL = backup_url_1
-		streamInfo.BackupChannel2URL = backup_url_2
-		streamInfo.BackupChannel3URL = backup_url_3
-		streamInfo.Name = channelName
-		streamInfo.PlaylistID = playlistID
-		streamInfo.ChannelNumber = channelNumber
-		streamInfo.URLid = urlID
-
 		Data.Cache.StreamingURLS[urlID] = streamInfo
-
 	}
 
-	streamingURL = System.BaseURL + "/stream/" + streamInfo.URLid
+	streamingURL = System.BaseURL + "/stream/" + urlID
 	return
 }
 
+// sameUpstream reports whether both stream infos request the same URLs with the same headers
+func sameUpstream(a, b *StreamInfo) bool {
+	return a.URL == b.URL && a.BackupChannel1URL == b.BackupChannel1URL && a.BackupChannel2URL == b.BackupChannel2URL &&
+		a.BackupChannel3URL == b.BackupChannel3URL && maps.Equal(a.HTTP_HEADER, b.HTTP_HEADER)
+}
+
 func getStreamInfo(urlID string) (streamInfo *StreamInfo, err error) {
 
 	if len(Data.Cache.StreamingURLS) == 0 {
 
-		tmp, err := loadJSONFileToMap(System.File.URLS)
-		if err != nil {
-			return streamInfo, err
-		}
-
-		err = json.Unmarshal([]byte(mapToJSON(tmp)), &Data.Cache.StreamingURLS)
+		err = loadStreamingURLs()
 		if err != nil {
 			return streamInfo, err
 		}
@@ -414,6 +534,13 @@ func getStreamInfo(urlID string) (streamInfo *StreamInfo, err error) {
 	}
 
 	if s, ok := Data.Cache.StreamingURLS[urlID]; ok {
+		// URL IDs of earlier versions forward to the current stream info of the XEPG channel
+		if s.XEPG != "" && s.URLid != urlID {
+			if s, ok = Data.Cache.StreamingURLS[s.URLid]; !ok {
+				return nil, errors.New("streaming error")
+			}
+		}
+
 		s.URL = strings.Trim(s.URL, "\r\n")
 		s.BackupChannel1URL = strings.Trim(s.BackupChannel1URL, "\r\n")
 		s.BackupChannel2URL = strings.Trim(s.BackupChannel2URL, "\r\n")
@@ -9,7 +9,6 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"syscall"
 )
 
 type ThirdPartyBuffer struct {
@@ -18,6 +17,8 @@ type ThirdPartyBuffer struct {
 	BufferType string
 	Path       string
 	Options    string
+	Progress   *ProcessProgress // Progress of FFmpeg
+	exited     chan struct{}    // Closed when the process of Cmd has exited
 }
 
 
@@ -32,41 +33,79 @@ func (sb *ThirdPartyBuffer) StartBuffer(stream *Stream) error {
 		return fmt.Errorf("could not set buffer config")
 	}
 
-	ShowInfo(fmt.Sprintf("Streaming: Buffer:%s path:%s", sb.BufferType, sb.Path))
-	ShowInfo("Streaming URL:" + stream.URL)
-
-	err := sb.RunBufferCommand(stream)
+	err := sb.StartInput(stream)
 	if err != nil {
 		stream.handleBufferError(err)
 	}
 	return nil
 }
 
-func (sb *ThirdPartyBuffer) StopBuffer() {
-	close(sb.StopChan)
+// StartInput starts the third party tool for the current stream URL, the buffered segments are kept
+func (sb *ThirdPartyBuffer) StartInput(stream *Stream) error {
+	sb.Activity.reset()
+
+	ShowInfo(fmt.Sprintf("Streaming: Buffer:%s path:%s", sb.BufferType, sb.Path))
+	ShowInfo("Streaming URL:" + stream.URL)
+
+	return sb.RunBufferCommand(stream)
+}
+
+// StopInput terminates the third party tool without closing the buffer
+func (sb *ThirdPartyBuffer) StopInput() {
+	done := sb.Activity.stop()
+	sb.stopProcess()
+	waitForInput(done)
+}
+
+// stopProcess terminates the process group of the third party tool and deletes its PID
+func (sb *ThirdPartyBuffer) stopProcess() {
+	if sb.Cmd == nil || sb.Cmd.Process == nil {
+		return
+	}
+	stopProcess(sb.Cmd.Process, sb.exited)
+	DeletPIDfromDisc(fmt.Sprintf("%d", sb.Cmd.Process.Pid))
+}
+
+/*
+closeProcess terminates the process group of the third party tool without waiting for it to exit.
+CloseBuffer is called under the lock of the StreamManager, the process is killed in the background if necessary.
+*/
+func (sb *ThirdPartyBuffer) closeProcess() {
+	if sb.Cmd == nil || sb.Cmd.Process == nil {
+		return
+	}
+	process, exited := sb.Cmd.Process, sb.exited
+	terminateProcess(process)
+
+	go func() {
+		awaitProcess(process, exited)
+		DeletPIDfromDisc(fmt.Sprintf("%d", process.Pid))
+	}()
 }
 
 func (sb *ThirdPartyBuffer) CloseBuffer() {
 	if !sb.Closed{
 		sb.Closed = true
+		sb.StopBuffer()
 		close(sb.CloseChan)
-		sb.Cmd.Process.Signal(syscall.SIGKILL) // Kill the third party tool process
-		sb.Cmd.Wait()
-		DeletPIDfromDisc(fmt.Sprintf("%d", sb.Cmd.Process.Pid)) // Delete the PID since the process has been terminated
+		sb.closeProcess() // Terminate the third party tool process
 		sb.RemoveBufferedFiles(filepath.Join(sb.Stream.Folder, "0.ts"))
 	}
 }
 
-// SetBufferConfig returns the the arguments from the buffer settings in the config file
+// SetBufferConfig returns the the arguments from the buffer config of the stream (global, provider or channel settings)
 func (sb *ThirdPartyBuffer) SetBufferConfig() {
-	sb.BufferType = strings.ToUpper(Settings.Buffer)
+	sb.BufferType = strings.ToUpper(sb.Stream.BufferConfig.Type)
 	switch sb.BufferType {
 	case "FFMPEG":
-		sb.Options = Settings.FFmpegOptions
+		sb.Options = sb.Stream.BufferConfig.Options
 		sb.Path = Settings.FFmpegPath
 	case "VLC":
-		sb.Options = Settings.VLCOptions
+		sb.Options = sb.Stream.BufferConfig.Options
 		sb.Path = Settings.VLCPath
+	case "COMMAND":
+		sb.Options = sb.Stream.BufferConfig.Options
+		sb.Path = Settings.CommandPath
 	default:
 		sb.BufferType = ""
 		sb.Options = ""
@@ -91,7 +130,8 @@ func (sb *ThirdPartyBuffer) RunBufferCommand(stream *Stream) error {
 	args := sb.PrepareBufferArguments()
 
 	cmd := exec.Command(sb.Path, args...)
-	debug := fmt.Sprintf("%s:%s %s", strings.ToUpper(Settings.Buffer), sb.Path, args)
+	setProcessGroup(cmd)
+	debug := fmt.Sprintf("%s:%s %s", sb.BufferType, sb.Path, args)
 	ShowDebug(debug, 1)
 
 	stdOut, stdErr, err := GetCommandPipes(cmd)
@@ -104,16 +144,34 @@ func (sb *ThirdPartyBuffer) RunBufferCommand(stream *Stream) error {
 	}
 	WritePIDtoDisk(fmt.Sprintf("%d", cmd.Process.Pid))
 
-	go ShowCommandStdErrInConsole(sb.BufferType, stdErr)
+	// Only the process is waited for, the pipes are closed by their readers
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if state, err := cmd.Process.Wait(); err == nil && !state.Success() {
+			ShowDebug(fmt.Sprintf("Streaming:%s process %d exited: %s", sb.BufferType, cmd.Process.Pid, state.String()), 1)
+		}
+	}()
+
+	sb.Progress = &ProcessProgress{}
+	go sb.handleStdErr(stdErr, sb.Progress)
 	go sb.HandleByteOutput(stdOut)
 
-	sb.Cmd = cmd
+	sb.Cmd, sb.exited = cmd, exited
 	return nil
 }
 
 // PrepareBufferArguments replaces the [URL] placeholder in the buffer options with the actual stream URL
 func (sb *ThirdPartyBuffer) PrepareBufferArguments() []string {
+	if sb.BufferType == "COMMAND" {
+		return getCommandValues(sb.Stream.StreamInfo).arguments(sb.Options)
+	}
+
 	args := []string{}
+	if sb.BufferType == "FFMPEG" && !strings.Contains(sb.Options, "-progress") {
+		// The progress is written to stderr and parsed by handleStdErr
+		args = append(args, "-progress", "pipe:2", "-nostats")
+	}
 	u, err := url.Parse(sb.Stream.URL)
 	if err != nil {
 		return []string{}
@@ -123,7 +181,7 @@ func (sb *ThirdPartyBuffer) PrepareBufferArguments() []string {
 	}	
 	for i, a := range strings.Split(sb.Options, " ") {
 		a = strings.Replace(a, "[URL]", sb.Stream.URL, 1)
-		if i == 0 && len(Settings.UserAgent) != 0 && Settings.Buffer == "ffmpeg" && u.Scheme != "rtp" {
+		if i == 0 && len(Settings.UserAgent) != 0 && sb.BufferType == "FFMPEG" && u.Scheme != "rtp" {
 			if sb.Stream.HTTP_HEADER != nil {
 				var builder strings.Builder
 				for key, val := range sb.Stream.HTTP_HEADER {
@@ -166,31 +224,6 @@ func GetCommandPipes(cmd *exec.Cmd) (io.ReadCloser, io.ReadCloser, error) {
 	return stdOut, stdErr, nil
 }
 
-// ShowCommandStdErrInConsole reads from the provided io.ReadCloser (stdErr) line by line,
-// and logs each line with the specified bufferType prefix. If an error occurs during scanning,
-// it logs the error with a specific error code.
-//
-// Parameters:
-//   - bufferType: A string that specifies the type of buffer, used as a prefix in the log.
-//   - stdErr: An io.ReadCloser from which the function reads the standard error output.
-//
-// The function uses a bufio.Scanner to read the standard error output line by line,
-// trims any leading or trailing whitespace from each line, and logs it using the ShowInfo function.
-// If an error occurs during scanning, it logs the error using the ShowError function with error code 4018.
-func ShowCommandStdErrInConsole(bufferType string, stdErr io.ReadCloser) {
-	scanner := bufio.NewScanner(stdErr)
-	scanner.Split(bufio.ScanLines)
-
-	for scanner.Scan() {
-		debug := fmt.Sprintf("%s log:%s", bufferType, strings.TrimSpace(scanner.Text()))
-		ShowInfo(debug)
-	}
-
-	if scanner.Err() != nil {
-		ShowError(scanner.Err(), 4018)
-	}
-}
-
 // WritePIDtoDisk saves the PID of the buffering process to a file on disk
 func WritePIDtoDisk(pid string) {
 	// Open the file in append mode (create it if it doesn't exist)
@@ -1,14 +1,13 @@
 package src
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"io"
 	"mime"
 	"net/http"
+	"path"
 	"path/filepath"
 	"strings"
-	"time"
 )
 
 type ThreadfinBuffer struct{
@@ -19,58 +18,48 @@ func (sb *ThreadfinBuffer) StartBuffer(stream *Stream) error {
 	if err := sb.StreamBuffer.StartBuffer(stream); err != nil {
 		return err
 	}
+	return sb.StartInput(stream)
+}
+
+/*
+StartInput requests the stream URL and writes the response into the buffer.
+HLS playlists are followed until the input gets stopped, UDP, RTP and RTSP URLs are received directly.
+*/
+func (sb *ThreadfinBuffer) StartInput(stream *Stream) error {
+	sb.Stream = stream
+	sb.Activity.reset()
+
+	ctx, cancel := context.WithCancel(stream.Ctx)
+	sb.inputCancel = cancel
 
 	ShowInfo(fmt.Sprintf("Streaming:Buffer:%s", "Threadfin"))
 	ShowInfo("Streaming URL:" + stream.URL)
 
 	go func() {
-		resp, err := http.Get(stream.URL)
+		defer cancel()
+
+		if isRTPURL(stream.URL) {
+			sb.ingestRTP(ctx, stream.URL)
+			return
+		}
+
+		resp, err := sb.get(ctx, stream.URL)
 		if err != nil {
+			sb.inputFailed(err, ReadIntoBufferError)
 			return
 		}
-		
-		if contentTypes, exists := resp.Header["Content-Type"]; exists {
-			ShowDebug(fmt.Sprintf("Streaming:%s", contentTypes), 1)
-			extensions := []string{}
-			for _, contentType := range contentTypes {
-				type_extensions, err := mime.ExtensionsByType(contentType)
-				if err != nil {
-					return
-				}
-				if type_extensions == nil {
-					continue
-				}
-				extensions = append(extensions, type_extensions...)
-			}
-						
-			for _, extension := range extensions {
-				if extension == ".m3u" || extension == ".m3u8" {
-					videoURL, audioURL, err := selectStreamFromMaster(resp.Body)
-					if err != nil {
-						ShowError(err, 0)
-						return
-					}
 
-					if videoURL != "" || audioURL != "" {
-						stream.ReportError(fmt.Errorf("Streaming:Can not stream from m3u file"), 4017, "", true)
-						return
-					}
-				}
-			}
+		if isHLSResponse(resp) {
+			ShowInfo("Streaming:HLS playlist detected, following the segments")
+			sb.ingestHLS(ctx, resp.Request.URL.String(), resp.Body)
+			return
 		}
 
-		go stream.Buffer.HandleByteOutput(resp.Body) // Download the video file directly and save to disk
+		// Radio stations send ADTS or MP3 audio without a container, the clients get it with the same content type
+		stream.setAudio(audioContentType(resp))
 
-		for {
-			select {
-			case <-sb.StopChan:
-				resp.Body.Close()
-				time.Sleep(200 * time.Millisecond) // Let the buffer stop before going on
-				return
-			default:
-				continue
-			}
-		}
+		// Download the video file directly and save to disk, cancelling the context closes the body
+		sb.HandleByteOutput(resp.Body)
 	}()
 	return nil
 }
@@ -78,31 +67,32 @@ func (sb *ThreadfinBuffer) StartBuffer(stream *Stream) error {
 func (sb *ThreadfinBuffer) CloseBuffer() {
 	if !sb.Closed {
 		sb.Closed = true
+		sb.StopBuffer()
 		close(sb.CloseChan)
 		sb.RemoveBufferedFiles(filepath.Join(sb.Stream.Folder, "0.ts"))
 	}
 }
 
-func selectStreamFromMaster(resp io.ReadCloser) (string, string, error) {
-	defer resp.Close()
-
-	scanner := bufio.NewScanner(resp)
-	var videoURL, audioURL string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#EXT-X-STREAM-INF") {
-			scanner.Scan()
-			videoURL = scanner.Text()
+/*
+isHLSResponse reports whether the response contains a HLS playlist.
+It checks the content type first and falls back to the extension of the requested path.
+*/
+func isHLSResponse(resp *http.Response) bool {
+	for _, contentType := range resp.Header.Values("Content-Type") {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			continue
+		}
+		ShowDebug(fmt.Sprintf("Streaming:%s", mediaType), 1)
+		extensions, err := mime.ExtensionsByType(mediaType)
+		if err != nil {
+			continue
 		}
-		if strings.HasPrefix(line, "#EXT-X-MEDIA:TYPE=AUDIO") && strings.Contains(line, "DEFAULT=YES") {
-			parts := strings.Split(line, "URI=\"")
-			if len(parts) > 1 {
-				audioURL = strings.Split(parts[1], "\"")[0]
+		for _, extension := range extensions {
+			if extension == ".m3u" || extension == ".m3u8" {
+				return true
 			}
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return "", "", err
-	}
-	return videoURL, audioURL, nil
+	return strings.EqualFold(path.Ext(resp.Request.URL.Path), ".m3u8")
 }
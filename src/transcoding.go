@@ -0,0 +1,114 @@
+package src
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// TranscodingProfile is a named set of FFmpeg arguments, [URL] is replaced by the stream URL
+type TranscodingProfile struct {
+	Name    string `json:"name"`
+	Options string `json:"options"`
+}
+
+// TranscodingRule selects the profile for the clients with a matching user agent
+type TranscodingRule struct {
+	UserAgent string `json:"userAgent"` // Part of the user agent
+	Profile   string `json:"profile"`
+}
+
+// The profile name is part of the stream ID and of the buffer folder
+var transcodingProfileName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// defaultTranscodingProfiles are created with the settings
+var defaultTranscodingProfiles = []TranscodingProfile{
+	{Name: "passthrough", Options: "-hide_banner -loglevel error -i [URL] -c copy -f mpegts pipe:1"},
+	{Name: "720p-3M", Options: "-hide_banner -loglevel error -i [URL] -vf scale=-2:720 -c:v libx264 -preset veryfast -b:v 3M -maxrate 3M -bufsize 6M -c:a aac -b:a 128k -f mpegts pipe:1"},
+	{Name: "audio-aac-stereo", Options: "-hide_banner -loglevel error -i [URL] -vn -c:a aac -ac 2 -b:a 128k -f mpegts pipe:1"},
+}
+
+func getTranscodingProfile(name string) (TranscodingProfile, bool) {
+	for _, profile := range Settings.TranscodingProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return TranscodingProfile{}, false
+}
+
+// checkTranscodingProfiles validates the profiles before they are saved
+func checkTranscodingProfiles(profiles []interface{}) error {
+	var names = make(map[string]bool)
+	for _, p := range profiles {
+		profile, _ := p.(map[string]interface{})
+		name, _ := profile["name"].(string)
+		options, _ := profile["options"].(string)
+		if !transcodingProfileName.MatchString(name) {
+			return fmt.Errorf("invalid transcoding profile name: %s", name)
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate transcoding profile: %s", name)
+		}
+		if !strings.Contains(options, "[URL]") {
+			return fmt.Errorf("the transcoding profile %s has no [URL] placeholder", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+/*
+selectTranscodingProfile returns the profile for the client. The profile query parameter takes precedence
+over the transcoding.rules, the x-transcoding-profile of the XEPG channel is the default.
+Without a request only the default of the channel is used. Unknown profiles are ignored.
+*/
+func selectTranscodingProfile(streamInfo *StreamInfo, r *http.Request) string {
+	var candidates []string
+	if r != nil {
+		candidates = append(candidates, r.URL.Query().Get("profile"))
+		for _, rule := range Settings.TranscodingRules {
+			if rule.UserAgent != "" && strings.Contains(strings.ToLower(r.UserAgent()), strings.ToLower(rule.UserAgent)) {
+				candidates = append(candidates, rule.Profile)
+				break
+			}
+		}
+	}
+	if xepgChannel, found := getStreamChannel(streamInfo); found {
+		candidates = append(candidates, xepgChannel.XTranscodingProfile)
+	}
+
+	for _, name := range candidates {
+		if name == "" {
+			continue
+		}
+		if _, ok := getTranscodingProfile(name); ok {
+			return name
+		}
+		ShowDebug(fmt.Sprintf("Streaming:Unknown transcoding profile %s", name), 1)
+	}
+	return ""
+}
+
+/*
+applyTranscodingProfile returns a copy of the stream info for the profile. The profile is appended to the URL ID,
+so every profile of the channel runs as a stream with its own buffer, the clients with the same profile share it.
+*/
+func applyTranscodingProfile(streamInfo *StreamInfo, profile string) *StreamInfo {
+	if profile == "" {
+		return streamInfo
+	}
+	var info = *streamInfo
+	info.Profile = profile
+	info.URLid = streamInfo.URLid + "-" + profile
+	return &info
+}
+
+// channelURLid returns the URL ID of the channel without the transcoding profile
+func (s *StreamInfo) channelURLid() string {
+	if s.Profile == "" {
+		return s.URLid
+	}
+	return strings.TrimSuffix(s.URLid, "-"+s.Profile)
+}
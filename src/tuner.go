@@ -0,0 +1,225 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TunerPool limits the streams of several playlists that share one provider account (tuner.pools)
+type TunerPool struct {
+	Name      string   `json:"name"`
+	Limit     int      `json:"limit"`
+	Playlists []string `json:"playlists"` // Playlist IDs or names
+}
+
+/*
+ClientPriority assigns a priority to the clients that match all given fields (tuner.priorities).
+A client with a higher priority can take the tuner of a stream whose clients all have a lower priority.
+*/
+type ClientPriority struct {
+	IP        string `json:"ip,omitempty"`        // IP address or CIDR
+	UserAgent string `json:"userAgent,omitempty"` // Part of the user agent
+	User      string `json:"user,omitempty"`      // Threadfin user, authenticated with basic auth
+	Priority  int    `json:"priority"`
+}
+
+// idleStreamPriority is the priority of a stream without clients, it can always be taken over
+const idleStreamPriority = math.MinInt32
+
+type clientPriorityKey struct{}
+
+// withClientPriority sets the priority for internal clients like recordings
+func withClientPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, clientPriorityKey{}, priority)
+}
+
+type tunerLimit struct {
+	name      string
+	limit     int
+	playlists []string // nil for the global limit
+}
+
+/*
+getTunerLimits returns all limits a new stream of the playlist has to respect:
+the tuner setting of the playlist, the tuner pools of the playlist and the global limit
+*/
+func getTunerLimits(playlistID string) (limits []tunerLimit) {
+	var playlistName = getProviderParameter(playlistID, GetPlaylistType(playlistID), "name")
+	limits = append(limits, tunerLimit{name: playlistName, limit: GetTuner(playlistID, GetPlaylistType(playlistID)), playlists: []string{playlistID}})
+
+	for _, pool := range Settings.TunerPools {
+		if pool.Limit <= 0 {
+			continue
+		}
+		for _, p := range pool.Playlists {
+			if p == playlistID || (playlistName != "" && p == playlistName) {
+				limits = append(limits, tunerLimit{name: pool.Name, limit: pool.Limit, playlists: pool.Playlists})
+				break
+			}
+		}
+	}
+
+	if Settings.TunerGlobal > 0 {
+		limits = append(limits, tunerLimit{name: "global", limit: Settings.TunerGlobal})
+	}
+	return
+}
+
+// counts reports whether the streams of the playlist count against the limit
+func (l tunerLimit) counts(playlistID string) bool {
+	if l.playlists == nil {
+		return true
+	}
+	var playlistName = getProviderParameter(playlistID, GetPlaylistType(playlistID), "name")
+	for _, p := range l.playlists {
+		if p == playlistID || (playlistName != "" && p == playlistName) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+usedTuners returns the number of upstream connections that count against the limit.
+The tuner limit video and the excluded stream don't count.
+*/
+func (sm *StreamManager) usedTuners(limit tunerLimit, exclude *Stream) (used int) {
+	for playlistID, playlist := range sm.Playlists {
+		if !limit.counts(playlistID) {
+			continue
+		}
+		for streamID, stream := range playlist.Streams {
+			if streamID != "TunerLimitReached" && stream != nil && stream != exclude {
+				used++
+			}
+		}
+	}
+	return
+}
+
+// isNewStreamPossibleWithout reports whether the stream could be started if the excluded stream would be stopped
+func (sm *StreamManager) isNewStreamPossibleWithout(streamInfo *StreamInfo, exclude *Stream) bool {
+	for _, limit := range getTunerLimits(streamInfo.PlaylistID) {
+		if sm.usedTuners(limit, exclude) >= limit.limit {
+			ShowDebug(fmt.Sprintf("Streaming:Tuner limit of %s reached (%d)", limit.name, limit.limit), 2)
+			return false
+		}
+	}
+	return true
+}
+
+// Priority returns the highest priority of the clients of the stream
+func (s *Stream) Priority() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var priority = idleStreamPriority
+	for _, client := range s.Clients {
+		priority = max(priority, client.priority)
+	}
+	return priority
+}
+
+/*
+findPreemptableStream returns the stream with the lowest priority below the given one,
+whose tuner would allow the new stream to start. The caller must hold the lock.
+*/
+func (sm *StreamManager) findPreemptableStream(streamInfo *StreamInfo, priority int) (playlistID, streamID string, found bool) {
+	var victim *Stream
+	for pID, playlist := range sm.Playlists {
+		for sID, stream := range playlist.Streams {
+			if sID == "TunerLimitReached" || stream == nil || stream.Priority() >= priority {
+				continue
+			}
+			if !sm.isNewStreamPossibleWithout(streamInfo, stream) {
+				continue
+			}
+			if victim == nil || stream.Priority() < victim.Priority() || (stream.Priority() == victim.Priority() && stream.clientCount() < victim.clientCount()) {
+				victim, playlistID, streamID, found = stream, pID, sID, true
+			}
+		}
+	}
+	return
+}
+
+/*
+preemptStream stops the stream with the lowest priority, so the stream with the given priority can be started.
+The caller must hold the lock.
+*/
+func (sm *StreamManager) preemptStream(streamInfo *StreamInfo, priority int) bool {
+	playlistID, streamID, found := sm.findPreemptableStream(streamInfo, priority)
+	if !found {
+		return false
+	}
+
+	stream := sm.Playlists[playlistID].Streams[streamID]
+	if stream.Priority() == idleStreamPriority {
+		ShowInfo(fmt.Sprintf("Streaming:Releasing idle stream %s for %s", stream.Name, streamInfo.Name))
+	} else {
+		ShowInfo(fmt.Sprintf("Streaming:Preempting %s (priority %d) for %s (priority %d)", stream.Name, stream.Priority(), streamInfo.Name, priority))
+	}
+
+	for clientID, client := range stream.removeAllClients() {
+		client.Disconnect()
+		stream.recordSession(streamID, clientID, client)
+	}
+	sm.removeStream(playlistID, streamID, stream)
+	return true
+}
+
+/*
+getClientPriority returns the priority of the client from the matching tuner.priorities rule with the highest priority.
+Clients without a matching rule have the priority 0.
+*/
+func getClientPriority(r *http.Request) int {
+	if priority, ok := r.Context().Value(clientPriorityKey{}).(int); ok {
+		return priority
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	var username string
+	var authenticated bool
+
+	var priority int
+	var matched bool
+	for _, rule := range Settings.TunerPriorities {
+		if rule.IP != "" && !matchClientIP(rule.IP, ip) {
+			continue
+		}
+		if rule.UserAgent != "" && !strings.Contains(strings.ToLower(r.UserAgent()), strings.ToLower(rule.UserAgent)) {
+			continue
+		}
+		if rule.User != "" {
+			if !authenticated {
+				if _, _, ok := r.BasicAuth(); ok {
+					if username, err = basicAuth(r, "authentication.m3u"); err != nil {
+						username = ""
+					}
+				}
+				authenticated = true
+			}
+			if rule.User != username {
+				continue
+			}
+		}
+		if !matched || rule.Priority > priority {
+			priority, matched = rule.Priority, true
+		}
+	}
+	return priority
+}
+
+func matchClientIP(rule, ip string) bool {
+	if _, network, err := net.ParseCIDR(rule); err == nil {
+		return network.Contains(net.ParseIP(ip))
+	}
+	return rule == ip
+}
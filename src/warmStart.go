@@ -0,0 +1,111 @@
+package src
+
+import (
+	"fmt"
+
+	"threadfin/src/internal/mpegts"
+)
+
+/*
+warmStart keeps the broadcasted data since the latest random access point, so a client joining a running
+stream starts with the PAT/PMT and a key frame instead of the middle of a GOP.
+The data is limited to half of the client queue, a longer GOP is not cached.
+*/
+type warmStart struct {
+	randomAccess mpegts.RandomAccess
+	gop          []byte // Complete packets since the latest random access point
+	valid        bool
+	carry        []byte // Start of the packet that is completed by the next data
+}
+
+// add inspects the broadcasted data, the stream is assumed to start at a packet boundary
+func (w *warmStart) add(data []byte) {
+	var limit = Settings.BufferClientSize * 1024 / 2
+
+	if len(w.carry) > 0 {
+		data = append(w.carry, data...)
+		w.carry = nil
+	}
+
+	for len(data) >= mpegts.PacketSize {
+		if data[0] != mpegts.SyncByte {
+			// Lost the packet boundary, search for the next sync byte
+			data = data[1:]
+			continue
+		}
+		packet := data[:mpegts.PacketSize]
+		if w.randomAccess.Packet(packet) {
+			w.gop, w.valid = w.gop[:0], true
+		}
+		if w.valid {
+			if len(w.gop)+mpegts.PacketSize > limit {
+				w.gop, w.valid = nil, false
+			} else {
+				w.gop = append(w.gop, packet...)
+			}
+		}
+		data = data[mpegts.PacketSize:]
+	}
+
+	if len(data) > 0 {
+		w.carry = append([]byte{}, data...)
+	}
+}
+
+/*
+data returns the cached PSI tables and the GOP for a new client. The partial packet at the end is part of it,
+the next broadcasted data completes it. Without known PSI tables only the partial packet is returned.
+*/
+func (w *warmStart) data() []byte {
+	psi := w.randomAccess.PSI()
+	if psi == nil {
+		return append([]byte{}, w.carry...)
+	}
+	var data = append(psi, w.gop...)
+	if !w.valid {
+		// Only the tables, the player waits for the next key frame
+		data = psi
+	}
+	return append(data, w.carry...)
+}
+
+// reset drops the cached data after a discontinuity, the PSI tables are kept
+func (w *warmStart) reset() {
+	w.gop, w.valid, w.carry = nil, false, nil
+}
+
+/*
+addClient adds the client to the stream and reports whether it is the first one. A client that joins the running
+broadcast gets the warm start data first. The first client restarts the broadcast from the buffered segments,
+the cached data doesn't continue with it.
+*/
+func (s *Stream) addClient(clientID string, client *Client) (first bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Clients[clientID] = client
+	first = len(s.Clients) == 1
+	switch {
+	case first:
+		s.warm.reset()
+		if s.hls != nil {
+			s.hls.discontinuity()
+		}
+	case !client.hls && !client.timeshift && s.audio == "":
+		if data := s.warm.data(); len(data) > 0 {
+			client.queue.Push(data, Settings.BufferSlowClientPolicy)
+			ShowDebug(fmt.Sprintf("Streaming:Warm start of client %s with %d bytes", clientID, len(data)), 2)
+		}
+	}
+	return first
+}
+
+// resetWarmStart drops the cached data, the broadcast continues with other content
+func (s *Stream) resetWarmStart() {
+	s.mu.Lock()
+	s.warm.reset()
+	if s.hls != nil {
+		s.hls.discontinuity()
+	}
+	s.mu.Unlock()
+}
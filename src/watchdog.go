@@ -0,0 +1,223 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"threadfin/src/internal/mpegts"
+)
+
+const (
+	StreamStallError = 4025 //errMsg = "Upstream stopped sending data"
+
+	watchdogInterval   = time.Second
+	watchdogMaxBackoff = 30 * time.Second
+	watchdogStopWait   = 5 * time.Second // Maximum time to wait for the old input to be released
+)
+
+/*
+InputActivity keeps track of the data the buffer receives from the upstream.
+Reads shorter than one TS packet don't count as progress, so a trickling upstream is detected as stall too.
+*/
+type InputActivity struct {
+	mu       sync.Mutex
+	lastData time.Time
+	received bool // The input delivered data since it has been started
+	err      error
+	errCode  int
+	stopping bool
+	done     chan struct{}
+}
+
+func NewInputActivity() *InputActivity {
+	return &InputActivity{lastData: time.Now()}
+}
+
+// reset is called when a new input is started
+func (a *InputActivity) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastData = time.Now()
+	a.received = false
+	a.err = nil
+	a.errCode = 0
+	a.stopping = false
+}
+
+// begin is called by HandleByteOutput, the returned channel has to be closed when it returns
+func (a *InputActivity) begin() chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.done = make(chan struct{})
+	return a.done
+}
+
+func (a *InputActivity) read(n int) {
+	if n < mpegts.PacketSize {
+		return
+	}
+	a.mu.Lock()
+	a.lastData = time.Now()
+	a.received = true
+	a.mu.Unlock()
+}
+
+// Received reports whether the input delivered data since it has been started
+func (a *InputActivity) Received() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.received
+}
+
+/*
+fail records the error of the input. It reports false if the input has been stopped on purpose,
+or if an error has already been recorded, the first error is the cause of the failure.
+*/
+func (a *InputActivity) fail(err error, errCode int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopping || a.err != nil {
+		return false
+	}
+	a.err, a.errCode = err, errCode
+	return true
+}
+
+// stop marks the input as stopped on purpose and returns the channel of the running HandleByteOutput
+func (a *InputActivity) stop() chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopping = true
+	return a.done
+}
+
+// Status returns the error of the input, or the stall error if there was no progress within the timeout
+func (a *InputActivity) Status(timeout time.Duration) (error, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.stopping:
+		return nil, 0
+	case a.err != nil:
+		return a.err, a.errCode
+	case time.Since(a.lastData) > timeout:
+		return errors.New(getErrMsg(StreamStallError)), StreamStallError
+	}
+	return nil, 0
+}
+
+// waitForInput waits until HandleByteOutput of the stopped input returned
+func waitForInput(done chan struct{}) {
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(watchdogStopWait):
+		ShowDebug("Streaming:Input did not stop in time", 1)
+	}
+}
+
+/*
+watch runs for every buffered stream. When the upstream stalls or fails it switches to the next backup URL,
+or reconnects to the primary URL with exponential backoff. The segments and clients of the stream are kept.
+Meanwhile the clients get the reconnecting slate. Once all reconnects failed, the offline or error slate
+is shown for slate.timeout seconds while the watchdog keeps trying, before the clients are disconnected.
+*/
+func (s *Stream) watch() {
+	var (
+		primaryURL  = s.URL
+		urls        = []string{s.URL, s.BackupChannel1URL, s.BackupChannel2URL, s.BackupChannel3URL}
+		index       int
+		attempts    int
+		lastRecover time.Time
+		slateSince  time.Time // The offline or error slate is shown since
+	)
+
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	defer s.hideSlate()
+
+	for {
+		select {
+		case <-s.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var timeout = time.Duration(Settings.BufferStallTimeout) * time.Second
+		if timeout <= 0 || s.clientCount() == 0 {
+			continue
+		}
+
+		err, errCode := s.Buffer.GetActivity().Status(timeout)
+		if err == nil {
+			if s.Buffer.GetActivity().Received() {
+				s.hideSlate()
+				slateSince = time.Time{}
+			}
+			// The input is healthy again, the next failure starts with a short delay
+			if attempts > 0 && time.Since(lastRecover) > 2*timeout+watchdogMaxBackoff {
+				attempts = 0
+			}
+			continue
+		}
+
+		ShowInfo(fmt.Sprintf("Streaming:Watchdog:%s (%s)", err.Error(), s.Name))
+		s.addEvent("error", err.Error())
+		s.Buffer.StopInput()
+
+		// Try the next backup URL, once all have been tried reconnect to the primary URL
+		var delay time.Duration
+		for index++; index < len(urls) && urls[index] == ""; index++ {
+		}
+		if index >= len(urls) {
+			attempts++
+			if attempts > Settings.BufferReconnectAttempts {
+				if slateSince.IsZero() && Settings.SlateTimeout > 0 && s.showSlate(slateForError(errCode)) {
+					slateSince = time.Now()
+				}
+				if slateSince.IsZero() || time.Since(slateSince) > time.Duration(Settings.SlateTimeout)*time.Second {
+					ShowInfo(fmt.Sprintf("Streaming:Watchdog:Giving up after %d reconnects (%s)", Settings.BufferReconnectAttempts, s.Name))
+					s.ReportError(err, errCode, "", true)
+					return
+				}
+			}
+			index = 0
+			delay = min(time.Second<<(min(attempts, 6)-1), watchdogMaxBackoff)
+		}
+		if slateSince.IsZero() {
+			s.showSlate(SlateReconnecting)
+		}
+
+		// A new URL of the provider is used from the reconnect on
+		if index == 0 && s.refreshURLs() {
+			primaryURL = s.URL
+			urls = []string{s.URL, s.BackupChannel1URL, s.BackupChannel2URL, s.BackupChannel3URL}
+		}
+
+		s.BackupNumber = index
+		s.UseBackup = index > 0
+		if index == 0 {
+			metrics.bufferRestart(s)
+			s.addEvent("reconnect", fmt.Sprintf("Reconnect %d/%d", attempts, Settings.BufferReconnectAttempts))
+			s.URL = primaryURL
+			ShowInfo(fmt.Sprintf("Streaming:Watchdog:Reconnecting to %s in %s (%d/%d)", s.URL, delay, attempts, Settings.BufferReconnectAttempts))
+		} else {
+			s.UpdateStreamURLForBackup()
+		}
+
+		select {
+		case <-s.Ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		lastRecover = time.Now()
+		if err := s.Buffer.StartInput(s); err != nil {
+			s.Buffer.GetActivity().fail(err, ReadIntoBufferError)
+		}
+	}
+}
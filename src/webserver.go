@@ -45,6 +45,7 @@ func (ws *WebServer) StartWebserver() (err error) {
 	serverMux.HandleFunc("/web/", Web)
 	serverMux.HandleFunc("/download/", Download)
 	serverMux.HandleFunc("/api/", API)
+	serverMux.HandleFunc("/metrics", Metrics)
 	serverMux.HandleFunc("/images/", Images)
 	serverMux.HandleFunc("/data_images/", DataImages)
 	serverMux.HandleFunc("/ppv/enable", enablePPV)
@@ -215,9 +216,16 @@ func stream(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
-	var path = strings.Replace(r.RequestURI, "/stream/", "", 1)
+	// The query string contains client options like the time-shift offset
+	var path = strings.Replace(r.URL.Path, "/stream/", "", 1)
 	//var stream = strings.SplitN(path, "-", 2)
 
+	// HLS output: /stream/<urlID>/index.m3u8 and /stream/<urlID>/<n>.ts
+	var hlsFile string
+	if urlID, file, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/stream/"), "/"); found {
+		path, hlsFile = urlID, file
+	}
+
 	streamInfo, err := getStreamInfo(path)
 	if err != nil {
 		ShowError(err, 1203)
@@ -225,85 +233,51 @@ func stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method == "HEAD" {
-		client := &http.Client{}
-		log.Println("URL: ", streamInfo.URL)
-		req, err := http.NewRequest("HEAD", streamInfo.URL, nil)
-		if err != nil {
-			ShowError(err, 1501)
-			httpStatusError(w, http.StatusMethodNotAllowed)
-			return
-		}
-
-		resp, err := client.Do(req)
-		if err != nil {
-			ShowError(err, 1502)
-			httpStatusError(w, http.StatusMethodNotAllowed)
-			return
-		}
-		defer resp.Body.Close()
+	// The XEPG channel is looked up once for the request
+	streamInfo = streamInfo.withChannel()
 
-		// Copy headers from the source HEAD response to the outgoing response
-		log.Println("HEAD response from source: ", resp.Header)
-		for key, values := range resp.Header {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
-		}
+	// Every transcoding profile of the channel runs as its own stream
+	streamInfo = applyTranscodingProfile(streamInfo, selectTranscodingProfile(streamInfo, r))
+	streamInfo = markRadioStream(streamInfo)
 
-		return
-	}
+	// The buffer of the channel or provider overrides the global setting
+	var bufferConfig = getBufferConfig(streamInfo)
 
-	if Settings.ForceHttpsToUpstream {
-		var u *url.URL
-		u, err = url.Parse(streamInfo.URL)
-		setProtocol(streamInfo, u, err)
-		if streamInfo.BackupChannel1URL != "" {
-			u, err = url.Parse(streamInfo.BackupChannel1URL)
-			setProtocol(streamInfo, u, err)
-		}
-		if streamInfo.BackupChannel1URL != "" {
-			u, err = url.Parse(streamInfo.BackupChannel2URL)
-			setProtocol(streamInfo, u, err)
+	if hlsFile != "" {
+		if bufferConfig.Type == "-" {
+			httpStatusError(w, http.StatusNotFound)
+			return
 		}
-		if streamInfo.BackupChannel1URL != "" {
-			u, err = url.Parse(streamInfo.BackupChannel3URL)
-			setProtocol(streamInfo, u, err)
+		// Requests of a running session don't need to go through the stream setup again
+		if filepath.Ext(hlsFile) == ".ts" || streamManager.HasHLSSession(streamInfo, r) {
+			streamManager.ServeHLS(streamInfo, hlsFile, w, r)
+			return
 		}
 	}
 
-	// If an UDPxy host is set, and the stream URL is multicast (i.e. starts with 'udp://@'),
-	// then streamInfo.URL needs to be rewritten to point to UDPxy.
-	if Settings.UDPxy != "" && strings.HasPrefix(streamInfo.URL, "udp://@") {
-		streamInfo.URL = fmt.Sprintf("http://%s/udp/%s/", Settings.UDPxy, strings.TrimPrefix(streamInfo.URL, "udp://@"))
+	// The cached stream info keeps the URLs of the provider
+	var info = *streamInfo
+	streamInfo = &info
+	rewriteUpstreamURLs(streamInfo)
+
+	// HEAD and OPTIONS requests don't open a connection to the streaming server
+	if r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		serveStreamHead(w, r, streamInfo, bufferConfig, hlsFile)
+		return
 	}
 
-	switch Settings.Buffer {
+	switch bufferConfig.Type {
 
 	case "-":
-		ShowInfo(fmt.Sprintf("Buffer:false [%s]", Settings.Buffer))
-
-	case "threadfin":
-		if strings.Contains(streamInfo.URL, "rtsp://") || strings.Contains(streamInfo.URL, "rtp://") {
-			err = errors.New("RTSP and RTP streams are not supported")
-			ShowError(err, 2004)
-
-			ShowInfo("Streaming URL:" + streamInfo.URL)
-			http.Redirect(w, r, streamInfo.URL, http.StatusFound)
-
-			ShowInfo("Streaming Info:URL was passed to the client")
-			return
-		}
-
-		ShowInfo(fmt.Sprintf("Buffer:true [%s]", Settings.Buffer))
+		ShowInfo(fmt.Sprintf("Buffer:false [%s]", bufferConfig.Type))
 
 	default:
-		ShowInfo(fmt.Sprintf("Buffer:true [%s]", Settings.Buffer))
+		ShowInfo(fmt.Sprintf("Buffer:true [%s]", bufferConfig.Type))
 
 	}
 
-	if Settings.Buffer != "-" {
-		ShowInfo(fmt.Sprintf("Buffer Size:%d KB", Settings.BufferSize))
+	if bufferConfig.Type != "-" {
+		ShowInfo(fmt.Sprintf("Buffer Size:%d KB", bufferConfig.Size))
 	}
 
 	log.Println("Stream Info: ", streamInfo)
@@ -313,7 +287,7 @@ func stream(w http.ResponseWriter, r *http.Request) {
 	ShowInfo(fmt.Sprintf("Client User-Agent:%s", r.Header.Get("User-Agent")))
 
 	// Prüfen ob der Buffer verwendet werden soll
-	switch Settings.Buffer {
+	switch bufferConfig.Type {
 
 	case "-":
 		providerSettings, ok := Settings.Files.M3U[streamInfo.PlaylistID].(map[string]interface{})
@@ -373,21 +347,49 @@ func stream(w http.ResponseWriter, r *http.Request) {
 		}
 
 	default:
-		w.Header().Set("Content-Type", "video/mp2t")
-		w.Header().Set("Cache-Control", "max-age=3600")
-		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("Keep-Alive", "timeout=10, max=100")
+		if hlsFile != "" {
+			streamManager.ServeHLS(streamInfo, hlsFile, w, r)
+			return
+		}
+		setStreamHeaders(w)
 		streamManager.ServeStream(streamInfo, w, r)
 	}
 }
 
+// rewriteUpstreamURLs applies force https and UDPxy to the URLs of the stream info
+func rewriteUpstreamURLs(streamInfo *StreamInfo) {
+	if Settings.ForceHttpsToUpstream {
+		u, err := url.Parse(streamInfo.URL)
+		setProtocol(streamInfo, u, err)
+		if streamInfo.BackupChannel1URL != "" {
+			u, err = url.Parse(streamInfo.BackupChannel1URL)
+			setProtocol(streamInfo, u, err)
+		}
+		if streamInfo.BackupChannel1URL != "" {
+			u, err = url.Parse(streamInfo.BackupChannel2URL)
+			setProtocol(streamInfo, u, err)
+		}
+		if streamInfo.BackupChannel1URL != "" {
+			u, err = url.Parse(streamInfo.BackupChannel3URL)
+			setProtocol(streamInfo, u, err)
+		}
+	}
+
+	// If an UDPxy host is set, and the stream URL is multicast (i.e. starts with 'udp://@'),
+	// then streamInfo.URL needs to be rewritten to point to UDPxy.
+	// Without UDPxy the Threadfin buffer joins the multicast group itself.
+	if Settings.UDPxy != "" && strings.HasPrefix(streamInfo.URL, "udp://@") {
+		streamInfo.URL = fmt.Sprintf("http://%s/udp/%s/", Settings.UDPxy, strings.TrimPrefix(streamInfo.URL, "udp://@"))
+	}
+}
+
 func setProtocol(streamInfo *StreamInfo, u *url.URL, err error) {
 	if err == nil {
 		switch u.Scheme {
 		case "http":
 			u.Scheme = "https"
 			streamInfo.URL = u.String()
-		case "https", "rtsp", "rtp":
+		case "https", "rtsp", "rtp", "udp":
 			return
 		default:
 			ShowInfo(fmt.Sprintf("Streaming: Unknown protocol: %s", u.Scheme))
@@ -780,6 +782,29 @@ func WS(w http.ResponseWriter, r *http.Request) {
 				ShowDebug("Sucessfully uploaded custom image", 1)
 			}
 
+		case "uploadSlate":
+			if len(request.Base64) > 0 {
+				err = uploadSlate(request.Slate, request.Base64, request.Filename)
+			}
+
+		case "deleteSlate":
+			err = deleteSlate(request.Slate)
+
+		case "getClients":
+			response.Clients = streamManager.GetClients()
+
+		case "kickClient":
+			err = streamManager.KickClient(request.ClientID)
+			if err == nil {
+				response.Clients = streamManager.GetClients()
+			}
+
+		case "stopStream":
+			err = streamManager.TerminateStream(request.PlaylistID, request.StreamID)
+			if err == nil {
+				response.Clients = streamManager.GetClients()
+			}
+
 		case "changeVersion":
 			System.Beta = !System.Beta // Toggle Beta
 			BinaryUpdate(true)
@@ -1196,6 +1221,91 @@ func API(w http.ResponseWriter, r *http.Request) {
 		}
 	case "updateXEPG":
 		buildXEPG(false)
+	case "getClients":
+		response.Clients = streamManager.GetClients()
+	case "kickClient":
+		if err = streamManager.KickClient(request.ClientID); err != nil {
+			responseAPIError(err, http.StatusNotFound)
+			return
+		}
+	case "stopStream":
+		if err = streamManager.TerminateStream(request.PlaylistID, request.StreamID); err != nil {
+			responseAPIError(err, http.StatusNotFound)
+			return
+		}
+	case "getRecordings":
+		response.Recordings, response.RecordingRules = recorder.GetRecordings()
+	case "addRecording":
+		if request.Recording == nil {
+			responseAPIError(errors.New(getErrMsg(4200)), http.StatusBadRequest)
+			return
+		}
+		recording, err := recorder.AddRecording(*request.Recording)
+		if err != nil {
+			responseAPIError(err, http.StatusBadRequest)
+			return
+		}
+		response.Recordings = []*Recording{recording}
+	case "cancelRecording", "deleteRecording":
+		if request.Recording == nil {
+			responseAPIError(errors.New(getErrMsg(4200)), http.StatusBadRequest)
+			return
+		}
+		if request.Cmd == "cancelRecording" {
+			err = recorder.CancelRecording(request.Recording.ID)
+		} else {
+			err = recorder.DeleteRecording(request.Recording.ID)
+		}
+		if err != nil {
+			responseAPIError(err, http.StatusNotFound)
+			return
+		}
+	case "addRecordingRule":
+		if request.RecordingRule == nil {
+			responseAPIError(errors.New(getErrMsg(4206)), http.StatusBadRequest)
+			return
+		}
+		rule, err := recorder.AddRecordingRule(*request.RecordingRule)
+		if err != nil {
+			responseAPIError(err, http.StatusBadRequest)
+			return
+		}
+		response.RecordingRules = []*RecordingRule{rule}
+	case "deleteRecordingRule":
+		if request.RecordingRule == nil {
+			responseAPIError(errors.New(getErrMsg(4206)), http.StatusBadRequest)
+			return
+		}
+		err = recorder.DeleteRecordingRule(request.RecordingRule.ID)
+		if err != nil {
+			responseAPIError(err, http.StatusNotFound)
+			return
+		}
+	case "getChannelHealth":
+		response.ChannelHealth = GetChannelHealth()
+	case "getBufferUsage":
+		response.BufferUsage = GetBufferUsage()
+	case "getHistory":
+		response.History = GetHistory(request.Days)
+	case "getHistoryStats":
+		response.HistoryStats = GetHistoryStats(request.Days)
+	case "getSlates":
+		response.Slates = GetSlates()
+	case "uploadSlate", "deleteSlate":
+		if request.Cmd == "uploadSlate" {
+			err = uploadSlate(request.Slate, request.Base64, request.Filename)
+		} else {
+			err = deleteSlate(request.Slate)
+		}
+		if err != nil {
+			responseAPIError(err, http.StatusBadRequest)
+			return
+		}
+		response.Slates = GetSlates()
+	case "scanChannelHealth":
+		if !healthScanner.Start() {
+			ShowInfo("Health:Scan is already running")
+		}
 	default:
 		responseAPIError(errors.New(getErrMsg(5000)), http.StatusBadRequest)
 		return
@@ -48,6 +48,7 @@ func buildXEPG(background bool) {
 
 	System.ScanInProgress = 1
 
+	var buildStart = time.Now()
 	Data.Cache.Images = imgcache.NewImageCache(Settings.CacheImages, System.Folder.ImagesCache, System.BaseURL)
 
 	if Settings.EpgSource == "XEPG" {
@@ -65,6 +66,7 @@ func buildXEPG(background bool) {
 				cleanupXEPG()
 				createXMLTVFile()
 				createM3UFile()
+				metrics.xepgBuild(time.Since(buildStart))
 
 				ShowInfo("XEPG:" + "Ready to use")
 
@@ -110,6 +112,7 @@ func buildXEPG(background bool) {
 			cleanupXEPG()
 			createXMLTVFile()
 			createM3UFile()
+			metrics.xepgBuild(time.Since(buildStart))
 
 			go func() {
 
@@ -436,6 +439,7 @@ func createXEPGDatabase() (err error) {
 			xepgChannel.Name = m3uChannel.Name
 
 			xepgChannel.TvgChno = m3uChannel.TvgChno
+			xepgChannel.Radio = m3uChannel.Radio
 
 			// Kanalname aktualisieren, nur mit Kanal ID's möglich
 			if channelHasUUID {
@@ -490,6 +494,7 @@ func createXEPGDatabase() (err error) {
 			newChannel.TvgID = m3uChannel.TvgID
 			newChannel.TvgLogo = m3uChannel.TvgLogo
 			newChannel.TvgName = m3uChannel.TvgName
+			newChannel.Radio = m3uChannel.Radio
 			newChannel.URL = m3uChannel.URL
 			newChannel.XmltvFile = ""
 			newChannel.XMapping = ""